@@ -53,6 +53,12 @@ type Config struct {
 	// "setuid": impersonate into user nobody (65534), default
 	// "namespace": create a new namespace for fuzzer using CLONE_NEWNS/CLONE_NEWNET/CLONE_NEWPID/etc,
 	//	requires building kernel with CONFIG_NAMESPACES, CONFIG_UTS_NS, CONFIG_USER_NS, CONFIG_PID_NS and CONFIG_NET_NS.
+	// "seccomp": install a seccomp filter that blocks noisy syscalls (reboot, kexec, ptrace attach, kill(-1)),
+	//	requires building kernel with CONFIG_SECCOMP_FILTER.
+
+	// Distribution of executor configurations across fuzzer procs (optional).
+	// By default all procs use Sandbox and Cover with threaded and collide modes.
+	Exec_Configs []ExecConfig
 
 	Machine_Type string // GCE machine type (e.g. "n1-highcpu-2")
 
@@ -67,6 +73,15 @@ type Config struct {
 	Leak      bool // do memory leak checking
 	Reproduce bool // reproduce, localize and minimize crashers (on by default)
 
+	Errno_Signal bool // use (syscall, errno) pairs as additional feedback signal
+
+	Descriptions []string // description files or dirs to load at runtime instead of compiled-in descriptions (optional)
+
+	// Directed fuzzing: concentrate fuzzing on these kernel functions and source files
+	// (file names are matched as suffixes of paths in debug info, e.g. "net/ipv4/tcp_input.c").
+	Target_Funcs []string
+	Target_Files []string
+
 	Enable_Syscalls  []string
 	Disable_Syscalls []string
 	Suppressions     []string // don't save reports matching these regexps, but reboot VM after them
@@ -75,6 +90,69 @@ type Config struct {
 	// Implementation details beyond this point.
 	ParsedSuppressions []*regexp.Regexp `json:"-"`
 	ParsedIgnores      []*regexp.Regexp `json:"-"`
+	// Contents of description files (see sys.ReadDescriptions), nil if compiled-in descriptions are used.
+	ParsedDescriptions map[string][]byte `json:"-"`
+}
+
+// ExecConfig is an executor configuration used by a share of fuzzer procs.
+type ExecConfig struct {
+	Weight   int    // relative share of procs with this configuration (default: 1)
+	Sandbox  string // see Config.Sandbox (default: Config.Sandbox)
+	Threaded bool   // use threaded mode in executor (default: true)
+	Collide  bool   // collide syscalls to provoke data races (default: true)
+	Cover    bool   // collect coverage, ignored if Config.Cover is false (default: true)
+}
+
+func (ec *ExecConfig) UnmarshalJSON(data []byte) error {
+	type rawExecConfig ExecConfig // to not recurse into UnmarshalJSON
+	raw := rawExecConfig{
+		Weight:   1,
+		Threaded: true,
+		Collide:  true,
+		Cover:    true,
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*ec = ExecConfig(raw)
+	return nil
+}
+
+// String returns the configuration in the format of -exec_configs fuzzer flag (see ipc.FlagsString).
+func (ec ExecConfig) String() string {
+	res := ec.Sandbox
+	if ec.Threaded {
+		res += ",threaded"
+	}
+	if ec.Collide {
+		res += ",collide"
+	}
+	if ec.Cover {
+		res += ",cover"
+	}
+	return res
+}
+
+// ProcExecConfigs returns executor configurations for procs of VM with the given index.
+// Configurations are assigned round-robin over the whole fleet proportionally to weights,
+// so that the distribution holds even if a VM has fewer procs than there are configurations.
+func (cfg *Config) ProcExecConfigs(index, procs int) []ExecConfig {
+	total := 0
+	for _, ec := range cfg.Exec_Configs {
+		total += ec.Weight
+	}
+	res := make([]ExecConfig, procs)
+	for i := range res {
+		slot := (index*procs + i) % total
+		for _, ec := range cfg.Exec_Configs {
+			if slot < ec.Weight {
+				res[i] = ec
+				break
+			}
+			slot -= ec.Weight
+		}
+	}
+	return res
 }
 
 func Parse(filename string) (*Config, map[int]bool, error) {
@@ -197,9 +275,27 @@ func parse(data []byte) (*Config, map[int]bool, error) {
 		return nil, nil, fmt.Errorf("config param output must contain one of none/stdout/dmesg/file")
 	}
 	switch cfg.Sandbox {
-	case "none", "setuid", "namespace":
+	case "none", "setuid", "namespace", "seccomp":
 	default:
-		return nil, nil, fmt.Errorf("config param sandbox must contain one of none/setuid/namespace")
+		return nil, nil, fmt.Errorf("config param sandbox must contain one of none/setuid/namespace/seccomp")
+	}
+	if len(cfg.Exec_Configs) == 0 {
+		cfg.Exec_Configs = []ExecConfig{{Weight: 1, Threaded: true, Collide: true, Cover: true}}
+	}
+	for i := range cfg.Exec_Configs {
+		ec := &cfg.Exec_Configs[i]
+		if ec.Weight <= 0 {
+			return nil, nil, fmt.Errorf("config param exec_configs: weight must be positive")
+		}
+		if ec.Sandbox == "" {
+			ec.Sandbox = cfg.Sandbox
+		}
+		switch ec.Sandbox {
+		case "none", "setuid", "namespace", "seccomp":
+		default:
+			return nil, nil, fmt.Errorf("config param exec_configs: sandbox must contain one of none/setuid/namespace/seccomp")
+		}
+		ec.Cover = ec.Cover && cfg.Cover
 	}
 
 	wd, err := os.Getwd()
@@ -219,6 +315,19 @@ func parse(data []byte) (*Config, map[int]bool, error) {
 	cfg.Initrd = abs(cfg.Initrd)
 	cfg.Sshkey = abs(cfg.Sshkey)
 	cfg.Bin = abs(cfg.Bin)
+	for i, path := range cfg.Descriptions {
+		cfg.Descriptions[i] = abs(path)
+	}
+
+	if len(cfg.Descriptions) != 0 {
+		cfg.ParsedDescriptions, err = sys.ReadDescriptions(cfg.Descriptions)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := sys.LoadDescriptions(cfg.ParsedDescriptions); err != nil {
+			return nil, nil, fmt.Errorf("failed to load descriptions: %v", err)
+		}
+	}
 
 	syscalls, err := parseSyscalls(cfg)
 	if err != nil {
@@ -398,11 +507,16 @@ func checkUnknownFields(data []byte) (string, error) {
 		"Reproduce",
 		"Sandbox",
 		"Leak",
+		"Errno_Signal",
+		"Descriptions",
+		"Target_Funcs",
+		"Target_Files",
 		"Enable_Syscalls",
 		"Disable_Syscalls",
 		"Suppressions",
 		"Ignores",
 		"Initrd",
+		"Exec_Configs",
 		"Machine_Type",
 		"Odroid_Host_Addr",
 		"Odroid_Slave_Addr",
@@ -4,6 +4,7 @@
 package config
 
 import (
+	"encoding/json"
 	"testing"
 )
 
@@ -14,3 +15,25 @@ func TestUnknown(t *testing.T) {
 		t.Fatalf("unknown field is not detected (%v)", err)
 	}
 }
+
+func TestProcExecConfigs(t *testing.T) {
+	cfg := new(Config)
+	data := `{"exec_configs": [{"sandbox": "namespace", "weight": 3}, {"sandbox": "none", "collide": false}]}`
+	if err := json.Unmarshal([]byte(data), cfg); err != nil {
+		t.Fatal(err)
+	}
+	ns, root := cfg.Exec_Configs[0], cfg.Exec_Configs[1]
+	if ns.String() != "namespace,threaded,collide,cover" || root.String() != "none,threaded,cover" {
+		t.Fatalf("bad configs: %v, %v", ns, root)
+	}
+	// With 2 procs per VM, 2 VMs get 3 namespace procs and 1 root proc.
+	counts := make(map[string]int)
+	for index := 0; index < 2; index++ {
+		for _, ec := range cfg.ProcExecConfigs(index, 2) {
+			counts[ec.Sandbox]++
+		}
+	}
+	if counts["namespace"] != 3 || counts["none"] != 1 {
+		t.Fatalf("bad distribution: %v", counts)
+	}
+}
@@ -180,3 +180,16 @@ func SignalAdd(base map[uint32]struct{}, signal []uint32) {
 		base[s] = struct{}{}
 	}
 }
+
+// ErrnoSignal returns a signal value for the call with ID call returning errno
+// (0 for successful calls). The value is hashed to spread it across the signal space.
+func ErrnoSignal(call, errno int) uint32 {
+	h := uint32(call)<<16 | uint32(errno)&0xffff
+	// Finalization mix of murmur3.
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
@@ -233,3 +233,63 @@ func BenchmarkHasDifference(b *testing.B) {
 		_ = HasDifference(cov1, cov0)
 	}
 }
+
+func TestErrnoSignal(t *testing.T) {
+	seen := make(map[uint32]bool)
+	for call := 0; call < 1000; call++ {
+		for _, errno := range []int{0, 1, 2, 11, 14, 22, 95} {
+			s := ErrnoSignal(call, errno)
+			if seen[s] {
+				t.Fatalf("duplicate signal %x for call %v errno %v", s, call, errno)
+			}
+			seen[s] = true
+			if s1 := ErrnoSignal(call, errno); s1 != s {
+				t.Fatalf("signal is not deterministic: %x vs %x", s, s1)
+			}
+		}
+	}
+}
+
+func TestTargets(t *testing.T) {
+	targets := MakeTargets([]PCRange{
+		{0x300000, 0x300100},
+		{0x100000, 0x100100},
+		{0x100080, 0x100200},
+		{0x500000, 0x500000},
+	})
+	want := Targets{{0x100000, 0x100200}, {0x300000, 0x300100}}
+	if !reflect.DeepEqual(targets, want) {
+		t.Fatalf("got targets %x, want %x", targets, want)
+	}
+	tests := []struct {
+		pc       uint32
+		contains bool
+		near     bool
+	}{
+		{0x0, false, false},
+		{0x100000 - TargetNearDistance - 1, false, false},
+		{0x100000 - TargetNearDistance, false, true},
+		{0x100000, true, true},
+		{0x1001ff, true, true},
+		{0x100200, false, true},
+		{0x100200 + TargetNearDistance, false, false},
+		{0x300050, true, true},
+		{0x400000, false, false},
+		{^uint32(0), false, false},
+	}
+	for _, test := range tests {
+		if got := targets.Contains(test.pc); got != test.contains {
+			t.Errorf("pc 0x%x: contains %v, want %v", test.pc, got, test.contains)
+		}
+		if got := targets.Near(test.pc); got != test.near {
+			t.Errorf("pc 0x%x: near %v, want %v", test.pc, got, test.near)
+		}
+	}
+	cov := []uint32{0x10, 0x100010, 0x100020, 0x100210}
+	if score := targets.Score(cov); score != 2+TargetNearWeight {
+		t.Errorf("got score %v, want %v", score, 2+TargetNearWeight)
+	}
+	if got := targets.Filter(cov); !reflect.DeepEqual(got, []uint32{0x100010, 0x100020, 0x100210}) {
+		t.Errorf("got filtered cover %x", got)
+	}
+}
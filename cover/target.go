@@ -0,0 +1,90 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package cover
+
+import (
+	"sort"
+)
+
+// PCRange is a range [Start, End) of PCs in coverage format (lower 32 bits of PCs).
+type PCRange struct {
+	Start uint32
+	End   uint32
+}
+
+// Targets are PC ranges of directed fuzzing targets (e.g. kernel functions), sorted and non-overlapping.
+type Targets []PCRange
+
+// TargetNearDistance is the distance from a target range at which PCs are considered close to the target.
+// Linker lays out functions of the same object file together, so close PCs are likely in the same subsystem.
+const TargetNearDistance = 64 << 10
+
+// TargetNearWeight is weight of a PC close to a target relative to a PC in a target.
+const TargetNearWeight = 0.1
+
+// MakeTargets sorts ranges and merges overlapping ones.
+func MakeTargets(ranges []PCRange) Targets {
+	var t Targets
+	for _, r := range ranges {
+		if r.Start < r.End {
+			t = append(t, r)
+		}
+	}
+	sort.Sort(t)
+	res := t[:0]
+	for _, r := range t {
+		if len(res) != 0 && r.Start <= res[len(res)-1].End {
+			if r.End > res[len(res)-1].End {
+				res[len(res)-1].End = r.End
+			}
+			continue
+		}
+		res = append(res, r)
+	}
+	return res
+}
+
+func (t Targets) Len() int           { return len(t) }
+func (t Targets) Less(i, j int) bool { return t[i].Start < t[j].Start }
+func (t Targets) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+
+// Contains returns true if pc is in one of the target ranges.
+func (t Targets) Contains(pc uint32) bool {
+	idx := sort.Search(len(t), func(i int) bool { return pc < t[i].End })
+	return idx != len(t) && pc >= t[idx].Start
+}
+
+// Near returns true if pc is in a target range or within TargetNearDistance of one.
+func (t Targets) Near(pc uint32) bool {
+	idx := sort.Search(len(t), func(i int) bool { return pc < t[i].End })
+	if idx != len(t) && (pc >= t[idx].Start || t[idx].Start-pc <= TargetNearDistance) {
+		return true
+	}
+	return idx != 0 && pc-t[idx-1].End < TargetNearDistance
+}
+
+// Score says how close coverage cov gets to the targets:
+// PCs in targets count as 1, PCs near targets count as TargetNearWeight.
+func (t Targets) Score(cov []uint32) float64 {
+	score := 0.0
+	for _, pc := range cov {
+		if t.Contains(pc) {
+			score++
+		} else if t.Near(pc) {
+			score += TargetNearWeight
+		}
+	}
+	return score
+}
+
+// Filter returns PCs of cov that are in or near targets.
+func (t Targets) Filter(cov []uint32) []uint32 {
+	var res []uint32
+	for _, pc := range cov {
+		if t.Near(pc) {
+			res = append(res, pc)
+		}
+	}
+	return res
+}
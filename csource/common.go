@@ -12,6 +12,7 @@ var commonHeader = `
 #include <sys/mman.h>
 #include <sys/mount.h>
 #include <sys/prctl.h>
+#include <sys/ptrace.h>
 #include <sys/resource.h>
 #include <sys/socket.h>
 #include <sys/stat.h>
@@ -20,11 +21,20 @@ var commonHeader = `
 #include <sys/types.h>
 #include <sys/wait.h>
 
+#include <linux/audit.h>
 #include <linux/capability.h>
+#include <linux/filter.h>
 #include <linux/if.h>
+#include <linux/if_ether.h>
 #include <linux/if_tun.h>
+#include <linux/ip.h>
+#include <linux/ipv6.h>
 #include <linux/kvm.h>
+#include <linux/loop.h>
 #include <linux/sched.h>
+#include <linux/seccomp.h>
+#include <linux/tcp.h>
+#include <arpa/inet.h>
 #include <net/if_arp.h>
 
 #include <assert.h>
@@ -61,6 +71,22 @@ __attribute__((noreturn)) void doexit(int status)
 #define _exit use_doexit_instead
 #endif
 
+#if defined(SYZ_EXECUTOR)
+enum fail_reason_t {
+	fail_reason_unknown,
+	fail_reason_mmap,
+	fail_reason_kcov,
+	fail_reason_sandbox,
+	fail_reason_tun,
+	fail_reason_syscall_table,
+};
+
+const uint32_t kFailureMagic = 0xfa11ed00;
+
+static int fail_reason;
+static uint32_t* fail_record;
+#endif
+
 __attribute__((noreturn)) void fail(const char* msg, ...)
 {
 	int e = errno;
@@ -70,7 +96,14 @@ __attribute__((noreturn)) void fail(const char* msg, ...)
 	vfprintf(stderr, msg, args);
 	va_end(args);
 	fprintf(stderr, " (errno %d)\n", e);
-	doexit((e == ENOMEM || e == EAGAIN) ? kRetryStatus : kFailStatus);
+	int status = (e == ENOMEM || e == EAGAIN) ? kRetryStatus : kFailStatus;
+#if defined(SYZ_EXECUTOR)
+	if (status == kFailStatus && fail_record && __atomic_load_n(&fail_record[0], __ATOMIC_ACQUIRE) != kFailureMagic) {
+		fail_record[1] = fail_reason;
+		__atomic_store_n(&fail_record[0], kFailureMagic, __ATOMIC_RELEASE);
+	}
+#endif
+	doexit(status);
 }
 
 #if defined(SYZ_EXECUTOR)
@@ -162,7 +195,37 @@ static void install_segv_handler()
 		*(type*)(addr) = new_val;                                         \
 	}
 
-#ifdef __NR_syz_emit_ethernet
+#if defined(SYZ_EXECUTOR) || defined(SYZ_USE_CHECKSUMS)
+struct csum_inet {
+	uint32_t acc;
+};
+
+static void csum_inet_init(struct csum_inet* csum)
+{
+	csum->acc = 0;
+}
+
+static void csum_inet_update(struct csum_inet* csum, const uint8_t* data, size_t length)
+{
+	if (length == 0)
+		return;
+
+	size_t i;
+	for (i = 0; i < length - 1; i += 2)
+		csum->acc += (uint32_t)data[i] << 8 | data[i + 1];
+	if (length & 1)
+		csum->acc += (uint32_t)data[length - 1] << 8;
+	while (csum->acc > 0xffff)
+		csum->acc = (csum->acc & 0xffff) + (csum->acc >> 16);
+}
+
+static uint16_t csum_inet_digest(struct csum_inet* csum)
+{
+	return ~csum->acc;
+}
+#endif
+
+#if defined(__NR_syz_emit_ethernet) || defined(__NR_syz_extract_tcp_res)
 static void vsnprintf_check(char* str, size_t size, const char* format, va_list args)
 {
 	int rv;
@@ -221,7 +284,7 @@ static void initialize_tun(uint64_t pid)
 		fail("tun: no more than %d executors", MAX_PIDS);
 	int id = pid;
 
-	tunfd = open("/dev/net/tun", O_RDWR);
+	tunfd = open("/dev/net/tun", O_RDWR | O_NONBLOCK);
 	if (tunfd == -1)
 		fail("tun: can't open /dev/net/tun");
 
@@ -260,10 +323,20 @@ static void initialize_tun(uint64_t pid)
 
 static void setup_tun(uint64_t pid, bool enable_tun)
 {
-	if (enable_tun)
-		initialize_tun(pid);
+	if (!enable_tun)
+		return;
+#if defined(SYZ_EXECUTOR)
+	fail_reason = fail_reason_tun;
+#endif
+	initialize_tun(pid);
+#if defined(SYZ_EXECUTOR)
+	fail_reason = fail_reason_sandbox;
+#endif
 }
 
+#endif
+
+#ifdef __NR_syz_emit_ethernet
 static uintptr_t syz_emit_ethernet(uintptr_t a0, uintptr_t a1)
 {
 	if (tunfd < 0)
@@ -275,6 +348,62 @@ static uintptr_t syz_emit_ethernet(uintptr_t a0, uintptr_t a1)
 }
 #endif
 
+#ifdef __NR_syz_extract_tcp_res
+#define SYZ_TUN_MAX_PACKET_SIZE 1000
+
+struct tcp_resources {
+	uint32_t seq;
+	uint32_t ack;
+};
+
+static uintptr_t syz_extract_tcp_res(uintptr_t a0, uintptr_t a1, uintptr_t a2)
+{
+	if (tunfd < 0)
+		return (uintptr_t)-1;
+
+	char data[SYZ_TUN_MAX_PACKET_SIZE];
+	struct tcphdr* tcphdr = NULL;
+	int i;
+	for (i = 0; i < 16 && tcphdr == NULL; i++) {
+		int rv = read(tunfd, data, sizeof(data));
+		if (rv < 0)
+			return (uintptr_t)-1;
+		size_t length = rv;
+		if (length < sizeof(struct ethhdr))
+			continue;
+		struct ethhdr* ethhdr = (struct ethhdr*)data;
+		if (ethhdr->h_proto == htons(ETH_P_IP)) {
+			if (length < sizeof(struct ethhdr) + sizeof(struct iphdr))
+				continue;
+			struct iphdr* iphdr = (struct iphdr*)&data[sizeof(struct ethhdr)];
+			if (iphdr->protocol != IPPROTO_TCP)
+				continue;
+			if (length < sizeof(struct ethhdr) + iphdr->ihl * 4 + sizeof(struct tcphdr))
+				continue;
+			tcphdr = (struct tcphdr*)&data[sizeof(struct ethhdr) + iphdr->ihl * 4];
+		} else if (ethhdr->h_proto == htons(ETH_P_IPV6)) {
+			if (length < sizeof(struct ethhdr) + sizeof(struct ipv6hdr))
+				continue;
+			struct ipv6hdr* ipv6hdr = (struct ipv6hdr*)&data[sizeof(struct ethhdr)];
+			if (ipv6hdr->nexthdr != IPPROTO_TCP)
+				continue;
+			if (length < sizeof(struct ethhdr) + sizeof(struct ipv6hdr) + sizeof(struct tcphdr))
+				continue;
+			tcphdr = (struct tcphdr*)&data[sizeof(struct ethhdr) + sizeof(struct ipv6hdr)];
+		}
+	}
+	if (tcphdr == NULL) {
+		errno = EAGAIN;
+		return (uintptr_t)-1;
+	}
+
+	struct tcp_resources* res = (struct tcp_resources*)a0;
+	NONFAILING(res->seq = htonl(ntohl(tcphdr->seq) + (uint32_t)a1));
+	NONFAILING(res->ack = htonl(ntohl(tcphdr->ack_seq) + (uint32_t)a2));
+	return 0;
+}
+#endif
+
 #ifdef __NR_syz_open_dev
 static uintptr_t syz_open_dev(uintptr_t a0, uintptr_t a1, uintptr_t a2)
 {
@@ -366,6 +495,96 @@ static uintptr_t syz_fuseblk_mount(uintptr_t a0, uintptr_t a1, uintptr_t a2, uin
 }
 #endif
 
+#ifdef __NR_syz_mount_image
+struct fs_image_segment {
+	void* data;
+	uintptr_t size;
+	uintptr_t offset;
+};
+
+#define IMAGE_MAX_SEGMENTS 4096
+#define IMAGE_MAX_SIZE (129 << 20)
+
+static uintptr_t syz_mount_image(uintptr_t a0, uintptr_t a1, uintptr_t a2, uintptr_t a3, uintptr_t a4, uintptr_t a5, uintptr_t a6)
+{
+	char* fs = (char*)a0;
+	char* dir = (char*)a1;
+	uintptr_t size = a2;
+	uintptr_t nsegs = a3;
+	struct fs_image_segment* segs = (struct fs_image_segment*)a4;
+	uint64_t flags = a5;
+	char* opts = (char*)a6;
+
+	if (nsegs > IMAGE_MAX_SEGMENTS)
+		nsegs = IMAGE_MAX_SEGMENTS;
+	if (size > IMAGE_MAX_SIZE)
+		size = IMAGE_MAX_SIZE;
+	int memfd = syscall(__NR_memfd_create, "syz_mount_image", 0);
+	if (memfd == -1)
+		return -1;
+	int err = 0, res = -1, loopfd = -1, loopctl = -1;
+	char loopname[64];
+	uintptr_t i;
+	for (i = 0; i < nsegs; i++) {
+		struct fs_image_segment seg;
+		memset(&seg, 0, sizeof(seg));
+		NONFAILING(memcpy(&seg, &segs[i], sizeof(seg)));
+		seg.offset %= IMAGE_MAX_SIZE;
+		if (seg.size > IMAGE_MAX_SIZE - seg.offset)
+			seg.size = IMAGE_MAX_SIZE - seg.offset;
+		if (size < seg.offset + seg.size)
+			size = seg.offset + seg.size;
+		if (pwrite(memfd, seg.data, seg.size, seg.offset) < 0) {
+		}
+	}
+	if (ftruncate(memfd, size)) {
+		err = errno;
+		goto error;
+	}
+	loopctl = open("/dev/loop-control", O_RDWR);
+	if (loopctl == -1) {
+		err = errno;
+		goto error;
+	}
+	for (i = 0;; i++) {
+		int loopnum = ioctl(loopctl, LOOP_CTL_GET_FREE);
+		if (loopnum < 0) {
+			err = errno;
+			goto error;
+		}
+		sprintf(loopname, "/dev/loop%d", loopnum);
+		loopfd = open(loopname, O_RDWR);
+		if (loopfd == -1) {
+			err = errno;
+			goto error;
+		}
+		if (ioctl(loopfd, LOOP_SET_FD, memfd) == 0)
+			break;
+		err = errno;
+		close(loopfd);
+		loopfd = -1;
+		if (err != EBUSY || i == 10)
+			goto error;
+	}
+	mkdir(dir, 0777);
+	if (syscall(SYS_mount, loopname, dir, fs, flags, opts)) {
+		err = errno;
+		ioctl(loopfd, LOOP_CLR_FD, 0);
+		goto error;
+	}
+	ioctl(loopfd, LOOP_CLR_FD, 0);
+	res = 0;
+error:
+	if (loopfd != -1)
+		close(loopfd);
+	if (loopctl != -1)
+		close(loopctl);
+	close(memfd);
+	errno = err;
+	return res;
+}
+#endif
+
 #ifdef __NR_syz_kvm_setup_cpu
 #if defined(__x86_64__)
 
@@ -1357,6 +1576,14 @@ static uintptr_t execute_syscall(int nr, uintptr_t a0, uintptr_t a1, uintptr_t a
 	case __NR_syz_emit_ethernet:
 		return syz_emit_ethernet(a0, a1);
 #endif
+#ifdef __NR_syz_extract_tcp_res
+	case __NR_syz_extract_tcp_res:
+		return syz_extract_tcp_res(a0, a1, a2);
+#endif
+#ifdef __NR_syz_mount_image
+	case __NR_syz_mount_image:
+		return syz_mount_image(a0, a1, a2, a3, a4, a5, a6);
+#endif
 #ifdef __NR_syz_kvm_setup_cpu
 	case __NR_syz_kvm_setup_cpu:
 		return syz_kvm_setup_cpu(a0, a1, a2, a3, a4, a5, a6, a7);
@@ -1414,7 +1641,7 @@ static int do_sandbox_none(int executor_pid, bool enable_tun)
 		return pid;
 
 	sandbox_common();
-#ifdef __NR_syz_emit_ethernet
+#if defined(__NR_syz_emit_ethernet) || defined(__NR_syz_extract_tcp_res)
 	setup_tun(executor_pid, enable_tun);
 #endif
 
@@ -1431,7 +1658,7 @@ static int do_sandbox_setuid(int executor_pid, bool enable_tun)
 		return pid;
 
 	sandbox_common();
-#ifdef __NR_syz_emit_ethernet
+#if defined(__NR_syz_emit_ethernet) || defined(__NR_syz_extract_tcp_res)
 	setup_tun(executor_pid, enable_tun);
 #endif
 
@@ -1486,7 +1713,7 @@ static int namespace_sandbox_proc(void* arg)
 	if (!write_file("/proc/self/gid_map", "0 %d 1\n", real_gid))
 		fail("write of /proc/self/gid_map failed");
 
-#ifdef __NR_syz_emit_ethernet
+#if defined(__NR_syz_emit_ethernet) || defined(__NR_syz_extract_tcp_res)
 	setup_tun(epid, etun);
 #endif
 
@@ -1545,6 +1772,71 @@ static int do_sandbox_namespace(int executor_pid, bool enable_tun)
 }
 #endif
 
+#if defined(SYZ_EXECUTOR) || defined(SYZ_SANDBOX_SECCOMP)
+#if defined(__x86_64__)
+#define SECCOMP_AUDIT_ARCH AUDIT_ARCH_X86_64
+#elif defined(__aarch64__)
+#define SECCOMP_AUDIT_ARCH AUDIT_ARCH_AARCH64
+#elif defined(__ppc64__) || defined(__PPC64__) || defined(__powerpc64__)
+#define SECCOMP_AUDIT_ARCH AUDIT_ARCH_PPC64LE
+#endif
+
+#define SECCOMP_LOAD(field) BPF_STMT(BPF_LD | BPF_W | BPF_ABS, offsetof(struct seccomp_data, field))
+#define SECCOMP_RET(val) BPF_STMT(BPF_RET | BPF_K, val)
+#define SECCOMP_DENY SECCOMP_RET(SECCOMP_RET_ERRNO | EPERM)
+
+static void install_seccomp_filter()
+{
+	struct sock_filter filter[] = {
+		SECCOMP_LOAD(arch),
+		BPF_JUMP(BPF_JMP | BPF_JEQ | BPF_K, SECCOMP_AUDIT_ARCH, 1, 0),
+		SECCOMP_DENY,
+		SECCOMP_LOAD(nr),
+#if defined(__x86_64__)
+		BPF_JUMP(BPF_JMP | BPF_JGE | BPF_K, 0x40000000, 0, 1),
+		SECCOMP_DENY,
+#endif
+		BPF_JUMP(BPF_JMP | BPF_JEQ | BPF_K, __NR_reboot, 2, 0),
+		BPF_JUMP(BPF_JMP | BPF_JEQ | BPF_K, __NR_kexec_load, 1, 0),
+		BPF_JUMP(BPF_JMP | BPF_JEQ | BPF_K, __NR_kexec_file_load, 0, 1),
+		SECCOMP_DENY,
+		BPF_JUMP(BPF_JMP | BPF_JEQ | BPF_K, __NR_ptrace, 0, 4),
+		SECCOMP_LOAD(args[0]),
+		BPF_JUMP(BPF_JMP | BPF_JEQ | BPF_K, PTRACE_ATTACH, 1, 0),
+		BPF_JUMP(BPF_JMP | BPF_JEQ | BPF_K, PTRACE_SEIZE, 0, 5),
+		SECCOMP_DENY,
+		BPF_JUMP(BPF_JMP | BPF_JEQ | BPF_K, __NR_kill, 0, 3),
+		SECCOMP_LOAD(args[0]),
+		BPF_JUMP(BPF_JMP | BPF_JEQ | BPF_K, (uint32_t)-1, 0, 1),
+		SECCOMP_DENY,
+		SECCOMP_RET(SECCOMP_RET_ALLOW),
+	};
+	struct sock_fprog prog = {};
+	prog.len = sizeof(filter) / sizeof(filter[0]);
+	prog.filter = filter;
+	if (prctl(PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0))
+		fail("prctl(PR_SET_NO_NEW_PRIVS) failed");
+	if (prctl(PR_SET_SECCOMP, SECCOMP_MODE_FILTER, &prog))
+		fail("prctl(PR_SET_SECCOMP) failed");
+}
+
+static int do_sandbox_seccomp(int executor_pid, bool enable_tun)
+{
+	int pid = fork();
+	if (pid)
+		return pid;
+
+	sandbox_common();
+#if defined(__NR_syz_emit_ethernet) || defined(__NR_syz_extract_tcp_res)
+	setup_tun(executor_pid, enable_tun);
+#endif
+	install_seccomp_filter();
+
+	loop();
+	doexit(1);
+}
+#endif
+
 #if defined(SYZ_EXECUTOR) || defined(SYZ_REPEAT)
 static void remove_dir(const char* dir)
 {
@@ -32,7 +32,7 @@ type Options struct {
 
 func Write(p *prog.Prog, opts Options) ([]byte, error) {
 	exec := make([]byte, prog.ExecBufferSize)
-	if err := p.SerializeForExec(exec, 0); err != nil {
+	if _, err := p.SerializeForExec(exec, 0); err != nil {
 		return nil, fmt.Errorf("failed to serialize program: %v", err)
 	}
 	w := new(bytes.Buffer)
@@ -54,19 +54,24 @@ func Write(p *prog.Prog, opts Options) ([]byte, error) {
 	if _, ok := handled["syz_emit_ethernet"]; ok {
 		enableTun = "true"
 	}
+	if _, ok := handled["syz_extract_tcp_res"]; ok {
+		enableTun = "true"
+	}
+
+	calls, nvar, useChecksums := generateCalls(exec)
+	delays := callDelays(p)
 
-	hdr, err := preprocessCommonHeader(opts, handled)
+	hdr, err := preprocessCommonHeader(opts, handled, useChecksums)
 	if err != nil {
 		return nil, err
 	}
 	fmt.Fprint(w, hdr)
 	fmt.Fprint(w, "\n")
 
-	calls, nvar := generateCalls(exec)
 	fmt.Fprintf(w, "long r[%v];\n", nvar)
 
 	if !opts.Repeat {
-		generateTestFunc(w, opts, calls, "loop")
+		generateTestFunc(w, opts, calls, delays, "loop")
 
 		fmt.Fprint(w, "int main()\n{\n")
 		fmt.Fprintf(w, "\tsetup_main_process();\n")
@@ -75,7 +80,7 @@ func Write(p *prog.Prog, opts Options) ([]byte, error) {
 		fmt.Fprint(w, "\twhile (waitpid(pid, &status, __WALL) != pid) {}\n")
 		fmt.Fprint(w, "\treturn 0;\n}\n")
 	} else {
-		generateTestFunc(w, opts, calls, "test")
+		generateTestFunc(w, opts, calls, delays, "test")
 		if opts.Procs <= 1 {
 			fmt.Fprint(w, "int main()\n{\n")
 			fmt.Fprintf(w, "\tsetup_main_process();\n")
@@ -111,7 +116,27 @@ func Write(p *prog.Prog, opts Options) ([]byte, error) {
 	return out, nil
 }
 
-func generateTestFunc(w io.Writer, opts Options, calls []string, name string) {
+// callDelays returns delays (in microseconds) between starts of consecutive calls
+// in threaded mode, or nil if the program does not have races.
+// Executor waits for a non-racing call for up to 20ms, for reproducers 10ms is enough.
+func callDelays(p *prog.Prog) []uint64 {
+	var delays []uint64
+	races := false
+	for _, c := range p.Calls {
+		if c.Race {
+			races = true
+			delays = append(delays, c.RaceDelay)
+		} else {
+			delays = append(delays, 10000)
+		}
+	}
+	if !races {
+		return nil
+	}
+	return delays
+}
+
+func generateTestFunc(w io.Writer, opts Options, calls []string, delays []uint64, name string) {
 	if !opts.Threaded && !opts.Collide {
 		fmt.Fprintf(w, "void %v()\n{\n", name)
 		if opts.Repro {
@@ -134,7 +159,9 @@ func generateTestFunc(w io.Writer, opts Options, calls []string, name string) {
 		fmt.Fprintf(w, "\treturn 0;\n}\n\n")
 
 		fmt.Fprintf(w, "void %v()\n{\n", name)
-		fmt.Fprintf(w, "\tlong i;\n")
+		if delays == nil || opts.Collide {
+			fmt.Fprintf(w, "\tlong i;\n")
+		}
 		fmt.Fprintf(w, "\tpthread_t th[%v];\n", 2*len(calls))
 		fmt.Fprintf(w, "\n")
 		if opts.Repro {
@@ -142,10 +169,20 @@ func generateTestFunc(w io.Writer, opts Options, calls []string, name string) {
 		}
 		fmt.Fprintf(w, "\tmemset(r, -1, sizeof(r));\n")
 		fmt.Fprintf(w, "\tsrand(getpid());\n")
-		fmt.Fprintf(w, "\tfor (i = 0; i < %v; i++) {\n", len(calls))
-		fmt.Fprintf(w, "\t\tpthread_create(&th[i], 0, thr, (void*)i);\n")
-		fmt.Fprintf(w, "\t\tusleep(10000);\n")
-		fmt.Fprintf(w, "\t}\n")
+		if delays == nil {
+			fmt.Fprintf(w, "\tfor (i = 0; i < %v; i++) {\n", len(calls))
+			fmt.Fprintf(w, "\t\tpthread_create(&th[i], 0, thr, (void*)i);\n")
+			fmt.Fprintf(w, "\t\tusleep(10000);\n")
+			fmt.Fprintf(w, "\t}\n")
+		} else {
+			// Racing calls are started with the same delays as in executor.
+			for i := range calls {
+				fmt.Fprintf(w, "\tpthread_create(&th[%v], 0, thr, (void*)%vl);\n", i, i)
+				if delays[i] != 0 {
+					fmt.Fprintf(w, "\tusleep(%v);\n", delays[i])
+				}
+			}
+		}
 		if opts.Collide {
 			fmt.Fprintf(w, "\tfor (i = 0; i < %v; i++) {\n", len(calls))
 			fmt.Fprintf(w, "\t\tpthread_create(&th[%v+i], 0, thr, (void*)i);\n", len(calls))
@@ -158,7 +195,7 @@ func generateTestFunc(w io.Writer, opts Options, calls []string, name string) {
 	}
 }
 
-func generateCalls(exec []byte) ([]string, int) {
+func generateCalls(exec []byte) ([]string, int, bool) {
 	read := func() uintptr {
 		if len(exec) < 8 {
 			panic("exec program overflow")
@@ -180,6 +217,8 @@ func generateCalls(exec []byte) ([]string, int) {
 	}
 	lastCall := 0
 	seenCall := false
+	useChecksums := false
+	csumSeq := 0
 	var calls []string
 	w := new(bytes.Buffer)
 	newCall := func() {
@@ -226,6 +265,34 @@ loop:
 					esc = append(esc, '\\', 'x', hex(v>>4), hex(v<<4>>4))
 				}
 				fmt.Fprintf(w, "\tNONFAILING(memcpy((void*)0x%x, \"%s\", %v));\n", addr, esc, size)
+			case prog.ExecArgCsum:
+				if kind := read(); kind != prog.ExecArgCsumInet {
+					panic(fmt.Sprintf("bad checksum kind %v", kind))
+				}
+				useChecksums = true
+				csumSeq++
+				fmt.Fprintf(w, "\tstruct csum_inet csum_%v;\n", csumSeq)
+				fmt.Fprintf(w, "\tcsum_inet_init(&csum_%v);\n", csumSeq)
+				nchunks := read()
+				for i := uintptr(0); i < nchunks; i++ {
+					kind := read()
+					value := read()
+					chunkSize := read()
+					switch kind {
+					case prog.ExecArgCsumChunkData:
+						fmt.Fprintf(w, "\tNONFAILING(csum_inet_update(&csum_%v, (const uint8_t*)0x%x, %v));\n", csumSeq, value, chunkSize)
+					case prog.ExecArgCsumChunkConst:
+						// Const chunks are big-endian.
+						var esc []byte
+						for j := chunkSize; j > 0; j-- {
+							esc = append(esc, fmt.Sprintf("\\x%02x", byte(value>>(8*(j-1))))...)
+						}
+						fmt.Fprintf(w, "\tcsum_inet_update(&csum_%v, (const uint8_t*)\"%s\", %v);\n", csumSeq, esc, chunkSize)
+					default:
+						panic(fmt.Sprintf("bad checksum chunk kind %v", kind))
+					}
+				}
+				fmt.Fprintf(w, "\tNONFAILING(*(uint16_t*)0x%x = htons(csum_inet_digest(&csum_%v)));\n", addr, csumSeq)
 			default:
 				panic("bad argument type")
 			}
@@ -234,10 +301,13 @@ loop:
 			size := read()
 			fmt.Fprintf(w, "\tif (r[%v] != -1)\n", lastCall)
 			fmt.Fprintf(w, "\t\tNONFAILING(r[%v] = *(uint%v_t*)0x%x);\n", n, size*8, addr)
+		case prog.ExecInstrRace:
+			// Delays of racing calls are taken from the program (see callDelays).
+			read() // delay
 		default:
 			// Normal syscall.
 			newCall()
-			meta := sys.Calls[instr]
+			meta := sys.CallByExecNum(int(instr))
 			fmt.Fprintf(w, "\tr[%v] = execute_syscall(__NR_%v", n, meta.CallName)
 			nargs := read()
 			for i := uintptr(0); i < nargs; i++ {
@@ -265,10 +335,10 @@ loop:
 		}
 	}
 	newCall()
-	return calls, n
+	return calls, n, useChecksums
 }
 
-func preprocessCommonHeader(opts Options, handled map[string]int) (string, error) {
+func preprocessCommonHeader(opts Options, handled map[string]int, useChecksums bool) (string, error) {
 	var defines []string
 	switch opts.Sandbox {
 	case "none":
@@ -277,12 +347,17 @@ func preprocessCommonHeader(opts Options, handled map[string]int) (string, error
 		defines = append(defines, "SYZ_SANDBOX_SETUID")
 	case "namespace":
 		defines = append(defines, "SYZ_SANDBOX_NAMESPACE")
+	case "seccomp":
+		defines = append(defines, "SYZ_SANDBOX_SECCOMP")
 	default:
 		return "", fmt.Errorf("unknown sandbox mode: %v", opts.Sandbox)
 	}
 	if opts.Repeat {
 		defines = append(defines, "SYZ_REPEAT")
 	}
+	if useChecksums {
+		defines = append(defines, "SYZ_USE_CHECKSUMS")
+	}
 	for name, _ := range handled {
 		defines = append(defines, "__NR_"+name)
 	}
@@ -4,6 +4,7 @@
 package csource
 
 import (
+	"bytes"
 	"fmt"
 	"math/rand"
 	"os"
@@ -33,7 +34,7 @@ func allOptionsPermutations() []Options {
 			for _, opt.Repeat = range []bool{false, true} {
 				for _, opt.Repro = range []bool{false, true} {
 					for _, opt.Procs = range []int{1, 4} {
-						for _, opt.Sandbox = range []string{"none", "setuid", "namespace"} {
+						for _, opt.Sandbox = range []string{"none", "setuid", "namespace", "seccomp"} {
 							if opt.Collide && !opt.Threaded {
 								continue
 							}
@@ -67,6 +68,30 @@ func TestSyz(t *testing.T) {
 	testOne(t, p, opts)
 }
 
+func TestRace(t *testing.T) {
+	initTest(t)
+	p, err := prog.Deserialize([]byte(
+		"r0 = open(&(0x7f0000001000)=\"2e2f66696c653000\", 0x22c0, 0x1)\n" +
+			"read(r0, &(0x7f0000000000)=0x0, 0x1) (race=0)\n" +
+			"write(r0, &(0x7f0000000000)=\"1122\", 0x2) (race=100)\n" +
+			"close(r0)\n"))
+	if err != nil {
+		t.Fatalf("failed to deserialize: %v", err)
+	}
+	opts := Options{
+		Threaded: true,
+		Sandbox:  "none",
+	}
+	src, err := Write(p, opts)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !bytes.Contains(src, []byte("usleep(100);")) {
+		t.Fatalf("race delay is not in the program:\n%s", src)
+	}
+	testOne(t, p, opts)
+}
+
 func Test(t *testing.T) {
 	rs, iters := initTest(t)
 	syzProg := prog.GenerateAllSyzProg(rs)
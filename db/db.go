@@ -3,7 +3,7 @@
 
 // Package db implements a simple key-value database.
 // The database is cached in memory and mirrored on disk.
-// It is used to store corpus in syz-manager and syz-hub.
+// It is used to store corpus in syz-manager, syz-hub and standalone syz-fuzzer.
 // The database strives to minimize number of disk accesses
 // as they can be slow in virtualized environments (GCE).
 package db
@@ -47,16 +47,39 @@ const int kMaxOutput = 16 << 20;
 const int kMaxArgs = 9;
 const int kMaxThreads = 16;
 const int kMaxCommands = 16 << 10;
+const int kMaxBatch = 16;
 const int kCoverSize = 64 << 10;
 const int kPageSize = 4 << 10;
 
+// Executor writes a handshake into the output region before it starts serving:
+// magic, exec format version, syscall table hash and mask of supported flags.
+// ipc checks it to detect mismatching executor binaries (keep in sync with ipc.go).
+const uint32_t kHandshakeMagic = 0xbadc0ffe;
+const uint32_t kExecVersion = 4;
+const uint32_t kSupportedFlags = (1 << 9) - 1;
+
+// The last words of the output region hold the failure record: magic and reason (see fail).
+const int kFailureSize = 2 * sizeof(uint32_t);
+const int kOutputSize = kMaxOutput - kFailureSize;
+
 const uint64_t instr_eof = -1;
 const uint64_t instr_copyin = -2;
 const uint64_t instr_copyout = -3;
+const uint64_t instr_race = -4;
 
 const uint64_t arg_const = 0;
 const uint64_t arg_result = 1;
 const uint64_t arg_data = 2;
+const uint64_t arg_csum = 3;
+
+const uint64_t arg_csum_inet = 0;
+
+const uint64_t arg_csum_chunk_data = 0;
+const uint64_t arg_csum_chunk_const = 1;
+
+// Flags in call output records.
+const uint32_t call_flag_finished = 1 << 0;
+const uint32_t call_flag_blocked = 1 << 1;
 
 // We use the default value instead of results of failed syscalls.
 // -1 is an invalid fd and an invalid address and deterministic,
@@ -67,6 +90,16 @@ enum sandbox_type {
 	sandbox_none,
 	sandbox_setuid,
 	sandbox_namespace,
+	sandbox_seccomp,
+};
+
+// Programs in a batch can share a prefix of calls.
+// Then the prefix is executed once (prefix_execute) and the rest of each program
+// is executed in a subprocess forked from the state after the prefix (prefix_skip).
+enum prefix_mode_t {
+	prefix_none,
+	prefix_execute,
+	prefix_skip,
 };
 
 bool flag_cover;
@@ -75,6 +108,7 @@ bool flag_collide;
 bool flag_sandbox_privs;
 sandbox_type flag_sandbox;
 bool flag_enable_tun;
+bool flag_syscall_numbers;
 
 bool flag_collect_cover;
 bool flag_dedup_cover;
@@ -82,16 +116,27 @@ bool flag_dedup_cover;
 __attribute__((aligned(64 << 10))) char input_data[kMaxInput];
 uint32_t* output_data;
 uint32_t* output_pos;
+// Input and output of the currently executing program in the batch.
+uint64_t* prog_input;
+uint32_t* prog_output;
 uint32_t completed;
 int running;
 bool collide;
 
+prefix_mode_t prefix_mode;
+uint64_t prefix_calls;
+// Output records of the prefix calls (without the number of calls).
+uint32_t* prefix_output;
+uint32_t* prefix_output_end;
+
 struct res_t {
 	bool executed;
 	uint64_t val;
 };
 
 res_t results[kMaxCommands];
+// Results of the prefix calls indexed by call index.
+res_t prefix_results[kMaxCommands];
 
 struct thread_t {
 	bool created;
@@ -111,11 +156,19 @@ struct thread_t {
 	uint64_t reserrno;
 	uint64_t cover_size;
 	int cover_fd;
+	uint64_t start_us;
+	uint64_t duration_us;
+	bool blocked;
 };
 
 thread_t threads[kMaxThreads];
 
+bool execute_program(int iter);
+bool execute_prefix_batch(int iter, uint64_t* offsets, uint64_t nprogs);
+bool wait_child(int pid, uint32_t* progress, uint64_t timeout_ms);
 void execute_one();
+void start_suffix();
+uint32_t* skip_output(uint32_t* pos);
 uint64_t read_input(uint64_t** input_posp, bool peek = false);
 uint64_t read_arg(uint64_t** input_posp);
 uint64_t read_result(uint64_t** input_posp);
@@ -124,7 +177,10 @@ void copyin(char* addr, uint64_t val, uint64_t size, uint64_t bf_off, uint64_t b
 uint64_t copyout(char* addr, uint64_t size);
 thread_t* schedule_call(int n, int call_index, int call_num, uint64_t num_args, uint64_t* args, uint64_t* pos);
 void execute_call(thread_t* th);
+const char* call_name(int call_num);
+int call_sys_nr(int call_num);
 void handle_completion(thread_t* th);
+void write_call_output(thread_t* th, uint32_t reserrno, uint32_t flags, uint64_t duration_us);
 void thread_create(thread_t* th, int id);
 void* worker_thread(void* arg);
 bool write_file(const char* file, const char* what, ...);
@@ -132,22 +188,26 @@ void cover_open();
 void cover_enable(thread_t* th);
 void cover_reset(thread_t* th);
 uint64_t cover_read(thread_t* th);
+static uint64_t current_time_us();
 static uint32_t hash(uint32_t a);
 static bool dedup(uint32_t sig);
 
 int main(int argc, char** argv)
 {
 	prctl(PR_SET_PDEATHSIG, SIGKILL, 0, 0, 0);
-	if (mmap(&input_data[0], kMaxInput, PROT_READ, MAP_PRIVATE | MAP_FIXED, kInFd, 0) != &input_data[0])
-		fail("mmap of input file failed");
+	fail_reason = fail_reason_mmap;
 	// The output region is the only thing in executor process for which consistency matters.
 	// If it is corrupted ipc package will fail to parse its contents and panic.
 	// But fuzzer constantly invents new ways of how to currupt the region,
 	// so we map the region at a (hopefully) hard to guess address surrounded by unmapped pages.
+	// The output region is mapped first, so that we can report failure to map the input.
 	void* const kOutputDataAddr = (void*)0x1ddbc20000;
-	output_data = (uint32_t*)mmap(kOutputDataAddr, kMaxOutput, PROT_READ | PROT_WRITE, MAP_SHARED | MAP_FIXED, kOutFd, 0);
-	if (output_data != kOutputDataAddr)
+	if (mmap(kOutputDataAddr, kMaxOutput, PROT_READ | PROT_WRITE, MAP_SHARED | MAP_FIXED, kOutFd, 0) != kOutputDataAddr)
 		fail("mmap of output file failed");
+	output_data = (uint32_t*)kOutputDataAddr;
+	fail_record = output_data + kOutputSize / sizeof(output_data[0]);
+	if (mmap(&input_data[0], kMaxInput, PROT_READ, MAP_PRIVATE | MAP_FIXED, kInFd, 0) != &input_data[0])
+		fail("mmap of input file failed");
 	// Prevent random programs to mess with these fds.
 	// Due to races in collider mode, a program can e.g. ftruncate one of these fds,
 	// which will cause fuzzer to crash.
@@ -165,12 +225,19 @@ int main(int argc, char** argv)
 		flag_sandbox = sandbox_setuid;
 	else if (flags & (1 << 5))
 		flag_sandbox = sandbox_namespace;
+	else if (flags & (1 << 8))
+		flag_sandbox = sandbox_seccomp;
 	if (!flag_threaded)
 		flag_collide = false;
 	flag_enable_tun = flags & (1 << 6);
+	// Calls are identified by kernel syscall numbers rather than by index in syscalls table
+	// (used when descriptions are loaded at runtime and don't match the table).
+	flag_syscall_numbers = flags & (1 << 7);
 	uint64_t executor_pid = *((uint64_t*)input_data + 1);
 
+	fail_reason = fail_reason_kcov;
 	cover_open();
+	fail_reason = fail_reason_sandbox;
 	setup_main_process();
 
 	int pid = -1;
@@ -184,6 +251,9 @@ int main(int argc, char** argv)
 	case sandbox_namespace:
 		pid = do_sandbox_namespace(executor_pid, flag_enable_tun);
 		break;
+	case sandbox_seccomp:
+		pid = do_sandbox_seccomp(executor_pid, flag_enable_tun);
+		break;
 	default:
 		fail("unknown sandbox type");
 	}
@@ -217,18 +287,18 @@ int main(int argc, char** argv)
 
 void loop()
 {
+	// Test environment is set up, failures from now on are assert failures.
+	fail_reason = fail_reason_unknown;
+	output_data[0] = kHandshakeMagic;
+	output_data[1] = kExecVersion;
+	output_data[2] = SYZ_SYSCALLS_HASH;
+	output_data[3] = kSupportedFlags;
 	// Tell parent that we are ready to serve.
 	char tmp = 0;
 	if (write(kOutPipeFd, &tmp, 1) != 1)
 		fail("control pipe write failed");
 
-	for (int iter = 0;; iter++) {
-		// Create a new private work dir for this test (removed at the end of the loop).
-		char cwdbuf[256];
-		sprintf(cwdbuf, "./%d", iter);
-		if (mkdir(cwdbuf, 0777))
-			fail("failed to mkdir");
-
+	for (int iter = 0;;) {
 		// TODO: consider moving the read into the child.
 		// Potentially it can speed up things a bit -- when the read finishes
 		// we already have a forked worker process.
@@ -238,137 +308,362 @@ void loop()
 		flag_collect_cover = flags & (1 << 0);
 		flag_dedup_cover = flags & (1 << 1);
 
-		int pid = fork();
-		if (pid < 0)
-			fail("clone failed");
-		if (pid == 0) {
-			prctl(PR_SET_PDEATHSIG, SIGKILL, 0, 0, 0);
-			setpgrp();
-			if (chdir(cwdbuf))
-				fail("failed to chdir");
-			close(kInPipeFd);
-			close(kOutPipeFd);
-			execute_one();
-			debug("worker exiting\n");
-			doexit(0);
+		// Input contains a batch of programs: flags, pid, number of programs,
+		// number of calls in the shared prefix and offsets of programs (in words) follow.
+		uint64_t* batch = (uint64_t*)&input_data[0] + 2;
+		uint64_t nprogs = batch[0];
+		if (nprogs == 0 || nprogs > kMaxBatch)
+			fail("bad batch size %lu", nprogs);
+		prefix_calls = batch[1];
+		if (prefix_calls >= kMaxCommands)
+			fail("bad prefix size %lu", prefix_calls);
+		uint64_t* offsets = batch + 2;
+		for (uint64_t i = 0; i < nprogs; i++) {
+			if (offsets[i] >= kMaxInput / sizeof(uint64_t))
+				fail("bad program offset %lu", offsets[i]);
 		}
-		debug("spawned worker pid %d\n", pid);
-
-		// We used to use sigtimedwait(SIGCHLD) to wait for the subprocess.
-		// But SIGCHLD is also delivered when a process stops/continues,
-		// so it would require a loop with status analysis and timeout recalculation.
-		// SIGCHLD should also unblock the usleep below, so the spin loop
-		// should be as efficient as sigtimedwait.
-		int status = 0;
-		uint64_t start = current_time_ms();
-		uint64_t last_executed = start;
-		uint32_t executed_calls = __atomic_load_n(output_data, __ATOMIC_RELAXED);
-		for (;;) {
-			int res = waitpid(-1, &status, __WALL | WNOHANG);
-			int errno0 = errno;
-			if (res == pid) {
-				debug("waitpid(%d)=%d (%d)\n", pid, res, errno0);
-				break;
-			}
-			usleep(1000);
-			// Even though the test process executes exit at the end
-			// and execution time of each syscall is bounded by 20ms,
-			// this backup watchdog is necessary and its performance is important.
-			// The problem is that exit in the test processes can fail (sic).
-			// One observed scenario is that the test processes prohibits
-			// exit_group syscall using seccomp. Another observed scenario
-			// is that the test processes setups a userfaultfd for itself,
-			// then the main thread hangs when it wants to page in a page.
-			// Below we check if the test process still executes syscalls
-			// and kill it after 200ms of inactivity.
-			uint64_t now = current_time_ms();
-			uint32_t now_executed = __atomic_load_n(output_data, __ATOMIC_RELAXED);
-			if (executed_calls != now_executed) {
-				executed_calls = now_executed;
-				last_executed = now;
+		// Output contains number of executed programs, a flag that says that the last
+		// executed program hanged and was killed, followed by output of each program.
+		__atomic_store_n(output_data, 0, __ATOMIC_RELEASE);
+		output_data[1] = 0;
+		prog_output = output_data + 2;
+		if (prefix_calls != 0) {
+			bool killed = execute_prefix_batch(iter++, offsets, nprogs);
+			uint32_t executed = __atomic_load_n(output_data, __ATOMIC_ACQUIRE);
+			if (killed && !output_data[1] && executed < nprogs) {
+				// The prefix process itself hanged while executing the next program
+				// (or the prefix), report that program as the killed one.
+				output_data[1] = 1;
+				__atomic_store_n(output_data, executed + 1, __ATOMIC_RELEASE);
 			}
-			if ((now - start < 3 * 1000) && (now - last_executed < 200))
-				continue;
-			debug("waitpid(%d)=%d (%d)\n", pid, res, errno0);
-			debug("killing\n");
-			kill(-pid, SIGKILL);
-			kill(pid, SIGKILL);
-			for (;;) {
-				int res = waitpid(-1, &status, __WALL);
-				debug("waitpid(%d)=%d (%d)\n", pid, res, errno);
-				if (res == pid)
+		} else {
+			for (uint64_t i = 0; i < nprogs; i++, iter++) {
+				prog_input = (uint64_t*)&input_data[0] + offsets[i];
+				prog_output[0] = 0;
+				bool killed = execute_program(iter);
+				prog_output = skip_output(prog_output);
+				if (killed)
+					output_data[1] = 1;
+				__atomic_store_n(output_data, i + 1, __ATOMIC_RELEASE);
+				if (killed) {
+					// The program hanged, don't execute the rest of the batch
+					// to not attribute the hang to wrong programs.
+					debug("stopping batch after %lu programs\n", i + 1);
 					break;
+				}
 			}
-			break;
 		}
-		status = WEXITSTATUS(status);
-		if (status == kFailStatus)
-			fail("child failed");
-		if (status == kErrorStatus)
-			error("child errored");
-		remove_dir(cwdbuf);
 		if (write(kOutPipeFd, &tmp, 1) != 1)
 			fail("control pipe write failed");
 	}
 }
 
+// execute_program executes the current program in a subprocess.
+// Returns true if the subprocess hanged and was killed.
+bool execute_program(int iter)
+{
+	// Create a new private work dir for this test (removed when the test finishes).
+	char cwdbuf[256];
+	sprintf(cwdbuf, "./%d", iter);
+	if (mkdir(cwdbuf, 0777))
+		fail("failed to mkdir");
+
+	int pid = fork();
+	if (pid < 0)
+		fail("clone failed");
+	if (pid == 0) {
+		prctl(PR_SET_PDEATHSIG, SIGKILL, 0, 0, 0);
+		setpgrp();
+		if (chdir(cwdbuf))
+			fail("failed to chdir");
+		close(kInPipeFd);
+		close(kOutPipeFd);
+		execute_one();
+		debug("worker exiting\n");
+		doexit(0);
+	}
+	debug("spawned worker pid %d\n", pid);
+	bool killed = wait_child(pid, prog_output, 3 * 1000);
+	remove_dir(cwdbuf);
+	return killed;
+}
+
+// execute_prefix_batch executes a batch of programs that share first prefix_calls calls.
+// The prefix is executed once in a subprocess, then for each program the subprocess
+// forks a new subprocess that executes the rest of the program starting from the state
+// after the prefix. Note that file system changes made by one program are visible
+// to the next programs in the batch, as all of them share the work dir.
+// Hanged programs are killed and reported by the prefix subprocess.
+// Returns true if the prefix subprocess itself hanged and was killed.
+bool execute_prefix_batch(int iter, uint64_t* offsets, uint64_t nprogs)
+{
+	char cwdbuf[256];
+	sprintf(cwdbuf, "./%d", iter);
+	if (mkdir(cwdbuf, 0777))
+		fail("failed to mkdir");
+
+	int pid = fork();
+	if (pid < 0)
+		fail("clone failed");
+	if (pid == 0) {
+		prctl(PR_SET_PDEATHSIG, SIGKILL, 0, 0, 0);
+		setpgrp();
+		if (chdir(cwdbuf))
+			fail("failed to chdir");
+		close(kInPipeFd);
+		close(kOutPipeFd);
+		// The prefix output is written as output of the first program.
+		prog_input = (uint64_t*)&input_data[0] + offsets[0];
+		prog_output[0] = 0;
+		prefix_mode = prefix_execute;
+		execute_one();
+		prefix_output = prog_output + 1;
+		prefix_output_end = output_pos;
+		debug("prefix of %lu calls executed\n", prefix_calls);
+
+		prefix_mode = prefix_skip;
+		for (uint64_t i = 0; i < nprogs; i++) {
+			prog_input = (uint64_t*)&input_data[0] + offsets[i];
+			prog_output[0] = 0;
+			int pid = fork();
+			if (pid < 0)
+				fail("clone failed");
+			if (pid == 0) {
+				prctl(PR_SET_PDEATHSIG, SIGKILL, 0, 0, 0);
+				setpgrp();
+				execute_one();
+				debug("worker exiting\n");
+				doexit(0);
+			}
+			debug("spawned suffix worker pid %d\n", pid);
+			bool killed = wait_child(pid, prog_output, 3 * 1000);
+			prog_output = skip_output(prog_output);
+			if (killed)
+				output_data[1] = 1;
+			__atomic_store_n(output_data, i + 1, __ATOMIC_RELEASE);
+			if (killed) {
+				debug("stopping batch after %lu programs\n", i + 1);
+				break;
+			}
+		}
+		doexit(0);
+	}
+	debug("spawned prefix worker pid %d\n", pid);
+	// Subprocesses of the prefix worker have own watchdog,
+	// here we only protect against hangs of the prefix worker itself.
+	bool killed = wait_child(pid, NULL, 3 * 1000 * (nprogs + 1));
+	remove_dir(cwdbuf);
+	return killed;
+}
+
+// wait_child waits for subprocess pid and kills it if it runs longer than timeout_ms,
+// or if progress is not NULL and the value does not change for 200ms.
+// Returns true if the subprocess hanged and was killed.
+bool wait_child(int pid, uint32_t* progress, uint64_t timeout_ms)
+{
+	// We used to use sigtimedwait(SIGCHLD) to wait for the subprocess.
+	// But SIGCHLD is also delivered when a process stops/continues,
+	// so it would require a loop with status analysis and timeout recalculation.
+	// SIGCHLD should also unblock the usleep below, so the spin loop
+	// should be as efficient as sigtimedwait.
+	int status = 0;
+	bool killed = false;
+	uint64_t start = current_time_ms();
+	uint64_t last_executed = start;
+	uint32_t executed_calls = progress ? __atomic_load_n(progress, __ATOMIC_RELAXED) : 0;
+	for (;;) {
+		int res = waitpid(-1, &status, __WALL | WNOHANG);
+		int errno0 = errno;
+		if (res == pid) {
+			debug("waitpid(%d)=%d (%d)\n", pid, res, errno0);
+			break;
+		}
+		usleep(1000);
+		// Even though the test process executes exit at the end
+		// and execution time of each syscall is bounded by 20ms,
+		// this backup watchdog is necessary and its performance is important.
+		// The problem is that exit in the test processes can fail (sic).
+		// One observed scenario is that the test processes prohibits
+		// exit_group syscall using seccomp. Another observed scenario
+		// is that the test processes setups a userfaultfd for itself,
+		// then the main thread hangs when it wants to page in a page.
+		// Below we check if the test process still executes syscalls
+		// and kill it after 200ms of inactivity.
+		uint64_t now = current_time_ms();
+		if (!progress)
+			last_executed = now;
+		else if (__atomic_load_n(progress, __ATOMIC_RELAXED) != executed_calls) {
+			executed_calls = __atomic_load_n(progress, __ATOMIC_RELAXED);
+			last_executed = now;
+		}
+		if ((now - start < timeout_ms) && (now - last_executed < 200))
+			continue;
+		debug("waitpid(%d)=%d (%d)\n", pid, res, errno0);
+		debug("killing\n");
+		killed = true;
+		kill(-pid, SIGKILL);
+		kill(pid, SIGKILL);
+		for (;;) {
+			int res = waitpid(-1, &status, __WALL);
+			debug("waitpid(%d)=%d (%d)\n", pid, res, errno);
+			if (res == pid)
+				break;
+		}
+		break;
+	}
+	status = WEXITSTATUS(status);
+	if (status == kFailStatus)
+		fail("child failed");
+	if (status == kErrorStatus)
+		error("child errored");
+	return killed;
+}
+
+// skip_output returns end of output of a program that starts at pos.
+uint32_t* skip_output(uint32_t* pos)
+{
+	uint32_t* end = output_data + kOutputSize / sizeof(output_data[0]);
+	uint32_t ncmd = *pos++;
+	for (uint32_t i = 0; i < ncmd; i++) {
+		// Record: call index, call num, errno, flags, time, signal size, cover size.
+		if (pos + 7 > end)
+			fail("output overflow");
+		uint32_t signal_size = pos[5];
+		uint32_t cover_size = pos[6];
+		pos += 7;
+		if (signal_size > end - pos || cover_size > end - pos - signal_size)
+			fail("output overflow");
+		pos += signal_size + cover_size;
+	}
+	if (pos >= end)
+		fail("output overflow");
+	return pos;
+}
+
 void execute_one()
 {
 retry:
-	uint64_t* input_pos = (uint64_t*)&input_data[0];
-	read_input(&input_pos); // flags
-	read_input(&input_pos); // pid
-	output_pos = output_data;
+	uint64_t* input_pos = prog_input;
+	output_pos = prog_output;
 	write_output(0); // Number of executed syscalls (updated later).
 
+	if (prefix_mode == prefix_skip && !collide)
+		start_suffix();
 	if (!collide && !flag_threaded)
 		cover_enable(&threads[0]);
 
-	int call_index = 0;
+	uint64_t call_index = 0;
+	bool race = false;
+	uint64_t race_delay = 0;
 	for (int n = 0;; n++) {
 		uint64_t call_num = read_input(&input_pos);
 		if (call_num == instr_eof)
 			break;
+		if (prefix_mode == prefix_execute && call_index == prefix_calls && call_num != instr_copyout)
+			break;
+		// Copyins of the prefix calls were done by the prefix process.
+		bool skip = prefix_mode == prefix_skip && call_index < prefix_calls;
 		if (call_num == instr_copyin) {
 			char* addr = (char*)read_input(&input_pos);
 			uint64_t typ = read_input(&input_pos);
 			uint64_t size = read_input(&input_pos);
-			debug("copyin to %p\n", addr);
+			if (!skip)
+				debug("copyin to %p\n", addr);
 			switch (typ) {
 			case arg_const: {
 				uint64_t arg = read_input(&input_pos);
 				uint64_t bf_off = read_input(&input_pos);
 				uint64_t bf_len = read_input(&input_pos);
-				copyin(addr, arg, size, bf_off, bf_len);
+				if (!skip)
+					copyin(addr, arg, size, bf_off, bf_len);
 				break;
 			}
 			case arg_result: {
 				uint64_t val = read_result(&input_pos);
-				copyin(addr, val, size, 0, 0);
+				if (!skip)
+					copyin(addr, val, size, 0, 0);
 				break;
 			}
 			case arg_data: {
-				NONFAILING(memcpy(addr, input_pos, size));
+				if (!skip)
+					NONFAILING(memcpy(addr, input_pos, size));
 				// Read out the data.
 				for (uint64_t i = 0; i < (size + 7) / 8; i++)
 					read_input(&input_pos);
 				break;
 			}
+			case arg_csum: {
+				uint64_t csum_kind = read_input(&input_pos);
+				if (csum_kind != arg_csum_inet)
+					fail("bad checksum kind %lu", csum_kind);
+				if (size != 2)
+					fail("inet checksum must be 2 bytes, not %lu", size);
+				struct csum_inet csum;
+				csum_inet_init(&csum);
+				uint64_t chunks_num = read_input(&input_pos);
+				for (uint64_t chunk = 0; chunk < chunks_num; chunk++) {
+					uint64_t chunk_kind = read_input(&input_pos);
+					uint64_t chunk_value = read_input(&input_pos);
+					uint64_t chunk_size = read_input(&input_pos);
+					switch (chunk_kind) {
+					case arg_csum_chunk_data:
+						if (!skip)
+							NONFAILING(csum_inet_update(&csum, (const uint8_t*)chunk_value, chunk_size));
+						break;
+					case arg_csum_chunk_const: {
+						if (chunk_size > 8)
+							fail("bad checksum const chunk size %lu", chunk_size);
+						// Const chunks are big-endian.
+						uint8_t data[8];
+						for (uint64_t i = 0; i < chunk_size; i++)
+							data[i] = chunk_value >> (8 * (chunk_size - i - 1));
+						csum_inet_update(&csum, data, chunk_size);
+						break;
+					}
+					default:
+						fail("bad checksum chunk kind %lu", chunk_kind);
+					}
+				}
+				// Checksums are stored in network byte order.
+				if (!skip)
+					copyin(addr, htons(csum_inet_digest(&csum)), 2, 0, 0);
+				break;
+			}
 			default:
 				fail("bad argument type %lu", typ);
 			}
 			continue;
 		}
 		if (call_num == instr_copyout) {
-			read_input(&input_pos); // addr
-			read_input(&input_pos); // size
-			// The copyout will happen when/if the call completes.
+			char* addr = (char*)read_input(&input_pos);
+			uint64_t size = read_input(&input_pos);
+			if (prefix_mode == prefix_skip && call_index != 0 && call_index <= prefix_calls) {
+				// Copyout of a prefix call. Programs can copyout different results
+				// of the prefix calls, so we do copyouts of the prefix calls here
+				// (from the state after the prefix) rather than in the prefix process.
+				results[n].executed = prefix_results[call_index - 1].executed;
+				if (results[n].executed)
+					results[n].val = copyout(addr, size);
+			}
+			// Otherwise the copyout will happen when/if the call completes.
+			continue;
+		}
+		if (call_num == instr_race) {
+			uint64_t delay = read_input(&input_pos);
+			// Races need threads. Calls of the prefix are not raced,
+			// because threads of the prefix process don't survive fork.
+			if (flag_threaded && !collide && !skip && prefix_mode != prefix_execute) {
+				race = true;
+				race_delay = delay;
+			}
 			continue;
 		}
 
 		// Normal syscall.
-		if (call_num >= sizeof(syscalls) / sizeof(syscalls[0]))
+		if (!flag_syscall_numbers && call_num >= sizeof(syscalls) / sizeof(syscalls[0])) {
+			fail_reason = fail_reason_syscall_table;
 			fail("invalid command number %lu", call_num);
+		}
 		uint64_t num_args = read_input(&input_pos);
 		if (num_args > kMaxArgs)
 			fail("command has bad number of arguments %lu", num_args);
@@ -377,11 +672,22 @@ retry:
 			args[i] = read_arg(&input_pos);
 		for (uint64_t i = num_args; i < 6; i++)
 			args[i] = 0;
+		if (skip) {
+			// The call was executed by the prefix process.
+			results[n] = prefix_results[call_index++];
+			continue;
+		}
 		thread_t* th = schedule_call(n, call_index++, call_num, num_args, args, input_pos);
 
 		if (collide && (call_index % 2) == 0) {
 			// Don't wait for every other call.
 			// We already have results from the previous execution.
+		} else if (race) {
+			// Don't wait for the call, start the next call after the delay.
+			// Completion of the call is handled with the following calls.
+			debug("racing call %lu with the next call after %luus\n", call_index - 1, race_delay);
+			race = false;
+			usleep(race_delay);
 		} else if (flag_threaded) {
 			// Wait for call completion.
 			uint64_t start = current_time_ms();
@@ -399,6 +705,8 @@ retry:
 			}
 			if (__atomic_load_n(&th->done, __ATOMIC_ACQUIRE))
 				handle_completion(th);
+			else
+				th->blocked = true;
 			// Check if any of previous calls have completed.
 			// Give them some additional time, because they could have been
 			// just unblocked by the current call.
@@ -422,13 +730,51 @@ retry:
 		}
 	}
 
-	if (flag_collide && !collide) {
+	if (!collide) {
+		// Report calls that are still running when the program ends.
+		for (int i = 0; i < kMaxThreads; i++) {
+			thread_t* th = &threads[i];
+			if (!th->created || th->handled)
+				continue;
+			if (__atomic_load_n(&th->done, __ATOMIC_ACQUIRE)) {
+				handle_completion(th);
+				continue;
+			}
+			debug("call %d [%s] on thread %d did not finish\n", th->call_index, call_name(th->call_num), th->id);
+			write_call_output(th, 0, th->blocked ? call_flag_blocked : 0, current_time_us() - th->start_us);
+		}
+	}
+
+	if (flag_collide && !collide && prefix_mode != prefix_execute) {
 		debug("enabling collider\n");
 		collide = true;
 		goto retry;
 	}
 }
 
+// start_suffix prepares a subprocess forked from the prefix process
+// to execute the rest of the program.
+void start_suffix()
+{
+	// Threads of the prefix process don't exist in this process.
+	for (int i = 0; i < kMaxThreads; i++) {
+		thread_t* th = &threads[i];
+		th->created = false;
+		th->ready = false;
+	}
+	running = 0;
+	// Results are indexed by instruction index, and programs can have different
+	// number of instructions in the prefix (see copyouts in execute_one).
+	memset(results, 0, sizeof(results));
+	// Output of the prefix calls is part of output of every program.
+	uint32_t n = prefix_output_end - prefix_output;
+	if (n > (uint32_t*)((char*)output_data + kOutputSize) - output_pos)
+		fail("output overflow");
+	memmove(output_pos, prefix_output, n * sizeof(prefix_output[0]));
+	output_pos += n;
+	__atomic_store_n(prog_output, completed, __ATOMIC_RELEASE);
+}
+
 thread_t* schedule_call(int n, int call_index, int call_num, uint64_t num_args, uint64_t* args, uint64_t* pos)
 {
 	// Find a spare thread to execute the call.
@@ -446,7 +792,7 @@ thread_t* schedule_call(int n, int call_index, int call_num, uint64_t num_args,
 	if (i == kMaxThreads)
 		exitf("out of threads");
 	thread_t* th = &threads[i];
-	debug("scheduling call %d [%s] on thread %d\n", call_index, syscalls[call_num].name, th->id);
+	debug("scheduling call %d [%s] on thread %d\n", call_index, call_name(call_num), th->id);
 	if (th->ready || !th->done || !th->handled)
 		fail("bad thread state in schedule: ready=%d done=%d handled=%d", th->ready, th->done, th->handled);
 	th->copyout_pos = pos;
@@ -456,6 +802,8 @@ thread_t* schedule_call(int n, int call_index, int call_num, uint64_t num_args,
 	th->call_index = call_index;
 	th->call_num = call_num;
 	th->num_args = num_args;
+	th->blocked = false;
+	th->start_us = current_time_us();
 	for (int i = 0; i < kMaxArgs; i++)
 		th->args[i] = args[i];
 	__atomic_store_n(&th->ready, 1, __ATOMIC_RELEASE);
@@ -466,7 +814,7 @@ thread_t* schedule_call(int n, int call_index, int call_num, uint64_t num_args,
 
 void handle_completion(thread_t* th)
 {
-	debug("completion of call %d [%s] on thread %d\n", th->call_index, syscalls[th->call_num].name, th->id);
+	debug("completion of call %d [%s] on thread %d\n", th->call_index, call_name(th->call_num), th->id);
 	if (th->ready || !th->done || th->handled)
 		fail("bad thread state in completion: ready=%d done=%d handled=%d",
 		     th->ready, th->done, th->handled);
@@ -475,6 +823,10 @@ void handle_completion(thread_t* th)
 			fail("result idx %ld overflows kMaxCommands", th->call_n);
 		results[th->call_n].executed = true;
 		results[th->call_n].val = th->res;
+		if (prefix_mode == prefix_execute) {
+			prefix_results[th->call_index].executed = true;
+			prefix_results[th->call_index].val = th->res;
+		}
 		for (bool done = false; !done;) {
 			th->call_n++;
 			uint64_t call_num = read_input(&th->copyout_pos);
@@ -497,19 +849,33 @@ void handle_completion(thread_t* th)
 		}
 	}
 	if (!collide) {
-		write_output(th->call_index);
-		write_output(th->call_num);
 		uint32_t reserrno = th->res != (uint64_t)-1 ? 0 : th->reserrno;
-		write_output(reserrno);
-		uint32_t* signal_count_pos = write_output(0); // filled in later
-		uint32_t* cover_count_pos = write_output(0);  // filled in later
+		uint32_t flags = call_flag_finished;
+		if (th->blocked)
+			flags |= call_flag_blocked;
+		write_call_output(th, reserrno, flags, th->duration_us);
+	}
+	th->handled = true;
+	running--;
+}
 
+void write_call_output(thread_t* th, uint32_t reserrno, uint32_t flags, uint64_t duration_us)
+{
+	write_output(th->call_index);
+	write_output(th->call_num);
+	write_output(reserrno);
+	write_output(flags);
+	write_output(duration_us > UINT32_MAX ? UINT32_MAX : duration_us);
+	uint32_t* signal_count_pos = write_output(0); // filled in later
+	uint32_t* cover_count_pos = write_output(0); // filled in later
+	uint32_t nsig = 0;
+	uint32_t cover_size = 0;
+	if (flags & call_flag_finished) {
 		// Write out feedback signals.
 		// Currently it is code edges computed as xor of two subsequent basic block PCs.
 		uint64_t* cover_data = th->cover_data + 1;
-		uint32_t cover_size = th->cover_size;
+		cover_size = th->cover_size;
 		uint32_t prev = 0;
-		uint32_t nsig = 0;
 		for (uint32_t i = 0; i < cover_size; i++) {
 			uint32_t pc = cover_data[i];
 			uint32_t sig = pc ^ prev;
@@ -540,14 +906,12 @@ void handle_completion(thread_t* th)
 				write_output((uint32_t)cover_data[i]);
 			*cover_count_pos = cover_size;
 		}
-		debug("out #%u: index=%u num=%u errno=%d sig=%u cover=%u\n",
-		      completed, th->call_index, th->call_num, reserrno, nsig, cover_size);
-
-		completed++;
-		__atomic_store_n(output_data, completed, __ATOMIC_RELEASE);
 	}
-	th->handled = true;
-	running--;
+	debug("out #%u: index=%u num=%u errno=%d flags=%u time=%lu sig=%u cover=%u\n",
+	      completed, th->call_index, th->call_num, reserrno, flags, duration_us, nsig, cover_size);
+
+	completed++;
+	__atomic_store_n(prog_output, completed, __ATOMIC_RELEASE);
 }
 
 void thread_create(thread_t* th, int id)
@@ -579,11 +943,25 @@ void* worker_thread(void* arg)
 	return 0;
 }
 
+const char* call_name(int call_num)
+{
+	if (flag_syscall_numbers)
+		return "syscall";
+	return syscalls[call_num].name;
+}
+
+int call_sys_nr(int call_num)
+{
+	if (flag_syscall_numbers)
+		return call_num;
+	return syscalls[call_num].sys_nr;
+}
+
 void execute_call(thread_t* th)
 {
 	th->ready = false;
-	call_t* call = &syscalls[th->call_num];
-	debug("#%d: %s(", th->id, call->name);
+	const char* name = call_name(th->call_num);
+	debug("#%d: %s(", th->id, name);
 	for (int i = 0; i < th->num_args; i++) {
 		if (i != 0)
 			debug(", ");
@@ -592,14 +970,16 @@ void execute_call(thread_t* th)
 	debug(")\n");
 
 	cover_reset(th);
-	th->res = execute_syscall(call->sys_nr, th->args[0], th->args[1], th->args[2], th->args[3], th->args[4], th->args[5], th->args[6], th->args[7], th->args[8]);
+	uint64_t start = current_time_us();
+	th->res = execute_syscall(call_sys_nr(th->call_num), th->args[0], th->args[1], th->args[2], th->args[3], th->args[4], th->args[5], th->args[6], th->args[7], th->args[8]);
 	th->reserrno = errno;
+	th->duration_us = current_time_us() - start;
 	th->cover_size = cover_read(th);
 
 	if (th->res == (uint64_t)-1)
-		debug("#%d: %s = errno(%ld)\n", th->id, call->name, th->reserrno);
+		debug("#%d: %s = errno(%ld)\n", th->id, name, th->reserrno);
 	else
-		debug("#%d: %s = 0x%lx\n", th->id, call->name, th->res);
+		debug("#%d: %s = 0x%lx\n", th->id, name, th->res);
 	__atomic_store_n(&th->done, 1, __ATOMIC_RELEASE);
 	syscall(SYS_futex, &th->done, FUTEX_WAKE);
 }
@@ -623,7 +1003,9 @@ void cover_open()
 
 void cover_enable(thread_t* th)
 {
-	if (!flag_cover)
+	// kcov can be enabled only for a single task at a time, so we don't collect
+	// coverage for the prefix to be able to enable kcov in subprocesses.
+	if (!flag_cover || prefix_mode == prefix_execute)
 		return;
 	debug("#%d: enabling /sys/kernel/debug/kcov\n", th->id);
 	if (ioctl(th->cover_fd, KCOV_ENABLE, 0)) {
@@ -653,6 +1035,14 @@ uint64_t cover_read(thread_t* th)
 	return n;
 }
 
+static uint64_t current_time_us()
+{
+	timespec ts;
+	if (clock_gettime(CLOCK_MONOTONIC, &ts))
+		fail("clock_gettime failed");
+	return (uint64_t)ts.tv_sec * 1000000 + (uint64_t)ts.tv_nsec / 1000;
+}
+
 static uint32_t hash(uint32_t a)
 {
 	a = (a ^ 61) ^ (a >> 16);
@@ -781,7 +1171,7 @@ uint32_t* write_output(uint32_t v)
 {
 	if (collide)
 		return 0;
-	if (output_pos < output_data || (char*)output_pos >= (char*)output_data + kMaxOutput)
+	if (output_pos < output_data || (char*)output_pos >= (char*)output_data + kOutputSize)
 		fail("output overflow");
 	*output_pos = v;
 	return output_pos++;
@@ -0,0 +1,102 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package host
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+)
+
+const (
+	kmemleakFile = "/sys/kernel/debug/kmemleak"
+	// KmemleakMinAge is the age after which kmemleak starts reporting unreferenced objects.
+	KmemleakMinAge = 5 * time.Second
+)
+
+// KmemleakInit prepares kmemleak for leak checking: if enable is set,
+// it turns off automatic scanning (leaks are detected with explicit KmemleakScan),
+// otherwise it turns kmemleak off (it slows down the kernel).
+func KmemleakInit(enable bool) error {
+	fd, err := syscall.Open(kmemleakFile, syscall.O_RDWR, 0)
+	if err != nil {
+		if enable {
+			return fmt.Errorf("%v is missing (%v). Enable CONFIG_KMEMLEAK and mount debugfs.", kmemleakFile, err)
+		}
+		return nil
+	}
+	defer syscall.Close(fd)
+	what := "scan=off"
+	if !enable {
+		what = "off"
+	}
+	if _, err := syscall.Write(fd, []byte(what)); err != nil {
+		// kmemleak returns EBUSY when kmemleak is already turned off.
+		if err != syscall.EBUSY {
+			return fmt.Errorf("failed to write %v to kmemleak: %v", what, err)
+		}
+	}
+	return nil
+}
+
+var kmemleakBuf []byte
+
+// KmemleakScan scans for memory leaks and then clears the list of leaked objects,
+// so that next scan reports only new leaks. If report is set, it returns leak report
+// (nil if there are no leaks). KmemleakScan must not be called concurrently.
+func KmemleakScan(report bool) ([]byte, error) {
+	fd, err := syscall.Open(kmemleakFile, syscall.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.Close(fd)
+	scan := func() error {
+		if _, err := syscall.Write(fd, []byte("scan")); err != nil {
+			return fmt.Errorf("failed to scan kmemleak: %v", err)
+		}
+		return nil
+	}
+	// Kmemleak has false positives. To mitigate most of them, it checksums
+	// potentially leaked objects, and reports them only on the next scan
+	// iff the checksum does not change. Because of that we do the following
+	// intricate dance:
+	// Scan, sleep, scan again. At this point we can get some leaks.
+	// If there are leaks, we sleep and scan again, this can remove
+	// false leaks. Then, read kmemleak again. If we get leaks now, then
+	// hopefully these are true positives during the previous testing cycle.
+	if err := scan(); err != nil {
+		return nil, err
+	}
+	time.Sleep(time.Second)
+	if err := scan(); err != nil {
+		return nil, err
+	}
+	var leaks []byte
+	if report {
+		if kmemleakBuf == nil {
+			kmemleakBuf = make([]byte, 128<<10)
+		}
+		n, err := syscall.Read(fd, kmemleakBuf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read kmemleak: %v", err)
+		}
+		if n != 0 {
+			time.Sleep(time.Second)
+			if err := scan(); err != nil {
+				return nil, err
+			}
+			n, err := syscall.Read(fd, kmemleakBuf)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read kmemleak: %v", err)
+			}
+			if n != 0 {
+				leaks = append([]byte{}, kmemleakBuf[:n]...)
+			}
+		}
+	}
+	if _, err := syscall.Write(fd, []byte("clear")); err != nil {
+		return nil, fmt.Errorf("failed to clear kmemleak: %v", err)
+	}
+	return leaks, nil
+}
@@ -0,0 +1,419 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+// Package fakeexec implements a stand-in for syz-executor in Go for hermetic tests.
+// The fake executor speaks the same shared memory and pipe protocol as executor.cc,
+// but instead of executing syscalls it produces results described by a Script.
+//
+// Tests run the fake executor by re-executing the test binary:
+//
+//	func TestMain(m *testing.M) {
+//		fakeexec.Main()
+//		os.Exit(m.Run())
+//	}
+//
+//	bin, cleanup, err := fakeexec.Command(script)
+//	env, err := ipc.MakeEnv(bin, timeout, flags, pid)
+package fakeexec
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/google/syzkaller/prog"
+	"github.com/google/syzkaller/sys"
+)
+
+// Call describes behavior of a syscall in the fake executor.
+type Call struct {
+	Errno      int           // errno returned by the call (0 for success)
+	Signal     []uint32      // feedback signal produced by the call
+	Cover      []uint32      // coverage produced by the call
+	ArgSignal  bool          // additionally produce signal from hash of the call and values of its arguments
+	Time       time.Duration // reported execution time of the call
+	Blocked    bool          // the call blocks and does not finish before the end of the program
+	Hang       bool          // the call hangs the whole executor (ipc kills it after timeout)
+	Kill       bool          // the call hangs and executor kills the program and ends the batch
+	Crash      string        // executor prints the message and exits as if it has detected a kernel bug
+	Fail       string        // executor fails with the message (ipc returns ExecutorFailure or a typed failure)
+	FailReason uint32        // failure reason reported with Fail (see fail_reason_t in executor/common.h)
+	Retry      bool          // executor exits with the retry status (ipc restarts executor)
+}
+
+// Script describes behavior of the fake executor.
+type Script struct {
+	Calls           map[string]*Call // behavior of calls by name (e.g. "getpid" or "open$dir")
+	Default         Call             // behavior of calls not present in Calls
+	StartFail       string           // executor fails with the message before it starts serving
+	StartFailReason uint32           // failure reason reported with StartFail
+	StartFailCount  int              // StartFail happens only on that many first starts (0 means on all)
+
+	// Handshake values reported to ipc, zero values mean the values of a matching executor.
+	Version        uint32 // exec format version
+	CallsHash      uint32 // syscall table hash
+	SupportedFlags uint32 // mask of supported flags
+}
+
+func (s *Script) call(name string) *Call {
+	if c := s.Calls[name]; c != nil {
+		return c
+	}
+	return &s.Default
+}
+
+// Keep in sync with executor.cc and ipc.go.
+const (
+	magicArg = "-syz-fake-executor"
+
+	inFd      = 3
+	outFd     = 4
+	inPipeFd  = 5
+	outPipeFd = 6
+
+	flagSignal = 1 << 1
+
+	handshakeMagic = 0xbadc0ffe
+	execVersion    = 4
+	supportedFlags = 1<<9 - 1
+
+	failureMagic = 0xfa11ed00
+	failureSize  = 2 * 4
+
+	statusFail  = 67
+	statusError = 68
+	statusRetry = 69
+
+	callFlagFinished = 1 << 0
+	callFlagBlocked  = 1 << 1
+)
+
+// Command writes script into a temp file and returns executor command line
+// (suitable for ipc.MakeEnv) that runs the current binary as fake executor.
+// The current binary must call Main at startup.
+// The returned function removes the script file.
+func Command(script *Script) (string, func(), error) {
+	bin, err := filepath.Abs(os.Args[0])
+	if err != nil {
+		return "", nil, err
+	}
+	data, err := json.Marshal(script)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to serialize script: %v", err)
+	}
+	f, err := ioutil.TempFile("", "syz-fake-executor")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create temp file: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		os.Remove(f.Name())
+		return "", nil, fmt.Errorf("failed to write script: %v", err)
+	}
+	cleanup := func() {
+		os.Remove(f.Name())
+		os.Remove(f.Name() + ".starts")
+	}
+	return fmt.Sprintf("%v %v %v", bin, magicArg, f.Name()), cleanup, nil
+}
+
+// Main runs the fake executor and exits if the current process was started
+// with a command line returned by Command. Otherwise it returns immediately.
+func Main() {
+	if len(os.Args) != 3 || os.Args[1] != magicArg {
+		return
+	}
+	data, err := ioutil.ReadFile(os.Args[2])
+	if err != nil {
+		failf("failed to read script: %v", err)
+	}
+	script := new(Script)
+	if err := json.Unmarshal(data, script); err != nil {
+		failf("failed to parse script: %v", err)
+	}
+	ex := &executor{script: script}
+	ex.in = mapFile(inFd, syscall.PROT_READ)
+	ex.out = mapFile(outFd, syscall.PROT_READ|syscall.PROT_WRITE)
+	syscall.Close(inFd)
+	syscall.Close(outFd)
+	if script.StartFail != "" && (script.StartFailCount == 0 || countStart(os.Args[2]) <= script.StartFailCount) {
+		ex.fail(script.StartFailReason, script.StartFail)
+	}
+	flags := ex.input(0)
+	ex.signal = flags&flagSignal != 0
+	ex.loop()
+}
+
+// countStart returns how many times the fake executor was started with the script file.
+func countStart(script string) int {
+	f, err := os.OpenFile(script+".starts", os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		failf("failed to open starts file: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.Write([]byte{0}); err != nil {
+		failf("failed to write starts file: %v", err)
+	}
+	st, err := f.Stat()
+	if err != nil {
+		failf("failed to stat starts file: %v", err)
+	}
+	return int(st.Size())
+}
+
+type executor struct {
+	script *Script
+	in     []byte
+	out    []byte
+	outPos int  // in words
+	signal bool // FlagSignal is set
+	cover  bool // coverage is requested for the current batch
+}
+
+func (ex *executor) loop() {
+	ex.handshake()
+	// Tell parent that we are ready to serve.
+	var tmp [1]byte
+	if n, err := syscall.Write(outPipeFd, tmp[:]); n != 1 || err != nil {
+		failf("control pipe write failed: %v", err)
+	}
+	serving = true
+	for {
+		if n, err := syscall.Read(inPipeFd, tmp[:]); n != 1 || err != nil {
+			failf("control pipe read failed: %v", err)
+		}
+		ex.cover = tmp[0]&(1<<0) != 0
+		// Input: flags, pid, number of programs, number of prefix calls, offsets of programs.
+		// The prefix is not special for the fake executor, programs are executed in full.
+		// Output: number of executed programs, killed flag, output of each program.
+		nprogs := ex.input(2)
+		ex.output(0, 0)
+		ex.output(1, 0)
+		ex.outPos = 2
+		for i := uint64(0); i < nprogs; i++ {
+			killed := ex.execute(ex.input(4 + i))
+			if killed {
+				ex.output(1, 1)
+			}
+			ex.output(0, uint32(i+1))
+			if killed {
+				break
+			}
+		}
+		tmp[0] = 0
+		if n, err := syscall.Write(outPipeFd, tmp[:]); n != 1 || err != nil {
+			failf("control pipe write failed: %v", err)
+		}
+	}
+}
+
+func (ex *executor) handshake() {
+	version, hash, flags := uint32(execVersion), sys.CallsHash(), uint32(supportedFlags)
+	if ex.script.Version != 0 {
+		version = ex.script.Version
+	}
+	if ex.script.CallsHash != 0 {
+		hash = ex.script.CallsHash
+	}
+	if ex.script.SupportedFlags != 0 {
+		flags = ex.script.SupportedFlags
+	}
+	for i, v := range []uint32{handshakeMagic, version, hash, flags} {
+		ex.output(i, v)
+	}
+}
+
+// execute executes a program that starts at word pos in the input.
+// Returns true if the program hanged and was killed.
+func (ex *executor) execute(pos uint64) bool {
+	read := func() uint64 {
+		v := ex.input(pos)
+		pos++
+		return v
+	}
+	readArg := func() uint64 {
+		typ := read()
+		read() // size
+		switch uintptr(typ) {
+		case prog.ExecArgConst:
+			v := read()
+			read() // bitfield offset
+			read() // bitfield length
+			return v
+		case prog.ExecArgResult:
+			idx := read()
+			read() // op div
+			read() // op add
+			return idx
+		case prog.ExecArgData:
+			failf("data argument of a syscall")
+		}
+		failf("bad argument type %v", typ)
+		return 0
+	}
+	ncmdPos := ex.outPos
+	ex.outPos++
+	ncmd := uint32(0)
+	ex.output(ncmdPos, ncmd)
+	for callIndex := 0; ; {
+		switch instr := uintptr(read()); instr {
+		case prog.ExecInstrEOF:
+			return false
+		case prog.ExecInstrCopyin:
+			read() // addr
+			switch typ := uintptr(read()); typ {
+			case prog.ExecArgConst:
+				read() // size
+				read() // value
+				read() // bitfield offset
+				read() // bitfield length
+			case prog.ExecArgResult:
+				read() // size
+				read() // index
+				read() // op div
+				read() // op add
+			case prog.ExecArgData:
+				size := read()
+				pos += (size + 7) / 8
+			case prog.ExecArgCsum:
+				read()            // size
+				read()            // kind
+				pos += 3 * read() // chunks
+			default:
+				failf("bad argument type %v", typ)
+			}
+		case prog.ExecInstrCopyout:
+			read() // addr
+			read() // size
+		case prog.ExecInstrRace:
+			read() // delay
+		default:
+			num := int(instr)
+			meta := sys.CallByExecNum(num)
+			if meta == nil {
+				failf("invalid command number %v", num)
+			}
+			nargs := read()
+			args := make([]uint64, nargs)
+			for i := range args {
+				args[i] = readArg()
+			}
+			c := ex.script.call(meta.Name)
+			if c.Kill {
+				// Executor does not write output for the hanged call.
+				return true
+			}
+			ex.call(callIndex, num, c, args)
+			callIndex++
+			ncmd++
+			ex.output(ncmdPos, ncmd)
+		}
+	}
+}
+
+// call writes output record for a call according to its behavior c.
+func (ex *executor) call(callIndex, num int, c *Call, args []uint64) {
+	switch {
+	case c.Fail != "":
+		ex.fail(c.FailReason, c.Fail)
+	case c.Crash != "":
+		fmt.Printf("%v\n", c.Crash)
+		exit(statusError)
+	case c.Retry:
+		exit(statusRetry)
+	case c.Hang:
+		for {
+			time.Sleep(time.Hour)
+		}
+	}
+	flags := uint32(callFlagFinished)
+	errno := uint32(c.Errno)
+	if c.Blocked {
+		flags = callFlagBlocked
+		errno = 0
+	}
+	var signal, cover []uint32
+	if ex.signal && !c.Blocked {
+		signal = append(signal, c.Signal...)
+		if c.ArgSignal {
+			for i, arg := range args {
+				h := fnv.New32a()
+				binary.Write(h, binary.LittleEndian, []uint64{uint64(num), uint64(i), arg})
+				signal = append(signal, h.Sum32())
+			}
+		}
+		if ex.cover {
+			cover = c.Cover
+		}
+	}
+	for _, v := range []uint32{uint32(callIndex), uint32(num), errno, flags,
+		uint32(c.Time / time.Microsecond), uint32(len(signal)), uint32(len(cover))} {
+		ex.write(v)
+	}
+	for _, v := range signal {
+		ex.write(v)
+	}
+	for _, v := range cover {
+		ex.write(v)
+	}
+}
+
+func (ex *executor) input(pos uint64) uint64 {
+	if pos >= uint64(len(ex.in)/8) {
+		failf("input command overflows input")
+	}
+	return binary.LittleEndian.Uint64(ex.in[pos*8:])
+}
+
+func (ex *executor) output(pos int, v uint32) {
+	if pos >= len(ex.out)/4 {
+		failf("output overflow")
+	}
+	binary.LittleEndian.PutUint32(ex.out[pos*4:], v)
+}
+
+func (ex *executor) write(v uint32) {
+	ex.output(ex.outPos, v)
+	ex.outPos++
+}
+
+// fail writes the failure record with the reason (like fail in executor does) and fails.
+func (ex *executor) fail(reason uint32, msg string) {
+	binary.LittleEndian.PutUint32(ex.out[len(ex.out)-failureSize+4:], reason)
+	binary.LittleEndian.PutUint32(ex.out[len(ex.out)-failureSize:], failureMagic)
+	failf("%v", msg)
+}
+
+func mapFile(fd, prot int) []byte {
+	var st syscall.Stat_t
+	if err := syscall.Fstat(fd, &st); err != nil {
+		failf("failed to stat fd %v: %v", fd, err)
+	}
+	mem, err := syscall.Mmap(fd, 0, int(st.Size), prot, syscall.MAP_SHARED)
+	if err != nil {
+		failf("failed to mmap fd %v: %v", fd, err)
+	}
+	return mem
+}
+
+func failf(msg string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, msg+"\n", args...)
+	exit(statusFail)
+}
+
+var serving bool
+
+// exit duplicates the exit status on the pipe like executor does
+// (if the pipe is already used to signal that executor is serving).
+func exit(status int) {
+	if serving {
+		syscall.Write(outPipeFd, []byte{byte(status)})
+	}
+	os.Exit(status)
+}
@@ -5,7 +5,6 @@ package ipc
 
 import (
 	"bytes"
-	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -19,12 +18,14 @@ import (
 
 	"github.com/google/syzkaller/fileutil"
 	"github.com/google/syzkaller/prog"
+	"github.com/google/syzkaller/sys"
 )
 
 type Env struct {
 	In  []byte
 	Out []byte
 
+	batch   []byte // batch header: number of programs, prefix size and offsets of programs in input
 	cmd     *command
 	inFile  *os.File
 	outFile *os.File
@@ -45,6 +46,8 @@ const (
 	FlagSandboxSetuid                        // impersonate nobody user
 	FlagSandboxNamespace                     // use namespaces for sandboxing
 	FlagEnableTun                            // initialize and use tun in executor
+	FlagSyscallNumbers                       // calls are identified by kernel syscall numbers (see sys.Call.ExecNum)
+	FlagSandboxSeccomp                       // block noisy syscalls with a seccomp filter
 
 	outputSize   = 16 << 20
 	signalOffset = 15 << 20
@@ -52,18 +55,28 @@ const (
 	statusFail  = 67
 	statusError = 68
 	statusRetry = 69
-)
 
-var (
-	flagThreaded = flag.Bool("threaded", true, "use threaded mode in executor")
-	flagCollide  = flag.Bool("collide", true, "collide syscalls to provoke data races")
-	flagSignal   = flag.Bool("cover", true, "collect feedback signals (coverage)")
-	flagSandbox  = flag.String("sandbox", "setuid", "sandbox for fuzzing (none/setuid/namespace)")
-	flagDebug    = flag.Bool("debug", false, "debug output from executor")
-	// Executor protects against most hangs, so we use quite large timeout here.
-	// Executor can be slow due to global locks in namespaces and other things,
-	// so let's better wait than report false misleading crashes.
-	flagTimeout = flag.Duration("timeout", 1*time.Minute, "execution timeout")
+	// Executor writes handshake (magic, exec format version, syscall table hash
+	// and mask of supported flags) into the output region before it starts serving.
+	// Keep in sync with executor.cc.
+	handshakeMagic = 0xbadc0ffe
+	execVersion    = 4
+	handshakeSize  = 4 * 4
+	// The last words of the output region hold failure record: magic and failure reason.
+	failureMagic = 0xfa11ed00
+	failureSize  = 2 * 4
+
+	// MaxBatch is the maximum number of programs in a single ExecBatch request.
+	MaxBatch = 16
+	// Input starts with flags and pid followed by batch header.
+	inputHeaderSize = 2 * 8
+	batchHeaderSize = (2 + MaxBatch) * 8
+
+	// IPC timeout must be larger then executor timeout.
+	// Otherwise IPC will kill parent executor but leave child executor alive.
+	minTimeout = 7 * time.Second
+	// MakeEnv tries to start executor that many times.
+	startRetries = 3
 )
 
 // ExecutorFailure is returned from MakeEnv or from env.Exec when executor terminates by calling fail function.
@@ -74,37 +87,147 @@ func (err ExecutorFailure) Error() string {
 	return string(err)
 }
 
-func DefaultFlags() (uint64, time.Duration, error) {
-	var flags uint64
-	if *flagThreaded {
-		flags |= FlagThreaded
+// handshakeFailure is returned when executor binary does not match fuzzer (see checkHandshake).
+type handshakeFailure string
+
+func (err handshakeFailure) Error() string {
+	return string(err)
+}
+
+// The following errors are returned instead of ExecutorFailure when executor reports
+// that it failed to set up the test environment. They usually mean that the machine
+// is misconfigured (e.g. the kernel is built without kcov) rather than a bug in executor.
+type (
+	MmapFailure         string // executor failed to map shared memory
+	KcovFailure         string // kcov is not available
+	SandboxFailure      string // executor failed to set up sandbox
+	TunFailure          string // executor failed to set up tun device
+	SyscallTableFailure string // executor syscall table does not match descriptions
+)
+
+func (err MmapFailure) Error() string         { return string(err) }
+func (err KcovFailure) Error() string         { return string(err) }
+func (err SandboxFailure) Error() string      { return string(err) }
+func (err TunFailure) Error() string          { return string(err) }
+func (err SyscallTableFailure) Error() string { return string(err) }
+
+// FailureReason returns short description of the executor setup failure err (e.g. "kcov"),
+// or an empty string if err is not one of the setup failures.
+func FailureReason(err error) string {
+	switch err.(type) {
+	case MmapFailure:
+		return "mmap"
+	case KcovFailure:
+		return "kcov"
+	case SandboxFailure:
+		return "sandbox"
+	case TunFailure:
+		return "tun"
+	case SyscallTableFailure:
+		return "syscall table"
+	}
+	return ""
+}
+
+// Failure reasons reported by executor in the failure record (see fail in executor/common.h).
+const (
+	failReasonUnknown = iota
+	failReasonMmap
+	failReasonKcov
+	failReasonSandbox
+	failReasonTun
+	failReasonSyscallTable
+)
+
+// failure returns error that corresponds to the failure reason reported by executor.
+func failure(outmem []byte, msg string) error {
+	record := (*[2]uint32)(unsafe.Pointer(&outmem[len(outmem)-failureSize]))
+	if record[0] != failureMagic {
+		return ExecutorFailure(msg)
+	}
+	switch record[1] {
+	case failReasonMmap:
+		return MmapFailure(msg)
+	case failReasonKcov:
+		return KcovFailure(msg)
+	case failReasonSandbox:
+		return SandboxFailure(msg)
+	case failReasonTun:
+		return TunFailure(msg)
+	case failReasonSyscallTable:
+		return SyscallTableFailure(msg)
+	}
+	return ExecutorFailure(msg)
+}
+
+func clearFailure(outmem []byte) {
+	for i := len(outmem) - failureSize; i < len(outmem); i++ {
+		outmem[i] = 0
 	}
-	if *flagCollide {
-		flags |= FlagCollide
+}
+
+// FlagsString returns textual representation of executor configuration in flags:
+// sandbox followed by enabled options, e.g. "namespace,threaded,collide,cover".
+// It is used to pass per-proc configurations to fuzzer and to record them in execution logs.
+func FlagsString(flags uint64) string {
+	res := "none"
+	switch {
+	case flags&FlagSandboxSetuid != 0:
+		res = "setuid"
+	case flags&FlagSandboxNamespace != 0:
+		res = "namespace"
+	case flags&FlagSandboxSeccomp != 0:
+		res = "seccomp"
 	}
-	if *flagSignal {
-		flags |= FlagSignal
+	if flags&FlagThreaded != 0 {
+		res += ",threaded"
 	}
-	switch *flagSandbox {
+	if flags&FlagCollide != 0 {
+		res += ",collide"
+	}
+	if flags&FlagSignal != 0 {
+		res += ",cover"
+	}
+	return res
+}
+
+// ParseFlags parses executor configuration in FlagsString format.
+func ParseFlags(s string) (uint64, error) {
+	parts := strings.Split(s, ",")
+	var flags uint64
+	switch parts[0] {
 	case "none":
 	case "setuid":
 		flags |= FlagSandboxSetuid
 	case "namespace":
 		flags |= FlagSandboxNamespace
+	case "seccomp":
+		flags |= FlagSandboxSeccomp
 	default:
-		return 0, 0, fmt.Errorf("flag sandbox must contain one of none/setuid/namespace")
-	}
-	if *flagDebug {
-		flags |= FlagDebug
+		return 0, fmt.Errorf("bad executor config '%v': sandbox must be one of none/setuid/namespace/seccomp", s)
+	}
+	for _, opt := range parts[1:] {
+		switch opt {
+		case "threaded":
+			flags |= FlagThreaded
+		case "collide":
+			flags |= FlagCollide
+		case "cover":
+			flags |= FlagSignal
+		default:
+			return 0, fmt.Errorf("bad executor config '%v': unknown option '%v'", s, opt)
+		}
 	}
-	return flags, *flagTimeout, nil
+	return flags, nil
 }
 
 func MakeEnv(bin string, timeout time.Duration, flags uint64, pid int) (*Env, error) {
-	// IPC timeout must be larger then executor timeout.
-	// Otherwise IPC will kill parent executor but leave child executor alive.
-	if timeout < 7*time.Second {
-		timeout = 7 * time.Second
+	if timeout < minTimeout {
+		timeout = minTimeout
+	}
+	if sys.RuntimeDescriptions {
+		// Executor syscall table does not match the descriptions.
+		flags |= FlagSyscallNumbers
 	}
 	inf, inmem, err := createMapping(prog.ExecBufferSize)
 	if err != nil {
@@ -128,9 +251,9 @@ func MakeEnv(bin string, timeout time.Duration, flags uint64, pid int) (*Env, er
 		inmem[i] = byte(flags >> (8 * uint(i)))
 	}
 	*(*uint64)(unsafe.Pointer(&inmem[8])) = uint64(pid)
-	inmem = inmem[16:]
 	env := &Env{
-		In:      inmem,
+		In:      inmem[inputHeaderSize+batchHeaderSize:],
+		batch:   inmem[inputHeaderSize : inputHeaderSize+batchHeaderSize],
 		Out:     outmem,
 		inFile:  inf,
 		outFile: outf,
@@ -161,11 +284,35 @@ func MakeEnv(bin string, timeout time.Duration, flags uint64, pid int) (*Env, er
 	if err := os.Link(env.bin[0], binCopy); err == nil {
 		env.bin[0] = binCopy
 	}
+	// Start executor right away, so that we fail early
+	// if the executor binary does not match (see checkHandshake).
+	// Other failures can be temporal (e.g. out of memory), so retry them.
+	for try := 1; ; try++ {
+		env.StatRestarts++
+		env.cmd, err = makeCommand(pid, env.bin, flags, inf, outf, outmem)
+		if err == nil {
+			break
+		}
+		if try == startRetries || !retryStart(err) {
+			return nil, err
+		}
+		time.Sleep(time.Second)
+	}
 	inf = nil
 	outf = nil
 	return env, nil
 }
 
+// retryStart returns true if starting executor that failed with err can succeed on retry.
+// Executor bugs and mismatched executor binaries won't go away after a restart.
+func retryStart(err error) bool {
+	switch err.(type) {
+	case ExecutorFailure, SyscallTableFailure, handshakeFailure:
+		return false
+	}
+	return true
+}
+
 func (env *Env) Close() error {
 	if env.cmd != nil {
 		env.cmd.close()
@@ -186,7 +333,22 @@ type CallInfo struct {
 	Signal []uint32 // feedback signal, filled if FlagSignal is set
 	Cover  []uint32 // per-call coverage, filled if FlagSignal is set and cover == true,
 	//if dedup == false, then cov effectively contains a trace, otherwise duplicates are removed
-	Errno int // call errno (0 if the call was successful)
+	Errno int // call errno (0 if the call was successful, -1 if the call was not executed or did not finish)
+
+	Time     time.Duration // call execution time (for unfinished calls time since the call was started)
+	Blocked  bool          // call did not finish within threaded mode timeout and executor proceeded to next calls
+	Finished bool          // call finished before the program ended
+}
+
+// Flags in call output records written by executor.
+const (
+	callFlagFinished = 1 << iota
+	callFlagBlocked
+)
+
+// Flags returns executor flags of the env.
+func (env *Env) Flags() uint64 {
+	return env.flags
 }
 
 // Exec starts executor binary to execute program p and returns information about the execution:
@@ -196,46 +358,172 @@ type CallInfo struct {
 // hanged: program hanged and was killed
 // err0: failed to start process, or executor has detected a logical error
 func (env *Env) Exec(p *prog.Prog, cover, dedup bool) (output []byte, info []CallInfo, failed, hanged bool, err0 error) {
-	if p != nil {
-		// Copy-in serialized program.
-		if err := p.SerializeForExec(env.In, env.pid); err != nil {
-			err0 = fmt.Errorf("executor %v: failed to serialize: %v", env.pid, err)
+	var infos [][]CallInfo
+	output, infos, failed, hanged, _, err0 = env.ExecBatch([]*prog.Prog{p}, cover, dedup)
+	if len(infos) != 0 {
+		info = infos[0]
+	}
+	return
+}
+
+// ExecBatch is like Exec but executes up to MaxBatch programs in a single request to executor.
+// Programs are executed sequentially, each in a separate executor subprocess.
+// killed is the index of the program that hanged and was killed, or -1 if no program was killed.
+// The program is killed either by executor (then execution proceeds normally)
+// or by ipc after timeout (then hanged is set and executor is restarted).
+// Execution stops after the killed program, the rest of progs is not executed.
+// infos contains per-call info for the executed programs, if killed is not -1,
+// len(infos) is killed+1 and calls of the killed program that did not finish have Finished unset.
+func (env *Env) ExecBatch(progs []*prog.Prog, cover, dedup bool) (output []byte, infos [][]CallInfo, failed, hanged bool, killed int, err0 error) {
+	return env.execBatch(progs, 0, cover, dedup)
+}
+
+// ExecPrefix is like ExecBatch but all progs must start with the same prefix calls
+// (e.g. programs produced by prog.MutateTail of the same program).
+// Executor executes the prefix once and then executes the rest of each program
+// in a subprocess forked from the state after the prefix.
+// Per-call info of the prefix calls is the same for all programs
+// and does not contain signal and coverage.
+func (env *Env) ExecPrefix(progs []*prog.Prog, prefix int, cover, dedup bool) (output []byte, infos [][]CallInfo, failed, hanged bool, killed int, err0 error) {
+	killed = -1
+	if prefix <= 0 {
+		err0 = fmt.Errorf("executor %v: bad prefix size %v", env.pid, prefix)
+		return
+	}
+	var prefix0 []byte
+	for i, p := range progs {
+		if p == nil || len(p.Calls) < prefix {
+			err0 = fmt.Errorf("executor %v: program is shorter than prefix %v", env.pid, prefix)
+			return
+		}
+		data := serializePrefix(p, prefix)
+		if i == 0 {
+			prefix0 = data
+		} else if !bytes.Equal(data, prefix0) {
+			err0 = fmt.Errorf("executor %v: program %v has different prefix:\n%s\nprogram 0 has:\n%s",
+				env.pid, i, data, prefix0)
 			return
 		}
 	}
-	if env.flags&FlagSignal != 0 {
-		// Zero out the first two words (ncmd and nsig), so that we don't have garbage there
-		// if executor crashes before writing non-garbage there.
-		for i := 0; i < 4; i++ {
-			env.Out[i] = 0
+	return env.execBatch(progs, prefix, cover, dedup)
+}
+
+// serializePrefix serializes the first prefix calls of p.
+// Uses of results by the rest of the program don't affect the result
+// (programs can use different results of the prefix calls).
+func serializePrefix(p *prog.Prog, prefix int) []byte {
+	p = p.Clone()
+	p.TrimAfter(prefix - 1)
+	return p.Serialize()
+}
+
+func (env *Env) execBatch(progs []*prog.Prog, prefix int, cover, dedup bool) (output []byte, infos [][]CallInfo, failed, hanged bool, killed int, err0 error) {
+	killed = -1
+	if len(progs) == 0 || len(progs) > MaxBatch {
+		err0 = fmt.Errorf("executor %v: bad batch size %v", env.pid, len(progs))
+		return
+	}
+	// Copy-in serialized programs and record their offsets (in words from the input start).
+	batch := make([]uint64, len(progs)+2)
+	batch[0] = uint64(len(progs))
+	batch[1] = uint64(prefix)
+	pos := 0
+	for i, p := range progs {
+		batch[i+2] = uint64(inputHeaderSize+batchHeaderSize+pos) / 8
+		if p == nil {
+			// Program is already in env.In.
+			continue
 		}
+		n, err := p.SerializeForExec(env.In[pos:], env.pid)
+		if err != nil {
+			err0 = fmt.Errorf("executor %v: failed to serialize: %v", env.pid, err)
+			return
+		}
+		pos += n
+	}
+	for i, v := range batch {
+		*(*uint64)(unsafe.Pointer(&env.batch[i*8])) = v
 	}
 
-	atomic.AddUint64(&env.StatExecs, 1)
+	atomic.AddUint64(&env.StatExecs, uint64(len(progs)))
 	if env.cmd == nil {
 		atomic.AddUint64(&env.StatRestarts, 1)
-		env.cmd, err0 = makeCommand(env.pid, env.bin, env.timeout, env.flags, env.inFile, env.outFile)
+		env.cmd, err0 = makeCommand(env.pid, env.bin, env.flags, env.inFile, env.outFile, env.Out)
 		if err0 != nil {
 			return
 		}
 	}
+	// Zero out the first words (number of executed programs, killed flag and ncmd of the first program),
+	// so that we don't have garbage (or handshake) there if executor crashes before writing non-garbage there.
+	for i := 0; i < 12; i++ {
+		env.Out[i] = 0
+	}
+	clearFailure(env.Out)
+	// Each program can take up to executor timeout, give executor time to run all of them.
+	nexec := len(progs)
+	if prefix != 0 {
+		nexec++
+	}
+	timeout := env.timeout + time.Duration(nexec-1)*minTimeout
 	var restart bool
-	output, failed, hanged, restart, err0 = env.cmd.exec(cover, dedup)
+	output, failed, hanged, restart, err0 = env.cmd.exec(cover, dedup, timeout)
 	if err0 != nil || restart {
+		if hanged {
+			// Programs before the hanged one were executed, so that caller knows which one hanged.
+			infos, killed, _ = env.readOutCoverage(progs, true)
+		}
 		env.cmd.close()
 		env.cmd = nil
 		return
 	}
+	infos, killed, err0 = env.readOutCoverage(progs, false)
+	return
+}
 
-	if env.flags&FlagSignal == 0 || p == nil {
+// readOutCoverage parses output of a batch of progs.
+// hanged says that executor hanged and was killed by ipc.
+func (env *Env) readOutCoverage(progs []*prog.Prog, hanged bool) (infos [][]CallInfo, killed int, err0 error) {
+	killed = -1
+	out := ((*[1 << 28]uint32)(unsafe.Pointer(&env.Out[0])))[:len(env.Out)/int(unsafe.Sizeof(uint32(0)))]
+	nprogs, killedFlag := out[0], out[1]
+	out = out[2:]
+	if nprogs > uint32(len(progs)) {
+		err0 = fmt.Errorf("executor %v: executed %v programs, but batch has only %v", env.pid, nprogs, len(progs))
 		return
 	}
-	info, err0 = env.readOutCoverage(p)
+	infos = make([][]CallInfo, nprogs)
+	for i := range infos {
+		if progs[i] == nil {
+			return
+		}
+		infos[i], out, err0 = env.readProgCoverage(progs[i], out)
+		if err0 != nil {
+			infos = nil
+			return
+		}
+	}
+	switch {
+	case killedFlag != 0 && nprogs != 0:
+		killed = int(nprogs) - 1
+	case hanged && int(nprogs) < len(progs) && progs[nprogs] != nil:
+		// The program after the executed ones hanged, its output contains
+		// the calls that finished before the hang (if executor managed to write it).
+		p := progs[nprogs]
+		info, _, err := env.readProgCoverage(p, out)
+		if err != nil {
+			info = make([]CallInfo, len(p.Calls))
+			for i := range info {
+				info[i].Errno = -1 // not executed
+			}
+		}
+		infos = append(infos, info)
+		killed = int(nprogs)
+	}
 	return
 }
 
-func (env *Env) readOutCoverage(p *prog.Prog) (info []CallInfo, err0 error) {
-	out := ((*[1 << 28]uint32)(unsafe.Pointer(&env.Out[0])))[:len(env.Out)/int(unsafe.Sizeof(uint32(0)))]
+// readProgCoverage parses output of a single program from out and returns the rest of out.
+func (env *Env) readProgCoverage(p *prog.Prog, out []uint32) (info []CallInfo, rest []uint32, err0 error) {
 	readOut := func(v *uint32) bool {
 		if len(out) == 0 {
 			return false
@@ -266,8 +554,9 @@ func (env *Env) readOutCoverage(p *prog.Prog) (info []CallInfo, err0 error) {
 		return buf.String()
 	}
 	for i := uint32(0); i < ncmd; i++ {
-		var callIndex, callNum, errno, signalSize, coverSize uint32
-		if !readOut(&callIndex) || !readOut(&callNum) || !readOut(&errno) || !readOut(&signalSize) || !readOut(&coverSize) {
+		var callIndex, callNum, errno, callFlags, callTime, signalSize, coverSize uint32
+		if !readOut(&callIndex) || !readOut(&callNum) || !readOut(&errno) || !readOut(&callFlags) ||
+			!readOut(&callTime) || !readOut(&signalSize) || !readOut(&coverSize) {
 			err0 = fmt.Errorf("executor %v: failed to read output coverage", env.pid)
 			return
 		}
@@ -277,7 +566,7 @@ func (env *Env) readOutCoverage(p *prog.Prog) (info []CallInfo, err0 error) {
 			return
 		}
 		c := p.Calls[callIndex]
-		if num := c.Meta.ID; uint32(num) != callNum {
+		if num := c.Meta.ExecNum(); uint32(num) != callNum {
 			err0 = fmt.Errorf("executor %v: failed to read output coverage: record %v call %v: expect syscall %v, got %v, executed %v (cov: %v)",
 				env.pid, i, callIndex, num, callNum, ncmd, dumpCov())
 			return
@@ -287,7 +576,13 @@ func (env *Env) readOutCoverage(p *prog.Prog) (info []CallInfo, err0 error) {
 				env.pid, callIndex, dumpCov())
 			return
 		}
-		info[callIndex].Errno = int(errno)
+		inf := &info[callIndex]
+		inf.Time = time.Duration(callTime) * time.Microsecond
+		inf.Blocked = callFlags&callFlagBlocked != 0
+		inf.Finished = callFlags&callFlagFinished != 0
+		if inf.Finished {
+			inf.Errno = int(errno)
+		}
 		if signalSize > uint32(len(out)) {
 			err0 = fmt.Errorf("executor %v: failed to read output signal: record %v, call %v, signalsize=%v coversize=%v",
 				env.pid, i, callIndex, signalSize, coverSize)
@@ -303,6 +598,7 @@ func (env *Env) readOutCoverage(p *prog.Prog) (info []CallInfo, err0 error) {
 		info[callIndex].Cover = out[:coverSize:coverSize]
 		out = out[coverSize:]
 	}
+	rest = out
 	return
 }
 
@@ -354,26 +650,26 @@ func closeMapping(f *os.File, mem []byte) error {
 
 type command struct {
 	pid      int
-	timeout  time.Duration
 	cmd      *exec.Cmd
 	flags    uint64
 	dir      string
 	readDone chan []byte
 	inrp     *os.File
 	outwp    *os.File
+	outmem   []byte
 }
 
-func makeCommand(pid int, bin []string, timeout time.Duration, flags uint64, inFile *os.File, outFile *os.File) (*command, error) {
+func makeCommand(pid int, bin []string, flags uint64, inFile *os.File, outFile *os.File, outmem []byte) (*command, error) {
 	dir, err := ioutil.TempDir("./", "syzkaller-testdir")
 	if err != nil {
 		return nil, fmt.Errorf("failed to create temp dir: %v", err)
 	}
 
 	c := &command{
-		pid:     pid,
-		timeout: timeout,
-		flags:   flags,
-		dir:     dir,
+		pid:    pid,
+		flags:  flags,
+		dir:    dir,
+		outmem: outmem,
 	}
 	defer func() {
 		if c != nil {
@@ -412,6 +708,12 @@ func makeCommand(pid int, bin []string, timeout time.Duration, flags uint64, inF
 
 	c.readDone = make(chan []byte, 1)
 
+	// Zero out handshake, so that we don't accept a stale one from the previous executor.
+	for i := 0; i < handshakeSize; i++ {
+		outmem[i] = 0
+	}
+	clearFailure(outmem)
+
 	cmd := exec.Command(bin[0], bin[1:]...)
 	cmd.ExtraFiles = []*os.File{inFile, outFile, outrp, inwp}
 	cmd.Env = []string{}
@@ -455,6 +757,9 @@ func makeCommand(pid int, bin []string, timeout time.Duration, flags uint64, inF
 	if err := c.waitServing(); err != nil {
 		return nil, err
 	}
+	if err := checkHandshake(outmem, flags); err != nil {
+		return nil, err
+	}
 
 	tmp := c
 	c = nil // disable defer above
@@ -498,7 +803,7 @@ func (c *command) waitServing() error {
 				if ws, ok := sys.(syscall.WaitStatus); ok {
 					// Magic values returned by executor.
 					if ws.ExitStatus() == statusFail {
-						err = ExecutorFailure(fmt.Sprintf("executor is not serving:\n%s", output))
+						err = failure(c.outmem, fmt.Sprintf("executor is not serving:\n%s", output))
 					}
 				}
 			}
@@ -509,11 +814,39 @@ func (c *command) waitServing() error {
 	}
 }
 
+// checkHandshake checks that executor speaks the same protocol version,
+// was generated from the same syscall descriptions and supports all flags.
+func checkHandshake(outmem []byte, flags uint64) error {
+	out := (*[4]uint32)(unsafe.Pointer(&outmem[0]))
+	magic, version, hash, supported := out[0], out[1], out[2], uint64(out[3])
+	if magic != handshakeMagic {
+		return handshakeFailure(fmt.Sprintf("executor did not send handshake (got magic 0x%x),"+
+			" executor binary is probably too old, rebuild it", magic))
+	}
+	if version != execVersion {
+		return handshakeFailure(fmt.Sprintf("executor exec format version %v does not match fuzzer version %v,"+
+			" rebuild executor", version, execVersion))
+	}
+	// With runtime descriptions calls are identified by syscall numbers,
+	// so the table does not need to match.
+	if flags&FlagSyscallNumbers == 0 {
+		if want := sys.CallsHash(); hash != want {
+			return SyscallTableFailure(fmt.Sprintf("executor syscall table hash 0x%08x does not match"+
+				" descriptions hash 0x%08x, executor was generated from different descriptions, rebuild it",
+				hash, want))
+		}
+	}
+	if unsupported := flags &^ supported; unsupported != 0 {
+		return handshakeFailure(fmt.Sprintf("executor does not support flags 0x%x", unsupported))
+	}
+	return nil
+}
+
 func (c *command) kill() {
 	syscall.Kill(c.cmd.Process.Pid, syscall.SIGKILL)
 }
 
-func (c *command) exec(cover, dedup bool) (output []byte, failed, hanged, restart bool, err0 error) {
+func (c *command) exec(cover, dedup bool, timeout time.Duration) (output []byte, failed, hanged, restart bool, err0 error) {
 	var flags [1]byte
 	if cover {
 		flags[0] |= 1 << 0
@@ -529,7 +862,7 @@ func (c *command) exec(cover, dedup bool) (output []byte, failed, hanged, restar
 	done := make(chan bool)
 	hang := make(chan bool)
 	go func() {
-		t := time.NewTimer(c.timeout)
+		t := time.NewTimer(timeout)
 		select {
 		case <-t.C:
 			c.kill()
@@ -576,7 +909,7 @@ func (c *command) exec(cover, dedup bool) (output []byte, failed, hanged, restar
 	// Handle magic values returned by executor.
 	switch status {
 	case statusFail:
-		err0 = ExecutorFailure(fmt.Sprintf("executor failed: %s", output))
+		err0 = failure(c.outmem, fmt.Sprintf("executor failed: %s", output))
 	case statusError:
 		failed = true
 	case statusRetry:
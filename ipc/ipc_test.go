@@ -4,18 +4,27 @@
 package ipc
 
 import (
+	"fmt"
 	"math/rand"
 	"os"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/google/syzkaller/csource"
 	"github.com/google/syzkaller/fileutil"
+	"github.com/google/syzkaller/ipc/fakeexec"
 	"github.com/google/syzkaller/prog"
 )
 
 const timeout = 10 * time.Second
 
+func TestMain(m *testing.M) {
+	fakeexec.Main()
+	os.Exit(m.Run())
+}
+
 func buildExecutor(t *testing.T) string {
 	return buildProgram(t, "../executor/executor.cc")
 }
@@ -95,3 +104,598 @@ func TestExecute(t *testing.T) {
 		}
 	}
 }
+
+func TestSandboxSeccomp(t *testing.T) {
+	bin := buildExecutor(t)
+	defer os.Remove(bin)
+
+	// Attaching to a non-existent process fails with ESRCH, unless the call is blocked.
+	p := deserialize(t, "ptrace(0x10, 0xffffffffffffffff)\n")
+	for _, test := range []struct {
+		flags uint64
+		errno syscall.Errno
+	}{
+		{0, syscall.ESRCH},
+		{FlagSandboxSeccomp, syscall.EPERM},
+	} {
+		env, err := MakeEnv(bin, timeout, test.flags, 0)
+		if _, ok := err.(SandboxFailure); ok {
+			t.Skipf("seccomp is not supported: %v", err)
+		}
+		if err != nil {
+			t.Fatalf("failed to create env: %v", err)
+		}
+		output, info, failed, hanged, err := env.Exec(p, false, false)
+		env.Close()
+		if err != nil || failed || hanged {
+			t.Fatalf("failed to run executor: failed=%v hanged=%v err=%v\n%s", failed, hanged, err, output)
+		}
+		if len(info) != 1 || info[0].Errno != int(test.errno) {
+			t.Fatalf("flags 0x%x: want errno %v, got info %+v", test.flags, int(test.errno), info)
+		}
+	}
+}
+
+func TestExtractTcpRes(t *testing.T) {
+	bin := buildExecutor(t)
+	defer os.Remove(bin)
+
+	// Namespace sandbox gives a fresh network namespace, so the port is not busy.
+	env, err := MakeEnv(bin, timeout, FlagSandboxNamespace|FlagEnableTun, 0)
+	switch err.(type) {
+	case SandboxFailure:
+		t.Skipf("namespace sandbox is not supported: %v", err)
+	case TunFailure:
+		t.Skipf("tun is not supported: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("failed to create env: %v", err)
+	}
+	defer env.Close()
+
+	// Establish a tcp connection with a listening socket over tun:
+	// send SYN, extract sequence numbers from SYN-ACK and use them in ACK.
+	// accept succeeds only if the extracted numbers and checksums are correct.
+	const packet = "{@local={[0xaa, 0xaa, 0xaa, 0xaa, 0xaa], 0x0}, @remote={[0xbb, 0xbb, 0xbb, 0xbb, 0xbb], 0x0}, [], " +
+		"{{0x800, @ipv4={{0x5, 0x4, 0x0, 0x0, 0x28, 0x0, 0x0, 0x40, 0x6, 0x0, @remote={0xac, 0x14, 0x0, 0xbb}, @local={0xac, 0x14, 0x0, 0xaa}, {[]}}, " +
+		"@tcp={{0x1, 0x0, %v, %v, 0x0, 0x0, 0x5, %v, 0x1000, 0x0, 0x0, {[]}}, {\"\"}}}}}}"
+	p := deserialize(t, "mmap(&(0x7f0000000000/0x1000)=nil, (0x1000), 0x3, 0x32, 0xffffffffffffffff, 0x0)\n"+
+		"r0 = socket$inet(0x2, 0x801, 0x0)\n"+
+		"bind$inet(r0, &(0x7f0000000000)={0x2, 0x0, @empty=0x0, [0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0]}, 0x10)\n"+
+		"listen(r0, 0x5)\n"+
+		"syz_emit_ethernet(0x36, &(0x7f0000000000+0x100)="+fmt.Sprintf(packet, "0x42424242", "0x0", "0x2")+")\n"+
+		"syz_extract_tcp_res$synack(&(0x7f0000000000+0x200)={<r1=>0x0, <r2=>0x0}, 0x1, 0x0)\n"+
+		"syz_emit_ethernet(0x36, &(0x7f0000000000+0x300)="+fmt.Sprintf(packet, "r2", "r1", "0x10")+")\n"+
+		"accept(r0, 0x0, &(0x7f0000000000+0x400)=0x0)\n")
+	output, info, failed, hanged, err := env.Exec(p, false, false)
+	if err != nil || failed || hanged {
+		t.Fatalf("failed to run executor: failed=%v hanged=%v err=%v\n%s", failed, hanged, err, output)
+	}
+	if len(info) != len(p.Calls) {
+		t.Fatalf("executed %v calls, want %v", len(info), len(p.Calls))
+	}
+	for _, idx := range []int{2, 5, 7} {
+		if info[idx].Errno != 0 {
+			t.Fatalf("%v failed with errno %v", p.Calls[idx].Meta.Name, info[idx].Errno)
+		}
+	}
+}
+
+func TestExecBatch(t *testing.T) {
+	bin := buildExecutor(t)
+	defer os.Remove(bin)
+
+	rs, iters := initTest(t)
+	env, err := MakeEnv(bin, timeout, FlagThreaded, 0)
+	if err != nil {
+		t.Fatalf("failed to create env: %v", err)
+	}
+	defer env.Close()
+
+	var empty []*prog.Prog
+	for i := 0; i < MaxBatch; i++ {
+		empty = append(empty, new(prog.Prog))
+	}
+	_, infos, failed, hanged, killed, err := env.ExecBatch(empty, false, false)
+	if err != nil {
+		t.Fatalf("failed to run executor: %v", err)
+	}
+	if failed || hanged || killed != -1 {
+		t.Fatalf("empty programs failed")
+	}
+	if len(infos) != len(empty) {
+		t.Fatalf("executed %v programs, want %v", len(infos), len(empty))
+	}
+
+	for i := 0; i < iters/5+1; i++ {
+		var progs []*prog.Prog
+		for j := 0; j < 5; j++ {
+			progs = append(progs, prog.Generate(rs, 10, nil))
+		}
+		output, infos, _, _, killed, err := env.ExecBatch(progs, false, false)
+		if err != nil {
+			t.Fatalf("failed to run executor: %v\n%s", err, output)
+		}
+		// Execution stops after a hanged program, but at least one must be executed.
+		if len(infos) == 0 || len(infos) > len(progs) {
+			t.Fatalf("executed %v programs out of %v", len(infos), len(progs))
+		}
+		if killed == -1 && len(infos) != len(progs) || killed != -1 && len(infos) != killed+1 {
+			t.Fatalf("executed %v programs out of %v, killed %v", len(infos), len(progs), killed)
+		}
+	}
+	if _, _, _, _, _, err := env.ExecBatch(append(empty, new(prog.Prog)), false, false); err == nil {
+		t.Fatalf("no error for too large batch")
+	}
+}
+
+func TestCallInfo(t *testing.T) {
+	bin := buildExecutor(t)
+	defer os.Remove(bin)
+
+	env, err := MakeEnv(bin, timeout, FlagThreaded, 0)
+	if err != nil {
+		t.Fatalf("failed to create env: %v", err)
+	}
+	defer env.Close()
+
+	// nanosleep blocks for 500ms and does not finish before the end of the program.
+	p, err := prog.Deserialize([]byte(
+		"mmap(&(0x7f0000000000/0x1000)=nil, (0x1000), 0x3, 0x32, 0xffffffffffffffff, 0x0)\n" +
+			"nanosleep(&(0x7f0000000000)={0x0, 0x1dcd6500}, 0x0)\n" +
+			"getpid()\n"))
+	if err != nil {
+		t.Fatalf("failed to deserialize program: %v", err)
+	}
+	output, info, failed, hanged, err := env.Exec(p, false, false)
+	if err != nil || failed || hanged {
+		t.Fatalf("failed to run executor: failed=%v hanged=%v err=%v\n%s", failed, hanged, err, output)
+	}
+	if len(info) != 3 {
+		t.Fatalf("got info for %v calls, want 3", len(info))
+	}
+	if inf := info[1]; inf.Finished || !inf.Blocked || inf.Errno != -1 || inf.Time < 20*time.Millisecond {
+		t.Fatalf("bad info for blocked call: %+v", inf)
+	}
+	if inf := info[2]; !inf.Finished || inf.Blocked || inf.Errno != 0 {
+		t.Fatalf("bad info for getpid: %+v", inf)
+	}
+}
+
+func TestExecPrefix(t *testing.T) {
+	bin := buildExecutor(t)
+	defer os.Remove(bin)
+
+	env, err := MakeEnv(bin, timeout, FlagThreaded, 0)
+	if err != nil {
+		t.Fatalf("failed to create env: %v", err)
+	}
+	defer env.Close()
+
+	// Programs share mmap and pipe2 calls, but use different results of pipe2,
+	// so copyouts of the prefix calls are different.
+	const prefix = "mmap(&(0x7f0000000000/0x2000)=nil, (0x2000), 0x3, 0x32, 0xffffffffffffffff, 0x0)\n"
+	srcs := []string{
+		prefix + "pipe2(&(0x7f0000000000)={0x0, <r0=>0x0}, 0x0)\n" +
+			"write(r0, &(0x7f0000001000)=\"01\", 0x1)\n",
+		prefix + "pipe2(&(0x7f0000000000)={<r0=>0x0, 0x0}, 0x0)\n" +
+			"close(r0)\n" +
+			"getpid()\n",
+		prefix + "pipe2(&(0x7f0000000000)={0x0, 0x0}, 0x0)\n",
+	}
+	var progs []*prog.Prog
+	for _, src := range srcs {
+		p, err := prog.Deserialize([]byte(src))
+		if err != nil {
+			t.Fatalf("failed to deserialize program: %v", err)
+		}
+		progs = append(progs, p)
+	}
+	output, infos, failed, hanged, _, err := env.ExecPrefix(progs, 2, false, false)
+	if err != nil || failed || hanged {
+		t.Fatalf("failed to run executor: failed=%v hanged=%v err=%v\n%s", failed, hanged, err, output)
+	}
+	if len(infos) != len(progs) {
+		t.Fatalf("executed %v programs, want %v", len(infos), len(progs))
+	}
+	for i, info := range infos {
+		if len(info) != len(progs[i].Calls) {
+			t.Fatalf("program %v: got info for %v calls, want %v", i, len(info), len(progs[i].Calls))
+		}
+		for j, inf := range info {
+			if !inf.Finished || inf.Errno != 0 {
+				t.Fatalf("program %v: call %v failed: %+v", i, j, inf)
+			}
+		}
+	}
+
+	p, err := prog.Deserialize([]byte("getpid()\ngetpid()\n"))
+	if err != nil {
+		t.Fatalf("failed to deserialize program: %v", err)
+	}
+	if _, _, _, _, _, err := env.ExecPrefix(append(progs, p), 2, false, false); err == nil {
+		t.Fatalf("no error for programs with different prefixes")
+	}
+	// Same calls, but different arguments.
+	p, err = prog.Deserialize([]byte(prefix + "pipe2(&(0x7f0000000000)={0x0, 0x0}, 0x80000)\n"))
+	if err != nil {
+		t.Fatalf("failed to deserialize program: %v", err)
+	}
+	if _, _, _, _, _, err := env.ExecPrefix(append(progs, p), 2, false, false); err == nil {
+		t.Fatalf("no error for programs with different prefix arguments")
+	}
+}
+
+func makeFakeEnv(t *testing.T, script *fakeexec.Script, flags uint64) (*Env, func()) {
+	bin, cleanup, err := fakeexec.Command(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err := MakeEnv(bin, timeout, flags, 0)
+	if err != nil {
+		cleanup()
+		t.Fatalf("failed to create env: %v", err)
+	}
+	return env, func() {
+		env.Close()
+		cleanup()
+	}
+}
+
+func deserialize(t *testing.T, src string) *prog.Prog {
+	p, err := prog.Deserialize([]byte(src))
+	if err != nil {
+		t.Fatalf("failed to deserialize program: %v", err)
+	}
+	return p
+}
+
+func TestFakeExec(t *testing.T) {
+	script := &fakeexec.Script{
+		Calls: map[string]*fakeexec.Call{
+			"getpid": {Signal: []uint32{1, 2}, Cover: []uint32{10, 20, 30}, Time: time.Millisecond},
+			"close":  {Errno: 9},
+			"pause":  {Blocked: true},
+		},
+	}
+	env, cleanup := makeFakeEnv(t, script, FlagSignal)
+	defer cleanup()
+
+	p := deserialize(t, "getpid()\nclose(0xffffffffffffffff)\npause()\n")
+	output, info, failed, hanged, err := env.Exec(p, true, true)
+	if err != nil || failed || hanged {
+		t.Fatalf("failed to run executor: failed=%v hanged=%v err=%v\n%s", failed, hanged, err, output)
+	}
+	if len(info) != 3 {
+		t.Fatalf("got info for %v calls, want 3", len(info))
+	}
+	if inf := info[0]; !inf.Finished || inf.Errno != 0 || len(inf.Signal) != 2 || len(inf.Cover) != 3 ||
+		inf.Time != time.Millisecond {
+		t.Fatalf("bad info for getpid: %+v", inf)
+	}
+	if inf := info[1]; !inf.Finished || inf.Errno != 9 || len(inf.Signal) != 0 {
+		t.Fatalf("bad info for close: %+v", inf)
+	}
+	if inf := info[2]; inf.Finished || !inf.Blocked || inf.Errno != -1 {
+		t.Fatalf("bad info for pause: %+v", inf)
+	}
+	// Coverage is returned only when requested.
+	_, info, _, _, err = env.Exec(p, false, false)
+	if err != nil || len(info) != 3 || len(info[0].Signal) != 2 || len(info[0].Cover) != 0 {
+		t.Fatalf("bad info without coverage: err=%v info=%+v", err, info)
+	}
+}
+
+func TestFakeRestart(t *testing.T) {
+	script := &fakeexec.Script{
+		Calls: map[string]*fakeexec.Call{
+			"sync": {Retry: true},
+		},
+	}
+	env, cleanup := makeFakeEnv(t, script, 0)
+	defer cleanup()
+
+	for i := 0; i < 3; i++ {
+		output, info, failed, hanged, err := env.Exec(deserialize(t, "getpid()\nsync()\n"), false, false)
+		if err != nil || failed || hanged || info != nil {
+			t.Fatalf("bad result for retried program: failed=%v hanged=%v err=%v info=%+v\n%s",
+				failed, hanged, err, info, output)
+		}
+	}
+	output, info, failed, hanged, err := env.Exec(deserialize(t, "getpid()\n"), false, false)
+	if err != nil || failed || hanged || len(info) != 1 || info[0].Errno != 0 {
+		t.Fatalf("failed to run executor: failed=%v hanged=%v err=%v info=%+v\n%s",
+			failed, hanged, err, info, output)
+	}
+	if env.StatRestarts != 4 {
+		t.Fatalf("executor restarted %v times, want 4", env.StatRestarts)
+	}
+}
+
+func TestFakeFailure(t *testing.T) {
+	script := &fakeexec.Script{
+		Calls: map[string]*fakeexec.Call{
+			"sync":  {Fail: "fake executor failure"},
+			"fsync": {Crash: "BUG: fake kernel bug"},
+		},
+	}
+	env, cleanup := makeFakeEnv(t, script, 0)
+	defer cleanup()
+
+	_, _, _, _, err := env.Exec(deserialize(t, "sync()\n"), false, false)
+	if _, ok := err.(ExecutorFailure); !ok || !strings.Contains(err.Error(), "fake executor failure") {
+		t.Fatalf("want ExecutorFailure, got %v", err)
+	}
+	output, _, failed, hanged, err := env.Exec(deserialize(t, "fsync(0xffffffffffffffff)\n"), false, false)
+	if !failed || hanged || !strings.Contains(string(output), "BUG: fake kernel bug") {
+		t.Fatalf("want failed with kernel bug, got failed=%v hanged=%v err=%v\n%s", failed, hanged, err, output)
+	}
+
+	err = makeFakeEnvErr(t, &fakeexec.Script{StartFail: "fake start failure"}, 0)
+	if _, ok := err.(ExecutorFailure); !ok {
+		t.Fatalf("want ExecutorFailure, got %v", err)
+	}
+}
+
+func TestFakeFailureReason(t *testing.T) {
+	script := &fakeexec.Script{
+		Calls: map[string]*fakeexec.Call{
+			"sync":  {Fail: "kcov is broken", FailReason: failReasonKcov},
+			"fsync": {Fail: "bad syscall", FailReason: failReasonSyscallTable},
+		},
+	}
+	env, cleanup := makeFakeEnv(t, script, 0)
+	defer cleanup()
+
+	_, _, _, _, err := env.Exec(deserialize(t, "sync()\n"), false, false)
+	if _, ok := err.(KcovFailure); !ok || FailureReason(err) != "kcov" {
+		t.Fatalf("want KcovFailure, got %#v", err)
+	}
+	// The failure record must be cleared before the next execution.
+	_, _, _, _, err = env.Exec(deserialize(t, "getpid()\n"), false, false)
+	if err != nil {
+		t.Fatalf("failed to run executor: %v", err)
+	}
+	_, _, _, _, err = env.Exec(deserialize(t, "fsync(0xffffffffffffffff)\n"), false, false)
+	if _, ok := err.(SyscallTableFailure); !ok {
+		t.Fatalf("want SyscallTableFailure, got %#v", err)
+	}
+
+	err = makeFakeEnvErr(t, &fakeexec.Script{StartFail: "no tun", StartFailReason: failReasonTun}, 0)
+	if _, ok := err.(TunFailure); !ok || FailureReason(err) != "tun" {
+		t.Fatalf("want TunFailure, got %#v", err)
+	}
+	if reason := FailureReason(ExecutorFailure("assert")); reason != "" {
+		t.Fatalf("ExecutorFailure has reason %q", reason)
+	}
+}
+
+// TestKcovFailure checks that the real executor reports unavailable kcov as KcovFailure.
+func TestKcovFailure(t *testing.T) {
+	if _, err := os.Stat("/sys/kernel/debug/kcov"); err == nil {
+		t.Skip("kcov is available")
+	}
+	bin := buildExecutor(t)
+	defer os.Remove(bin)
+	env, err := MakeEnv(bin, timeout, FlagSignal, 0)
+	if err == nil {
+		env.Close()
+	}
+	if _, ok := err.(KcovFailure); !ok {
+		t.Fatalf("want KcovFailure, got %#v", err)
+	}
+}
+
+func TestFakeHandshake(t *testing.T) {
+	tests := []struct {
+		script *fakeexec.Script
+		flags  uint64
+		err    string
+	}{
+		{&fakeexec.Script{Version: execVersion + 1}, 0, "exec format version"},
+		{&fakeexec.Script{CallsHash: 1}, 0, "syscall table hash"},
+		{&fakeexec.Script{SupportedFlags: uint32(FlagSignal)}, FlagSignal | FlagThreaded, "does not support flags 0x4"},
+	}
+	for i, test := range tests {
+		err := makeFakeEnvErr(t, test.script, test.flags)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Fatalf("#%v: want error %q, got %v", i, test.err, err)
+		}
+	}
+	if _, ok := makeFakeEnvErr(t, &fakeexec.Script{CallsHash: 1}, 0).(SyscallTableFailure); !ok {
+		t.Fatalf("want SyscallTableFailure on syscall table hash mismatch")
+	}
+	// Syscall table does not matter when calls are identified by syscall numbers.
+	env, cleanup := makeFakeEnv(t, &fakeexec.Script{CallsHash: 1}, FlagSyscallNumbers)
+	cleanup()
+	if env.StatRestarts != 1 {
+		t.Fatalf("executor started %v times, want 1", env.StatRestarts)
+	}
+}
+
+func TestFakeStartRetry(t *testing.T) {
+	tests := []struct {
+		script   *fakeexec.Script
+		restarts uint64
+		err      bool
+	}{
+		// Setup failures can be temporal.
+		{&fakeexec.Script{StartFail: "no tun", StartFailReason: failReasonTun, StartFailCount: 1}, 2, false},
+		{&fakeexec.Script{StartFail: "no tun", StartFailReason: failReasonTun, StartFailCount: startRetries - 1}, startRetries, false},
+		{&fakeexec.Script{StartFail: "no tun", StartFailReason: failReasonTun, StartFailCount: startRetries}, 0, true},
+		// Executor bugs and mismatched executor are not retried.
+		{&fakeexec.Script{StartFail: "fake start failure", StartFailCount: 1}, 0, true},
+		{&fakeexec.Script{Version: execVersion + 1}, 0, true},
+	}
+	for i, test := range tests {
+		bin, cleanup, err := fakeexec.Command(test.script)
+		if err != nil {
+			t.Fatal(err)
+		}
+		env, err := MakeEnv(bin, timeout, 0, 0)
+		cleanup()
+		if test.err {
+			if err == nil {
+				env.Close()
+				t.Fatalf("#%v: MakeEnv did not fail", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("#%v: MakeEnv failed: %v", i, err)
+		}
+		env.Close()
+		if env.StatRestarts != test.restarts {
+			t.Fatalf("#%v: executor started %v times, want %v", i, env.StatRestarts, test.restarts)
+		}
+	}
+}
+
+// makeFakeEnvErr returns the error from MakeEnv with the fake executor.
+func makeFakeEnvErr(t *testing.T, script *fakeexec.Script, flags uint64) error {
+	bin, cleanup, err := fakeexec.Command(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	env, err := MakeEnv(bin, timeout, flags, 0)
+	if err == nil {
+		env.Close()
+	}
+	return err
+}
+
+func TestFakeHang(t *testing.T) {
+	if testing.Short() {
+		t.Skip("hang detection takes at least executor timeout")
+	}
+	script := &fakeexec.Script{
+		Calls: map[string]*fakeexec.Call{
+			"pause": {Hang: true},
+		},
+	}
+	env, cleanup := makeFakeEnv(t, script, 0)
+	defer cleanup()
+
+	_, _, failed, hanged, err := env.Exec(deserialize(t, "pause()\n"), false, false)
+	if failed || !hanged || err == nil {
+		t.Fatalf("want hanged, got failed=%v hanged=%v err=%v", failed, hanged, err)
+	}
+	// The executor must be restarted.
+	_, info, failed, hanged, err := env.Exec(deserialize(t, "getpid()\n"), false, false)
+	if err != nil || failed || hanged || len(info) != 1 {
+		t.Fatalf("failed to run executor after hang: failed=%v hanged=%v err=%v", failed, hanged, err)
+	}
+}
+
+func TestFakeHangBatch(t *testing.T) {
+	if testing.Short() {
+		t.Skip("hang detection takes at least executor timeout")
+	}
+	script := &fakeexec.Script{
+		Calls: map[string]*fakeexec.Call{
+			"pause": {Hang: true},
+		},
+	}
+	env, cleanup := makeFakeEnv(t, script, 0)
+	defer cleanup()
+
+	progs := []*prog.Prog{
+		deserialize(t, "getpid()\n"),
+		deserialize(t, "getpid()\npause()\n"),
+		deserialize(t, "getpid()\n"),
+	}
+	_, infos, failed, hanged, killed, err := env.ExecBatch(progs, false, false)
+	if failed || !hanged || err == nil {
+		t.Fatalf("want hanged, got failed=%v hanged=%v err=%v", failed, hanged, err)
+	}
+	if killed != 1 || len(infos) != 2 {
+		t.Fatalf("got killed %v and info for %v programs, want killed 1 and 2 programs", killed, len(infos))
+	}
+	// The hanged program has info for the calls that finished before the hang.
+	if info := infos[1]; !info[0].Finished || info[1].Finished || info[1].Errno != -1 {
+		t.Fatalf("bad info for the hanged program: %+v", info)
+	}
+}
+
+func TestFakeKill(t *testing.T) {
+	script := &fakeexec.Script{
+		Calls: map[string]*fakeexec.Call{
+			"pause": {Kill: true},
+		},
+	}
+	env, cleanup := makeFakeEnv(t, script, 0)
+	defer cleanup()
+
+	progs := []*prog.Prog{
+		deserialize(t, "getpid()\n"),
+		deserialize(t, "getpid()\npause()\nsync()\n"),
+		deserialize(t, "getpid()\n"),
+	}
+	tests := []struct {
+		progs  []*prog.Prog
+		killed int
+	}{
+		{progs, 1},
+		{progs[1:], 0},
+		{[]*prog.Prog{progs[0], progs[2]}, -1},
+	}
+	for i, test := range tests {
+		_, infos, failed, hanged, killed, err := env.ExecBatch(test.progs, false, false)
+		if err != nil || failed || hanged {
+			t.Fatalf("#%v: failed to run executor: failed=%v hanged=%v err=%v", i, failed, hanged, err)
+		}
+		if killed != test.killed {
+			t.Fatalf("#%v: killed program %v, want %v", i, killed, test.killed)
+		}
+		want := len(test.progs)
+		if killed != -1 {
+			want = killed + 1
+		}
+		if len(infos) != want {
+			t.Fatalf("#%v: got info for %v programs, want %v", i, len(infos), want)
+		}
+		if killed == -1 {
+			continue
+		}
+		// Calls before the hanged one finished, the rest did not.
+		info := infos[killed]
+		if !info[0].Finished || info[1].Finished || info[1].Errno != -1 || info[2].Finished {
+			t.Fatalf("#%v: bad info for the killed program: %+v", i, info)
+		}
+	}
+	// Executor kills the program itself, so it does not need to be restarted.
+	if env.StatRestarts != 1 {
+		t.Fatalf("executor was restarted %v times", env.StatRestarts-1)
+	}
+}
+
+func TestFlagsString(t *testing.T) {
+	tests := []struct {
+		flags uint64
+		str   string
+	}{
+		{0, "none"},
+		{FlagSandboxSetuid | FlagThreaded | FlagCollide | FlagSignal, "setuid,threaded,collide,cover"},
+		{FlagSandboxNamespace | FlagSignal, "namespace,cover"},
+		{FlagSandboxSeccomp | FlagThreaded, "seccomp,threaded"},
+	}
+	for _, test := range tests {
+		if str := FlagsString(test.flags); str != test.str {
+			t.Errorf("flags %x: got %q, want %q", test.flags, str, test.str)
+		}
+		flags, err := ParseFlags(test.str)
+		if err != nil {
+			t.Errorf("failed to parse %q: %v", test.str, err)
+			continue
+		}
+		if flags != test.flags {
+			t.Errorf("%q: got flags %x, want %x", test.str, flags, test.flags)
+		}
+	}
+	for _, str := range []string{"", "root", "setuid,fast", "threaded,setuid"} {
+		if _, err := ParseFlags(str); err == nil {
+			t.Errorf("parsed bad config %q", str)
+		}
+	}
+}
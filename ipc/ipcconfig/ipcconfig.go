@@ -0,0 +1,56 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+// Package ipcconfig defines command-line flags that control executor configuration.
+// They are separate from package ipc, so that ipc can be used by programs
+// that have their own flags (e.g. syz-manager uses ipc via repro).
+package ipcconfig
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/google/syzkaller/ipc"
+)
+
+var (
+	flagThreaded = flag.Bool("threaded", true, "use threaded mode in executor")
+	flagCollide  = flag.Bool("collide", true, "collide syscalls to provoke data races")
+	flagSignal   = flag.Bool("cover", true, "collect feedback signals (coverage)")
+	flagSandbox  = flag.String("sandbox", "setuid", "sandbox for fuzzing (none/setuid/namespace/seccomp)")
+	flagDebug    = flag.Bool("debug", false, "debug output from executor")
+	// Executor protects against most hangs, so we use quite large timeout here.
+	// Executor can be slow due to global locks in namespaces and other things,
+	// so let's better wait than report false misleading crashes.
+	flagTimeout = flag.Duration("timeout", 1*time.Minute, "execution timeout")
+)
+
+// Default returns executor flags and timeout specified by command-line flags.
+func Default() (uint64, time.Duration, error) {
+	var flags uint64
+	if *flagThreaded {
+		flags |= ipc.FlagThreaded
+	}
+	if *flagCollide {
+		flags |= ipc.FlagCollide
+	}
+	if *flagSignal {
+		flags |= ipc.FlagSignal
+	}
+	switch *flagSandbox {
+	case "none":
+	case "setuid":
+		flags |= ipc.FlagSandboxSetuid
+	case "namespace":
+		flags |= ipc.FlagSandboxNamespace
+	case "seccomp":
+		flags |= ipc.FlagSandboxSeccomp
+	default:
+		return 0, 0, fmt.Errorf("flag sandbox must contain one of none/setuid/namespace/seccomp")
+	}
+	if *flagDebug {
+		flags |= ipc.FlagDebug
+	}
+	return flags, *flagTimeout, nil
+}
@@ -150,9 +150,12 @@ func foreachArg(c *Call, f func(arg, base *Arg, parent *[]*Arg)) {
 	foreachArgArray(&c.Args, nil, f)
 }
 
+// foreachSubargOffset invokes f for arg and all its subargs (including groups and unions)
+// with offsets of the subargs from the beginning of arg.
 func foreachSubargOffset(arg *Arg, f func(arg *Arg, offset uintptr)) {
 	var rec func(*Arg, uintptr) uintptr
 	rec = func(arg1 *Arg, offset uintptr) uintptr {
+		f(arg1, offset)
 		switch arg1.Kind {
 		case ArgGroup:
 			var totalSize uintptr
@@ -172,8 +175,6 @@ func foreachSubargOffset(arg *Arg, f func(arg *Arg, offset uintptr)) {
 			if size > arg1.Size() {
 				panic(fmt.Sprintf("bad union arg size %v, should be <= %v for arg %+v with type %+v", size, arg1.Size(), arg1, arg1.Type))
 			}
-		default:
-			f(arg1, offset)
 		}
 		return arg1.Size()
 	}
@@ -258,3 +259,75 @@ func sanitizeCall(c *Call) {
 		}
 	}
 }
+
+// UsedFields returns names of struct fields ("struct.field")
+// and union options ("union.option") used by arguments of the call.
+// A field is used if it has a non-default value (see isDefaultArg),
+// an option is used if it is selected. Pads and const fields can't be used.
+func UsedFields(c *Call) []string {
+	var res []string
+	dedup := make(map[string]bool)
+	ForeachUsedField(c, func(parent, field sys.Type) {
+		name := parent.Name() + "." + field.FieldName()
+		if !dedup[name] {
+			dedup[name] = true
+			res = append(res, name)
+		}
+	})
+	return res
+}
+
+// ForeachUsedField calls f for every struct field and union option used by arguments
+// of the call (see UsedFields) with types of the struct/union and of the field/option.
+// Unlike UsedFields it does not allocate names, but can visit the same field several times.
+func ForeachUsedField(c *Call, f func(parent, field sys.Type)) {
+	foreachArg(c, func(arg, _ *Arg, _ *[]*Arg) {
+		switch arg.Type.(type) {
+		case *sys.StructType:
+			for _, fld := range arg.Inner {
+				if !IsConstField(fld.Type) && !isDefaultArg(fld) {
+					f(arg.Type, fld.Type)
+				}
+			}
+		case *sys.UnionType:
+			f(arg.Type, arg.OptionType)
+		}
+	})
+}
+
+// IsConstField returns true for struct fields that have a fixed value (including pads).
+func IsConstField(t sys.Type) bool {
+	_, ok := t.(*sys.ConstType)
+	return ok
+}
+
+// isDefaultArg returns true if arg has the value of an unused field:
+// zero, a nil pointer, zero or empty data, or a struct/array of such args.
+func isDefaultArg(arg *Arg) bool {
+	switch arg.Kind {
+	case ArgConst:
+		return arg.Val == 0
+	case ArgPointer:
+		_, ok := arg.Type.(*sys.PtrType)
+		return ok && arg.Res == nil
+	case ArgPageSize:
+		return arg.AddrPage == 0
+	case ArgData:
+		for _, v := range arg.Data {
+			if v != 0 {
+				return false
+			}
+		}
+		return true
+	case ArgGroup:
+		for _, inner := range arg.Inner {
+			if !IsConstField(inner.Type) && !isDefaultArg(inner) {
+				return false
+			}
+		}
+		return true
+	case ArgUnion:
+		return isDefaultArg(arg.Option)
+	}
+	return false
+}
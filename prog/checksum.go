@@ -4,121 +4,36 @@
 package prog
 
 import (
-	"encoding/binary"
 	"fmt"
-	"unsafe"
 
 	"github.com/google/syzkaller/sys"
 )
 
-type IPChecksum struct {
-	acc uint32
-}
-
-func (csum *IPChecksum) Update(data []byte) {
-	length := len(data) - 1
-	for i := 0; i < length; i += 2 {
-		csum.acc += uint32(data[i]) << 8
-		csum.acc += uint32(data[i+1])
-	}
-	if len(data)%2 == 1 {
-		csum.acc += uint32(data[length]) << 8
-	}
-	for csum.acc > 0xffff {
-		csum.acc = (csum.acc >> 16) + (csum.acc & 0xffff)
-	}
-}
-
-func (csum *IPChecksum) Digest() uint16 {
-	return ^uint16(csum.acc)
-}
-
-func ipChecksum(data []byte) uint16 {
-	var csum IPChecksum
-	csum.Update(data)
-	return csum.Digest()
-}
+// Checksums are not calculated here, because checksummed data can contain
+// values that are known only at runtime (e.g. tcp sequence numbers extracted
+// by syz_extract_tcp_res). Instead we describe what data the checksum covers
+// and executor calculates the checksum right before the call.
 
-func bitmaskLen(bfLen uint64) uint64 {
-	return (1 << bfLen) - 1
-}
-
-func bitmaskLenOff(bfOff, bfLen uint64) uint64 {
-	return bitmaskLen(bfLen) << bfOff
-}
-
-func storeByBitmask8(addr *uint8, value uint8, bfOff uint64, bfLen uint64) {
-	if bfOff == 0 && bfLen == 0 {
-		*addr = value
-	} else {
-		newValue := *addr
-		newValue &= ^uint8(bitmaskLenOff(bfOff, bfLen))
-		newValue |= (value & uint8(bitmaskLen(bfLen))) << bfOff
-		*addr = newValue
-	}
-}
-
-func storeByBitmask16(addr *uint16, value uint16, bfOff uint64, bfLen uint64) {
-	if bfOff == 0 && bfLen == 0 {
-		*addr = value
-	} else {
-		newValue := *addr
-		newValue &= ^uint16(bitmaskLenOff(bfOff, bfLen))
-		newValue |= (value & uint16(bitmaskLen(bfLen))) << bfOff
-		*addr = newValue
-	}
-}
+type CsumChunkKind int
 
-func storeByBitmask32(addr *uint32, value uint32, bfOff uint64, bfLen uint64) {
-	if bfOff == 0 && bfLen == 0 {
-		*addr = value
-	} else {
-		newValue := *addr
-		newValue &= ^uint32(bitmaskLenOff(bfOff, bfLen))
-		newValue |= (value & uint32(bitmaskLen(bfLen))) << bfOff
-		*addr = newValue
-	}
-}
+const (
+	CsumChunkArg   CsumChunkKind = iota // contents of an argument in memory
+	CsumChunkConst                      // big-endian constant of the given size
+)
 
-func storeByBitmask64(addr *uint64, value uint64, bfOff uint64, bfLen uint64) {
-	if bfOff == 0 && bfLen == 0 {
-		*addr = value
-	} else {
-		newValue := *addr
-		newValue &= ^uint64(bitmaskLenOff(bfOff, bfLen))
-		newValue |= (value & uint64(bitmaskLen(bfLen))) << bfOff
-		*addr = newValue
-	}
+// CsumChunk is a contiguous piece of data covered by a checksum.
+type CsumChunk struct {
+	Kind  CsumChunkKind
+	Arg   *Arg    // for CsumChunkArg
+	Value uintptr // for CsumChunkConst
+	Size  uintptr // for CsumChunkConst
 }
 
-func encodeArg(arg *Arg, pid int) []byte {
-	bytes := make([]byte, arg.Size())
-	foreachSubargOffset(arg, func(arg *Arg, offset uintptr) {
-		switch arg.Kind {
-		case ArgConst:
-			addr := unsafe.Pointer(&bytes[offset])
-			val := arg.Value(pid)
-			bfOff := uint64(arg.Type.BitfieldOffset())
-			bfLen := uint64(arg.Type.BitfieldLength())
-			switch arg.Size() {
-			case 1:
-				storeByBitmask8((*uint8)(addr), uint8(val), bfOff, bfLen)
-			case 2:
-				storeByBitmask16((*uint16)(addr), uint16(val), bfOff, bfLen)
-			case 4:
-				storeByBitmask32((*uint32)(addr), uint32(val), bfOff, bfLen)
-			case 8:
-				storeByBitmask64((*uint64)(addr), uint64(val), bfOff, bfLen)
-			default:
-				panic(fmt.Sprintf("bad arg size %v, arg: %+v\n", arg.Size(), arg))
-			}
-		case ArgData:
-			copy(bytes[offset:], arg.Data)
-		default:
-			panic(fmt.Sprintf("bad arg kind %v, arg: %+v, type: %+v", arg.Kind, arg, arg.Type))
-		}
-	})
-	return bytes
+// CsumInfo describes a checksum field value:
+// it is the inet checksum of concatenation of the chunks.
+type CsumInfo struct {
+	Kind   sys.CsumKind
+	Chunks []CsumChunk
 }
 
 func getFieldByName(arg *Arg, name string) *Arg {
@@ -130,14 +45,6 @@ func getFieldByName(arg *Arg, name string) *Arg {
 	panic(fmt.Sprintf("failed to find %v field in %v", name, arg.Type.Name()))
 }
 
-func calcChecksumInet(packet, csumField *Arg, pid int) *Arg {
-	bytes := encodeArg(packet, pid)
-	csum := ipChecksum(bytes)
-	newCsumField := *csumField
-	newCsumField.Val = uintptr(csum)
-	return &newCsumField
-}
-
 func extractHeaderParamsIPv4(arg *Arg) (*Arg, *Arg) {
 	srcAddr := getFieldByName(arg, "src_ip")
 	if srcAddr.Size() != 4 {
@@ -162,35 +69,30 @@ func extractHeaderParamsIPv6(arg *Arg) (*Arg, *Arg) {
 	return srcAddr, dstAddr
 }
 
-func composePseudoHeaderIPv4(tcpPacket, srcAddr, dstAddr *Arg, protocol uint8, pid int) []byte {
-	header := []byte{}
-	header = append(header, encodeArg(srcAddr, pid)...)
-	header = append(header, encodeArg(dstAddr, pid)...)
-	header = append(header, []byte{0, protocol}...)
-	length := []byte{0, 0}
-	binary.BigEndian.PutUint16(length, uint16(tcpPacket.Size()))
-	header = append(header, length...)
-	return header
-}
-
-func composePseudoHeaderIPv6(tcpPacket, srcAddr, dstAddr *Arg, protocol uint8, pid int) []byte {
-	header := []byte{}
-	header = append(header, encodeArg(srcAddr, pid)...)
-	header = append(header, encodeArg(dstAddr, pid)...)
-	length := []byte{0, 0, 0, 0}
-	binary.BigEndian.PutUint32(length, uint32(tcpPacket.Size()))
-	header = append(header, length...)
-	header = append(header, []byte{0, 0, 0, protocol}...)
-	return header
+func composePseudoCsumIPv4(tcpPacket, srcAddr, dstAddr *Arg, protocol uint8) CsumInfo {
+	return CsumInfo{
+		Kind: sys.CsumPseudo,
+		Chunks: []CsumChunk{
+			{Kind: CsumChunkArg, Arg: srcAddr},
+			{Kind: CsumChunkArg, Arg: dstAddr},
+			{Kind: CsumChunkConst, Value: uintptr(protocol), Size: 2},
+			{Kind: CsumChunkConst, Value: tcpPacket.Size(), Size: 2},
+			{Kind: CsumChunkArg, Arg: tcpPacket},
+		},
+	}
 }
 
-func calcChecksumPseudo(packet, csumField *Arg, pseudoHeader []byte, pid int) *Arg {
-	var csum IPChecksum
-	csum.Update(pseudoHeader)
-	csum.Update(encodeArg(packet, pid))
-	newCsumField := *csumField
-	newCsumField.Val = uintptr(csum.Digest())
-	return &newCsumField
+func composePseudoCsumIPv6(tcpPacket, srcAddr, dstAddr *Arg, protocol uint8) CsumInfo {
+	return CsumInfo{
+		Kind: sys.CsumPseudo,
+		Chunks: []CsumChunk{
+			{Kind: CsumChunkArg, Arg: srcAddr},
+			{Kind: CsumChunkArg, Arg: dstAddr},
+			{Kind: CsumChunkConst, Value: tcpPacket.Size(), Size: 4},
+			{Kind: CsumChunkConst, Value: uintptr(protocol), Size: 4},
+			{Kind: CsumChunkArg, Arg: tcpPacket},
+		},
+	}
 }
 
 func findCsummedArg(arg *Arg, typ *sys.CsumType, parentsMap map[*Arg]*Arg) *Arg {
@@ -209,7 +111,8 @@ func findCsummedArg(arg *Arg, typ *sys.CsumType, parentsMap map[*Arg]*Arg) *Arg
 	panic(fmt.Sprintf("csum field '%v' references non existent field '%v'", typ.FieldName(), typ.Buf))
 }
 
-func calcChecksumsCall(c *Call, pid int) map[*Arg]*Arg {
+// calcChecksumsCall returns descriptions of all checksum fields in call c.
+func calcChecksumsCall(c *Call) map[*Arg]CsumInfo {
 	var inetCsumFields []*Arg
 	var pseudoCsumFields []*Arg
 
@@ -242,14 +145,16 @@ func calcChecksumsCall(c *Call, pid int) map[*Arg]*Arg {
 		}
 	})
 
-	csumMap := make(map[*Arg]*Arg)
+	csumMap := make(map[*Arg]CsumInfo)
 
-	// Calculate inet checksums.
+	// Describe inet checksums.
 	for _, arg := range inetCsumFields {
 		typ, _ := arg.Type.(*sys.CsumType)
 		csummedArg := findCsummedArg(arg, typ, parentsMap)
-		newCsumField := calcChecksumInet(csummedArg, arg, pid)
-		csumMap[arg] = newCsumField
+		csumMap[arg] = CsumInfo{
+			Kind:   sys.CsumInet,
+			Chunks: []CsumChunk{{Kind: CsumChunkArg, Arg: csummedArg}},
+		}
 	}
 
 	// No need to continue if there are no pseudo csum fields.
@@ -277,19 +182,16 @@ func calcChecksumsCall(c *Call, pid int) map[*Arg]*Arg {
 		panic("no ipv4 nor ipv6 header found")
 	}
 
-	// Calculate pseudo checksums.
+	// Describe pseudo checksums.
 	for _, arg := range pseudoCsumFields {
 		typ, _ := arg.Type.(*sys.CsumType)
 		csummedArg := findCsummedArg(arg, typ, parentsMap)
 		protocol := uint8(typ.Protocol)
-		var pseudoHeader []byte
 		if ipv4HeaderParsed {
-			pseudoHeader = composePseudoHeaderIPv4(csummedArg, ipSrcAddr, ipDstAddr, protocol, pid)
+			csumMap[arg] = composePseudoCsumIPv4(csummedArg, ipSrcAddr, ipDstAddr, protocol)
 		} else {
-			pseudoHeader = composePseudoHeaderIPv6(csummedArg, ipSrcAddr, ipDstAddr, protocol, pid)
+			csumMap[arg] = composePseudoCsumIPv6(csummedArg, ipSrcAddr, ipDstAddr, protocol)
 		}
-		newCsumField := calcChecksumPseudo(csummedArg, arg, pseudoHeader, pid)
-		csumMap[arg] = newCsumField
 	}
 
 	return csumMap
@@ -5,11 +5,145 @@ package prog
 
 import (
 	"bytes"
+	"fmt"
 	"testing"
+	"unsafe"
 
 	"github.com/google/syzkaller/sys"
 )
 
+type IPChecksum struct {
+	acc uint32
+}
+
+func (csum *IPChecksum) Update(data []byte) {
+	length := len(data) - 1
+	for i := 0; i < length; i += 2 {
+		csum.acc += uint32(data[i]) << 8
+		csum.acc += uint32(data[i+1])
+	}
+	if len(data)%2 == 1 {
+		csum.acc += uint32(data[length]) << 8
+	}
+	for csum.acc > 0xffff {
+		csum.acc = (csum.acc >> 16) + (csum.acc & 0xffff)
+	}
+}
+
+func (csum *IPChecksum) Digest() uint16 {
+	return ^uint16(csum.acc)
+}
+
+func ipChecksum(data []byte) uint16 {
+	var csum IPChecksum
+	csum.Update(data)
+	return csum.Digest()
+}
+
+func bitmaskLen(bfLen uint64) uint64 {
+	return (1 << bfLen) - 1
+}
+
+func bitmaskLenOff(bfOff, bfLen uint64) uint64 {
+	return bitmaskLen(bfLen) << bfOff
+}
+
+func storeByBitmask8(addr *uint8, value uint8, bfOff uint64, bfLen uint64) {
+	if bfOff == 0 && bfLen == 0 {
+		*addr = value
+	} else {
+		newValue := *addr
+		newValue &= ^uint8(bitmaskLenOff(bfOff, bfLen))
+		newValue |= (value & uint8(bitmaskLen(bfLen))) << bfOff
+		*addr = newValue
+	}
+}
+
+func storeByBitmask16(addr *uint16, value uint16, bfOff uint64, bfLen uint64) {
+	if bfOff == 0 && bfLen == 0 {
+		*addr = value
+	} else {
+		newValue := *addr
+		newValue &= ^uint16(bitmaskLenOff(bfOff, bfLen))
+		newValue |= (value & uint16(bitmaskLen(bfLen))) << bfOff
+		*addr = newValue
+	}
+}
+
+func storeByBitmask32(addr *uint32, value uint32, bfOff uint64, bfLen uint64) {
+	if bfOff == 0 && bfLen == 0 {
+		*addr = value
+	} else {
+		newValue := *addr
+		newValue &= ^uint32(bitmaskLenOff(bfOff, bfLen))
+		newValue |= (value & uint32(bitmaskLen(bfLen))) << bfOff
+		*addr = newValue
+	}
+}
+
+func storeByBitmask64(addr *uint64, value uint64, bfOff uint64, bfLen uint64) {
+	if bfOff == 0 && bfLen == 0 {
+		*addr = value
+	} else {
+		newValue := *addr
+		newValue &= ^uint64(bitmaskLenOff(bfOff, bfLen))
+		newValue |= (value & uint64(bitmaskLen(bfLen))) << bfOff
+		*addr = newValue
+	}
+}
+
+func encodeArg(arg *Arg, pid int) []byte {
+	bytes := make([]byte, arg.Size())
+	foreachSubargOffset(arg, func(arg *Arg, offset uintptr) {
+		switch arg.Kind {
+		case ArgGroup, ArgUnion:
+			// Fields are encoded separately.
+		case ArgConst:
+			addr := unsafe.Pointer(&bytes[offset])
+			val := arg.Value(pid)
+			bfOff := uint64(arg.Type.BitfieldOffset())
+			bfLen := uint64(arg.Type.BitfieldLength())
+			switch arg.Size() {
+			case 1:
+				storeByBitmask8((*uint8)(addr), uint8(val), bfOff, bfLen)
+			case 2:
+				storeByBitmask16((*uint16)(addr), uint16(val), bfOff, bfLen)
+			case 4:
+				storeByBitmask32((*uint32)(addr), uint32(val), bfOff, bfLen)
+			case 8:
+				storeByBitmask64((*uint64)(addr), uint64(val), bfOff, bfLen)
+			default:
+				panic(fmt.Sprintf("bad arg size %v, arg: %+v\n", arg.Size(), arg))
+			}
+		case ArgData:
+			copy(bytes[offset:], arg.Data)
+		default:
+			panic(fmt.Sprintf("bad arg kind %v, arg: %+v, type: %+v", arg.Kind, arg, arg.Type))
+		}
+	})
+	return bytes
+}
+
+// evalChecksum calculates checksum described by info the same way executor does.
+func evalChecksum(info CsumInfo, pid int) uint16 {
+	var csum IPChecksum
+	for _, chunk := range info.Chunks {
+		switch chunk.Kind {
+		case CsumChunkArg:
+			csum.Update(encodeArg(chunk.Arg, pid))
+		case CsumChunkConst:
+			data := make([]byte, chunk.Size)
+			for i := range data {
+				data[i] = byte(chunk.Value >> (8 * (chunk.Size - uintptr(i) - 1)))
+			}
+			csum.Update(data)
+		default:
+			panic(fmt.Sprintf("unknown csum chunk kind %v", chunk.Kind))
+		}
+	}
+	return csum.Digest()
+}
+
 func TestChecksumIP(t *testing.T) {
 	tests := []struct {
 		data string
@@ -181,19 +315,22 @@ func TestChecksumCalc(t *testing.T) {
 		if err != nil {
 			t.Fatalf("failed to deserialize prog %v: %v", test.prog, err)
 		}
-		csumMap := calcChecksumsCall(p.Calls[0], i%32)
+		csumMap := calcChecksumsCall(p.Calls[0])
 		found := false
-		for oldField, newField := range csumMap {
-			if typ, ok := newField.Type.(*sys.CsumType); ok {
+		for field, info := range csumMap {
+			if typ, ok := field.Type.(*sys.CsumType); ok {
+				if typ.Kind != info.Kind {
+					t.Fatalf("csum field of kind %v is described as %v", typ.Kind, info.Kind)
+				}
 				if typ.Kind == test.kind {
 					found = true
-					csum := newField.Value(i % 32)
-					if csum != uintptr(test.csum) {
+					csum := evalChecksum(info, i%32)
+					if csum != test.csum {
 						t.Fatalf("failed to calc checksum, got %x, want %x, kind %v, prog '%v'", csum, test.csum, test.kind, test.prog)
 					}
 				}
 			} else {
-				t.Fatalf("non csum key %+v in csum map %+v", oldField, csumMap)
+				t.Fatalf("non csum key %+v in csum map %+v", field, csumMap)
 			}
 		}
 		if !found {
@@ -207,12 +344,12 @@ func TestChecksumCalcRandom(t *testing.T) {
 	for i := 0; i < iters; i++ {
 		p := Generate(rs, 10, nil)
 		for _, call := range p.Calls {
-			calcChecksumsCall(call, i%32)
+			calcChecksumsCall(call)
 		}
 		for try := 0; try <= 10; try++ {
 			p.Mutate(rs, 10, nil, nil)
 			for _, call := range p.Calls {
-				calcChecksumsCall(call, i%32)
+				calcChecksumsCall(call)
 			}
 		}
 	}
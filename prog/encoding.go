@@ -51,7 +51,11 @@ func (p *Prog) Serialize() []byte {
 			}
 			a.serialize(buf, vars, &varSeq)
 		}
-		fmt.Fprintf(buf, ")\n")
+		fmt.Fprintf(buf, ")")
+		if c.Race {
+			fmt.Fprintf(buf, " (race=%v)", c.RaceDelay)
+		}
+		fmt.Fprintf(buf, "\n")
 	}
 	return buf.Bytes()
 }
@@ -162,6 +166,21 @@ func Deserialize(data []byte) (prog *Prog, err error) {
 			}
 		}
 		p.Parse(')')
+		if !p.EOF() && p.Char() == '(' {
+			p.Parse('(')
+			if attr := p.Ident(); attr != "race" {
+				return nil, fmt.Errorf("unknown call attribute '%v' (line #%v)", attr, p.l)
+			}
+			p.Parse('=')
+			delay := p.Ident()
+			v, err := strconv.ParseUint(delay, 0, 64)
+			if err != nil {
+				return nil, fmt.Errorf("wrong race delay '%v': %v", delay, err)
+			}
+			p.Parse(')')
+			c.Race = true
+			c.RaceDelay = v
+		}
 		if !p.EOF() {
 			return nil, fmt.Errorf("tailing data (line #%v)", p.l)
 		}
@@ -8,6 +8,7 @@ package prog
 
 import (
 	"fmt"
+	"sort"
 
 	"github.com/google/syzkaller/sys"
 )
@@ -16,12 +17,23 @@ const (
 	ExecInstrEOF = ^uintptr(iota)
 	ExecInstrCopyin
 	ExecInstrCopyout
+	ExecInstrRace
 )
 
 const (
 	ExecArgConst = uintptr(iota)
 	ExecArgResult
 	ExecArgData
+	ExecArgCsum
+)
+
+const (
+	ExecArgCsumInet = uintptr(iota)
+)
+
+const (
+	ExecArgCsumChunkData = uintptr(iota)
+	ExecArgCsumChunkConst
 )
 
 const (
@@ -33,8 +45,9 @@ const (
 )
 
 // SerializeForExec serializes program p for execution by process pid into the provided buffer.
+// Returns number of bytes written to the buffer.
 // If the provided buffer is too small for the program an error is returned.
-func (p *Prog) SerializeForExec(buffer []byte, pid int) error {
+func (p *Prog) SerializeForExec(buffer []byte, pid int) (int, error) {
 	if debug {
 		if err := p.validate(); err != nil {
 			panic(fmt.Errorf("serializing invalid program: %v", err))
@@ -47,32 +60,64 @@ func (p *Prog) SerializeForExec(buffer []byte, pid int) error {
 		args: make(map[*Arg]argInfo),
 	}
 	for _, c := range p.Calls {
-		// Calculate checksums.
-		csumMap := calcChecksumsCall(c, pid)
+		// Describe checksums and remember what args they cover.
+		csumMap := calcChecksumsCall(c)
+		csumUses := make(map[*Arg]bool)
+		for _, info := range csumMap {
+			for _, chunk := range info.Chunks {
+				if chunk.Kind == CsumChunkArg {
+					csumUses[chunk.Arg] = true
+				}
+			}
+		}
 		// Calculate arg offsets within structs.
 		// Generate copyin instructions that fill in data into pointer arguments.
 		foreachArg(c, func(arg, _ *Arg, _ *[]*Arg) {
 			if arg.Kind == ArgPointer && arg.Res != nil {
 				foreachSubargOffset(arg.Res, func(arg1 *Arg, offset uintptr) {
-					if len(arg1.Uses) != 0 {
-						w.args[arg1] = argInfo{Offset: offset}
+					_, isCsum := csumMap[arg1]
+					if len(arg1.Uses) != 0 || csumUses[arg1] || isCsum {
+						w.args[arg1] = argInfo{Addr: physicalAddr(arg) + offset}
+					}
+					if arg1.Kind == ArgGroup || arg1.Kind == ArgUnion {
+						return
 					}
 					if !sys.IsPad(arg1.Type) &&
 						!(arg1.Kind == ArgData && len(arg1.Data) == 0) &&
 						arg1.Type.Dir() != sys.DirOut {
 						w.write(ExecInstrCopyin)
 						w.write(physicalAddr(arg) + offset)
-						w.writeArg(arg1, pid, csumMap)
+						w.writeArg(arg1, pid)
 						instrSeq++
 					}
 				})
 			}
 		})
+		// Generate checksum calculation instructions after all data is copied in.
+		// Start from the last checksum, since earlier checksums can cover latter ones
+		// (e.g. ipv4 header checksum covers checksum of the payload).
+		var csumArgs []csumArg
+		for arg, info := range csumMap {
+			csumArgs = append(csumArgs, csumArg{arg, w.args[arg].Addr, info})
+		}
+		sort.Sort(sort.Reverse(csumArgArray(csumArgs)))
+		for _, csum := range csumArgs {
+			w.write(ExecInstrCopyin)
+			w.write(csum.addr)
+			w.writeCsum(csum.arg, csum.info)
+			instrSeq++
+		}
+		// Race instruction says that executor must not wait for the following call.
+		if c.Race {
+			w.write(ExecInstrRace)
+			w.write(uintptr(c.RaceDelay))
+			instrSeq++
+		}
 		// Generate the call itself.
-		w.write(uintptr(c.Meta.ID))
+		w.write(uintptr(c.Meta.ExecNum()))
 		w.write(uintptr(len(c.Args)))
 		for _, arg := range c.Args {
-			w.writeArg(arg, pid, csumMap)
+			w.writeArg(arg, pid)
 		}
 		if len(c.Ret.Uses) != 0 {
 			w.args[c.Ret] = argInfo{Idx: instrSeq}
@@ -96,7 +141,7 @@ func (p *Prog) SerializeForExec(buffer []byte, pid int) error {
 				instrSeq++
 				w.args[arg] = info
 				w.write(ExecInstrCopyout)
-				w.write(physicalAddr(base) + info.Offset)
+				w.write(info.Addr)
 				w.write(arg.Size())
 			default:
 				panic("bad arg kind in copyout")
@@ -105,9 +150,9 @@ func (p *Prog) SerializeForExec(buffer []byte, pid int) error {
 	}
 	w.write(ExecInstrEOF)
 	if w.eof {
-		return fmt.Errorf("provided buffer is too small")
+		return 0, fmt.Errorf("provided buffer is too small")
 	}
-	return nil
+	return len(buffer) - len(w.buf), nil
 }
 
 func physicalAddr(arg *Arg) uintptr {
@@ -129,9 +174,21 @@ type execContext struct {
 	args map[*Arg]argInfo
 }
 
+type csumArg struct {
+	arg  *Arg
+	addr uintptr
+	info CsumInfo
+}
+
+type csumArgArray []csumArg
+
+func (a csumArgArray) Len() int           { return len(a) }
+func (a csumArgArray) Less(i, j int) bool { return a[i].addr < a[j].addr }
+func (a csumArgArray) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
 type argInfo struct {
-	Offset uintptr // from base pointer
-	Idx    uintptr // instruction index
+	Addr uintptr // physical addr
+	Idx  uintptr // instruction index
 }
 
 func (w *execContext) write(v uintptr) {
@@ -150,17 +207,18 @@ func (w *execContext) write(v uintptr) {
 	w.buf = w.buf[8:]
 }
 
-func (w *execContext) writeArg(arg *Arg, pid int, csumMap map[*Arg]*Arg) {
+func (w *execContext) writeArg(arg *Arg, pid int) {
 	switch arg.Kind {
 	case ArgConst:
+		val := arg.Value(pid)
 		if _, ok := arg.Type.(*sys.CsumType); ok {
-			if arg, ok = csumMap[arg]; !ok {
-				panic("csum arg is not in csum map")
-			}
+			// Checksum is calculated later by a separate instruction,
+			// but the field itself must be zero during the calculation.
+			val = 0
 		}
 		w.write(ExecArgConst)
 		w.write(arg.Size())
-		w.write(arg.Value(pid))
+		w.write(val)
 		w.write(arg.Type.BitfieldOffset())
 		w.write(arg.Type.BitfieldLength())
 	case ArgResult:
@@ -192,9 +250,36 @@ func (w *execContext) writeArg(arg *Arg, pid int, csumMap map[*Arg]*Arg) {
 			w.eof = true
 		} else {
 			copy(w.buf, arg.Data)
+			for i := len(arg.Data); i < padded; i++ {
+				w.buf[i] = 0
+			}
 			w.buf = w.buf[padded:]
 		}
 	default:
 		panic("unknown arg type")
 	}
 }
+
+func (w *execContext) writeCsum(arg *Arg, info CsumInfo) {
+	if arg.Size() != 2 {
+		panic(fmt.Sprintf("inet checksum must be 2 bytes, not %v", arg.Size()))
+	}
+	w.write(ExecArgCsum)
+	w.write(arg.Size())
+	w.write(ExecArgCsumInet)
+	w.write(uintptr(len(info.Chunks)))
+	for _, chunk := range info.Chunks {
+		switch chunk.Kind {
+		case CsumChunkArg:
+			w.write(ExecArgCsumChunkData)
+			w.write(w.args[chunk.Arg].Addr)
+			w.write(chunk.Arg.Size())
+		case CsumChunkConst:
+			w.write(ExecArgCsumChunkConst)
+			w.write(chunk.Value)
+			w.write(chunk.Size)
+		default:
+			panic(fmt.Sprintf("unknown csum chunk kind %v", chunk.Kind))
+		}
+	}
+}
@@ -17,7 +17,7 @@ func TestSerializeForExecRandom(t *testing.T) {
 	buf := make([]byte, ExecBufferSize)
 	for i := 0; i < iters; i++ {
 		p := Generate(rs, 10, nil)
-		if err := p.SerializeForExec(buf, i%16); err != nil {
+		if _, err := p.SerializeForExec(buf, i%16); err != nil {
 			t.Fatalf("failed to serialize: %v", err)
 		}
 	}
@@ -29,20 +29,27 @@ func TestSerializeForExec(t *testing.T) {
 	// The sequence is terminated by a speciall call ExecInstrEOF.
 	// Each call is (call ID, number of arguments, arguments...).
 	// Each argument is (type, size, value).
-	// There are 3 types of arguments:
+	// There are 4 types of arguments:
 	//  - ExecArgConst: value is const value
 	//  - ExecArgResult: value is index of a call whose result we want to reference
 	//  - ExecArgData: value is a binary blob (represented as ]size/8[ uint64's)
+	//  - ExecArgCsum: value is checksum kind and a list of (kind, value, size) chunks covered by the checksum
 	// There are 2 other special call:
 	//  - ExecInstrCopyin: copies its second argument into address specified by first argument
 	//  - ExecInstrCopyout: reads value at address specified by first argument (result can be referenced by ExecArgResult)
+	//  - ExecInstrRace: the next call is started after the delay specified by first argument without waiting for its completion
 	const (
 		instrEOF     = uint64(ExecInstrEOF)
 		instrCopyin  = uint64(ExecInstrCopyin)
 		instrCopyout = uint64(ExecInstrCopyout)
+		instrRace    = uint64(ExecInstrRace)
 		argConst     = uint64(ExecArgConst)
 		argResult    = uint64(ExecArgResult)
 		argData      = uint64(ExecArgData)
+		argCsum      = uint64(ExecArgCsum)
+		csumInet     = uint64(ExecArgCsumInet)
+		chunkData    = uint64(ExecArgCsumChunkData)
+		chunkConst   = uint64(ExecArgCsumChunkConst)
 	)
 	callID := func(name string) uint64 {
 		c := sys.CallMap[name]
@@ -62,6 +69,14 @@ func TestSerializeForExec(t *testing.T) {
 				instrEOF,
 			},
 		},
+		{
+			"syz_test() (race=100)\nsyz_test()",
+			[]uint64{
+				instrRace, 100, callID("syz_test"), 0,
+				callID("syz_test"), 0,
+				instrEOF,
+			},
+		},
 		{
 			"syz_test$int(0x1, 0x2, 0x3, 0x4, 0x5)",
 			[]uint64{
@@ -250,6 +265,26 @@ func TestSerializeForExec(t *testing.T) {
 				instrEOF,
 			},
 		},
+		{
+			"syz_test$csum_ipv4_tcp(&(0x7f0000000000)={{0x0, 0x1234, 0x5678}, {{0x0}, \"abcd\"}})",
+			[]uint64{
+				instrCopyin, dataOffset + 0, argConst, 2, 0, 0, 0,
+				instrCopyin, dataOffset + 2, argConst, 4, 0x34120000, 0, 0,
+				instrCopyin, dataOffset + 6, argConst, 4, 0x78560000, 0, 0,
+				instrCopyin, dataOffset + 10, argConst, 2, 0, 0, 0,
+				instrCopyin, dataOffset + 12, argData, 2, 0xcdab,
+				instrCopyin, dataOffset + 10, argCsum, 2, csumInet, 5,
+				chunkData, dataOffset + 2, 4,
+				chunkData, dataOffset + 6, 4,
+				chunkConst, 6, 2,
+				chunkConst, 4, 2,
+				chunkData, dataOffset + 10, 4,
+				instrCopyin, dataOffset + 0, argCsum, 2, csumInet, 1,
+				chunkData, dataOffset + 0, 10,
+				callID("syz_test$csum_ipv4_tcp"), 1, argConst, ptrSize, dataOffset, 0, 0,
+				instrEOF,
+			},
+		},
 	}
 
 	buf := make([]byte, ExecBufferSize)
@@ -259,15 +294,16 @@ func TestSerializeForExec(t *testing.T) {
 			t.Fatalf("failed to deserialize prog %v: %v", i, err)
 		}
 		t.Run(fmt.Sprintf("%v:%v", i, p.String()), func(t *testing.T) {
-			if err := p.SerializeForExec(buf, i%16); err != nil {
+			n, err := p.SerializeForExec(buf, i%16)
+			if err != nil {
 				t.Fatalf("failed to serialize: %v", err)
 			}
 			w := new(bytes.Buffer)
 			binary.Write(w, binary.LittleEndian, test.serialized)
-			data := buf
-			if len(data) > len(w.Bytes()) {
-				data = data[:len(w.Bytes())]
+			if n != len(w.Bytes()) {
+				t.Fatalf("serialized size %v, want %v", n, len(w.Bytes()))
 			}
+			data := buf[:n]
 			if !bytes.Equal(data, w.Bytes()) {
 				got := make([]uint64, len(data)/8)
 				binary.Read(bytes.NewReader(data), binary.LittleEndian, &got)
@@ -13,21 +13,44 @@ import (
 )
 
 func (p *Prog) Mutate(rs rand.Source, ncalls int, ct *ChoiceTable, corpus []*Prog) {
+	p.mutate(rs, ncalls, ct, corpus, 0)
+}
+
+// MutateTail is like Mutate but leaves the first prefix calls intact,
+// so that the resulting program starts with the same calls as the original one.
+// The program must have at least one call after the prefix.
+func (p *Prog) MutateTail(rs rand.Source, ncalls int, ct *ChoiceTable, corpus []*Prog, prefix int) {
+	if prefix < 0 || prefix >= len(p.Calls) {
+		panic(fmt.Sprintf("bad prefix %v for program with %v calls", prefix, len(p.Calls)))
+	}
+	if ncalls <= prefix {
+		ncalls = prefix + 1
+	}
+	p.mutate(rs, ncalls, ct, corpus, prefix)
+}
+
+func (p *Prog) mutate(rs rand.Source, ncalls int, ct *ChoiceTable, corpus []*Prog, prefix int) {
 	r := newRand(rs)
 
 	retry := false
 	for stop := false; !stop || retry; stop = r.oneOf(3) {
 		retry = false
 		switch {
+		case r.nOutOf(1, 50):
+			// Make calls that use the same resource race with each other.
+			if !p.mutateRace(r, prefix) {
+				retry = true
+				continue
+			}
 		case r.nOutOf(1, 100):
 			// Splice with another prog from corpus.
-			if len(corpus) == 0 || len(p.Calls) == 0 {
+			if len(corpus) == 0 || len(p.Calls) == prefix {
 				retry = true
 				continue
 			}
 			p0 := corpus[r.Intn(len(corpus))]
 			p0c := p0.Clone()
-			idx := r.Intn(len(p.Calls))
+			idx := prefix + r.Intn(len(p.Calls)-prefix)
 			p.Calls = append(p.Calls[:idx], append(p0c.Calls, p.Calls[idx:]...)...)
 			if len(p.Calls) > ncalls {
 				p.Calls = p.Calls[:ncalls]
@@ -38,7 +61,7 @@ func (p *Prog) Mutate(rs rand.Source, ncalls int, ct *ChoiceTable, corpus []*Pro
 				retry = true
 				continue
 			}
-			idx := r.biasedRand(len(p.Calls)+1, 5)
+			idx := prefix + r.biasedRand(len(p.Calls)-prefix+1, 5)
 			var c *Call
 			if idx < len(p.Calls) {
 				c = p.Calls[idx]
@@ -48,11 +71,11 @@ func (p *Prog) Mutate(rs rand.Source, ncalls int, ct *ChoiceTable, corpus []*Pro
 			p.insertBefore(c, calls)
 		case r.nOutOf(10, 11):
 			// Change args of a call.
-			if len(p.Calls) == 0 {
+			if len(p.Calls) == prefix {
 				retry = true
 				continue
 			}
-			c := p.Calls[r.Intn(len(p.Calls))]
+			c := p.Calls[prefix+r.Intn(len(p.Calls)-prefix)]
 			if len(c.Args) == 0 {
 				retry = true
 				continue
@@ -227,11 +250,11 @@ func (p *Prog) Mutate(rs rand.Source, ncalls int, ct *ChoiceTable, corpus []*Pro
 			}
 		default:
 			// Remove a random call.
-			if len(p.Calls) == 0 {
+			if len(p.Calls) == prefix {
 				retry = true
 				continue
 			}
-			idx := r.Intn(len(p.Calls))
+			idx := prefix + r.Intn(len(p.Calls)-prefix)
 			p.removeCall(idx)
 		}
 	}
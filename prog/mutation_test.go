@@ -46,6 +46,33 @@ next:
 	}
 }
 
+func TestMutateTail(t *testing.T) {
+	rs, iters := initTest(t)
+	for i := 0; i < iters; i++ {
+		p := Generate(rs, 10, nil)
+		if len(p.Calls) < 2 {
+			continue
+		}
+		prefix := 1 + i%(len(p.Calls)-1)
+		trimmed := func(p *Prog) []byte {
+			p1 := p.Clone()
+			p1.TrimAfter(prefix - 1)
+			return p1.Serialize()
+		}
+		data0 := trimmed(p)
+		p1 := p.Clone()
+		p1.MutateTail(rs, 10, nil, nil, prefix)
+		if len(p1.Calls) < prefix {
+			t.Fatalf("mutation removed prefix calls: prefix %v\noriginal:\n%s\n\nnew:\n%s\n",
+				prefix, p.Serialize(), p1.Serialize())
+		}
+		if data1 := trimmed(p1); !bytes.Equal(data0, data1) {
+			t.Fatalf("mutation changed prefix of %v calls\noriginal:\n%s\n\nnew:\n%s\n",
+				prefix, p.Serialize(), p1.Serialize())
+		}
+	}
+}
+
 func TestMutateTable(t *testing.T) {
 	tests := [][2]string{
 		// Insert calls.
@@ -10,10 +10,11 @@ import (
 
 // LogEntry describes one program in execution log.
 type LogEntry struct {
-	P     *Prog
-	Proc  int // index of parallel proc
-	Start int // start offset in log
-	End   int // end offset in log
+	P      *Prog
+	Proc   int    // index of parallel proc
+	Config string // executor configuration of the proc (see ipc.FlagsString), empty if not recorded
+	Start  int    // start offset in log
+	End    int    // end offset in log
 }
 
 func ParseLog(data []byte) []*LogEntry {
@@ -42,9 +43,17 @@ func ParseLog(data []byte) []*LogEntry {
 				procEnd++
 			}
 			proc, _ := strconv.Atoi(string(line[procStart:procEnd]))
+			// Optional executor configuration: "executing program 3 (setuid,threaded):".
+			config := ""
+			if rest := line[procEnd:]; bytes.HasPrefix(rest, []byte(" (")) {
+				if end := bytes.IndexByte(rest, ')'); end != -1 {
+					config = string(rest[2:end])
+				}
+			}
 			ent = &LogEntry{
-				Proc:  proc,
-				Start: pos0,
+				Proc:   proc,
+				Config: config,
+				Start:  pos0,
 			}
 			cur = nil
 			continue
@@ -89,3 +89,35 @@ getpid()
 2015/12/21 12:18:05 executing program 9:
 munlockall()
 `
+
+func TestParseConfig(t *testing.T) {
+	const execLog = `
+2015/12/21 12:18:05 executing program 1 (namespace,threaded):
+getpid()
+2015/12/21 12:18:05 executing program 2:
+gettid()
+2015/12/21 12:18:05 executing program 33 (none):
+[ 2351.935478] Modules linked in:
+munlockall()
+`
+	entries := ParseLog([]byte(execLog))
+	if len(entries) != 3 {
+		t.Fatalf("got %v programs, want 3", len(entries))
+	}
+	tests := []struct {
+		proc   int
+		config string
+		prog   string
+	}{
+		{1, "namespace,threaded", "getpid"},
+		{2, "", "gettid"},
+		{33, "none", "munlockall"},
+	}
+	for i, test := range tests {
+		ent := entries[i]
+		if ent.Proc != test.proc || ent.Config != test.config || ent.P.String() != test.prog {
+			t.Fatalf("program #%v: got proc %v, config %q, program %s; want proc %v, config %q, program %s",
+				i, ent.Proc, ent.Config, ent.P, test.proc, test.config, test.prog)
+		}
+	}
+}
@@ -17,6 +17,10 @@ type Call struct {
 	Meta *sys.Call
 	Args []*Arg
 	Ret  *Arg
+	// If Race is set, the call races with the next call: the next call is started
+	// RaceDelay microseconds after this call without waiting for its completion (see race.go).
+	Race      bool
+	RaceDelay uint64
 }
 
 type Arg struct {
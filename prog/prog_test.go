@@ -6,6 +6,7 @@ package prog
 import (
 	"bytes"
 	"math/rand"
+	"reflect"
 	"testing"
 	"time"
 
@@ -90,3 +91,80 @@ func TestVmaType(t *testing.T) {
 		check(c.Args[4], c.Args[5], 7, 9)
 	}
 }
+
+func TestFsImageSegment(t *testing.T) {
+	rs, iters := initTest(t)
+	meta := sys.CallMap["syz_mount_image"]
+	r := newRand(rs)
+	total, small := 0, 0
+	for i := 0; i < iters; i++ {
+		s := newState(nil)
+		calls := r.generateParticularCall(s, meta)
+		c := calls[len(calls)-1]
+		if c.Meta.Name != "syz_mount_image" {
+			t.Fatalf("generated wrong call %v", c.Meta.Name)
+		}
+		segs := c.Args[4].Res
+		if segs == nil {
+			continue
+		}
+		if uintptr(len(segs.Inner)) != c.Args[3].Val {
+			t.Fatalf("nsegs is %v, but there are %v segments", c.Args[3].Val, len(segs.Inner))
+		}
+		for _, seg := range segs.Inner {
+			if len(seg.Inner) != 3 {
+				t.Fatalf("segment has %v fields, want 3", len(seg.Inner))
+			}
+			data, size, offset := seg.Inner[0], seg.Inner[1], seg.Inner[2]
+			if data.Res != nil && uintptr(len(data.Res.Data)) != size.Val {
+				t.Fatalf("segment size is %v, but data is %v bytes", size.Val, len(data.Res.Data))
+			}
+			total++
+			if offset.Val < 1<<20 {
+				small++
+			}
+		}
+	}
+	if small < total/2 {
+		t.Fatalf("only %v out of %v segments are within the first megabyte", small, total)
+	}
+}
+
+func TestUsedFields(t *testing.T) {
+	tests := []struct {
+		prog string
+		used []string
+	}{
+		{
+			"syz_test$union0(&(0x7f0000000000)={0x1, @f2=0x2})",
+			[]string{"syz_union0_struct.f", "syz_union0_struct.u", "syz_union0.f2"},
+		},
+		{
+			// The selected option is used even if it has the default value.
+			"syz_test$union0(&(0x7f0000000000)={0x0, @f0=0x0})",
+			[]string{"syz_union0.f0"},
+		},
+		{
+			"syz_test$align3(&(0x7f0000000000)={0x0, {0x1}, {0x0}})",
+			[]string{"syz_align3.f1", "syz_align3_noalign.f0"},
+		},
+		{
+			"syz_test$align6(&(0x7f0000000000)={0x1, []})",
+			[]string{"syz_align6.f0"},
+		},
+		{
+			"syz_test$align6(&(0x7f0000000000)={0x0, [0x0, 0x2]})",
+			[]string{"syz_align6.f1"},
+		},
+	}
+	for i, test := range tests {
+		p, err := Deserialize([]byte(test.prog))
+		if err != nil {
+			t.Fatalf("#%v: failed to deserialize program: %v", i, err)
+		}
+		got := UsedFields(p.Calls[0])
+		if !reflect.DeepEqual(got, test.used) {
+			t.Errorf("#%v: got used fields %v, want %v", i, got, test.used)
+		}
+	}
+}
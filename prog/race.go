@@ -0,0 +1,85 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package prog
+
+// Races: a call marked with Race is started, and the next call is started RaceDelay
+// microseconds later without waiting for completion of the first call.
+// Consecutive racing calls and the call following them run concurrently.
+// Races are honored only in threaded mode (executor and csource).
+// Unlike collide mode, which re-runs the whole program with pairs of calls
+// in parallel, races are targeted on pairs of calls that use the same resource.
+
+// raceDelays are delays (in microseconds) between starts of racing calls.
+var raceDelays = []uint64{0, 1, 10, 100, 1000}
+
+// mutateRace makes two calls that use the same resource race with each other:
+// the second call is moved right after the first one and the first call is marked with Race.
+// Calls before prefix are not touched. Returns false if no change was made.
+func (p *Prog) mutateRace(r *randGen, prefix int) bool {
+	var races []*Call
+	for _, c := range p.Calls[prefix:] {
+		if c.Race {
+			races = append(races, c)
+		}
+	}
+	if len(races) != 0 && r.oneOf(3) {
+		// Change delay of an existing race or remove it.
+		c := races[r.Intn(len(races))]
+		if r.oneOf(3) {
+			c.Race = false
+			c.RaceDelay = 0
+		} else {
+			c.RaceDelay = raceDelays[r.Intn(len(raceDelays))]
+		}
+		return true
+	}
+	pairs := p.racePairs(prefix)
+	if len(pairs) == 0 {
+		return false
+	}
+	pair := pairs[r.Intn(len(pairs))]
+	i, j := pair[0], pair[1]
+	c := p.Calls[j]
+	copy(p.Calls[i+2:j+1], p.Calls[i+1:j])
+	p.Calls[i+1] = c
+	p.Calls[i].Race = true
+	p.Calls[i].RaceDelay = raceDelays[r.Intn(len(raceDelays))]
+	return true
+}
+
+// racePairs returns indices of pairs of calls (i < j) that use the same resource,
+// such that call j can be moved right after call i
+// (i.e. call j does not use results of calls i..j-1).
+func (p *Prog) racePairs(prefix int) [][2]int {
+	owner := make(map[*Arg]int) // arg -> index of the call that contains it
+	uses := make([]map[*Arg]bool, len(p.Calls))
+	for i, c := range p.Calls {
+		uses[i] = make(map[*Arg]bool)
+		foreachArgArray(&c.Args, c.Ret, func(arg, _ *Arg, _ *[]*Arg) {
+			owner[arg] = i
+			if arg.Kind == ArgResult {
+				uses[i][arg.Res] = true
+			}
+		})
+	}
+	var pairs [][2]int
+	for j := prefix + 1; j < len(p.Calls); j++ {
+		// First call that call j can be moved after.
+		first := prefix
+		for res := range uses[j] {
+			if idx := owner[res]; idx+1 > first {
+				first = idx + 1
+			}
+		}
+		for i := first; i < j; i++ {
+			for res := range uses[j] {
+				if uses[i][res] {
+					pairs = append(pairs, [2]int{i, j})
+					break
+				}
+			}
+		}
+	}
+	return pairs
+}
@@ -0,0 +1,88 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package prog
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRaceSerialize(t *testing.T) {
+	text := "r0 = open(&(0x7f0000001000)=\"2e2f66696c653000\", 0x22c0, 0x1)\n" +
+		"read(r0, &(0x7f0000000000)=0x0, 0x1) (race=100)\n" +
+		"write(r0, &(0x7f0000000000)=\"1122\", 0x2)\n"
+	p, err := Deserialize([]byte(text))
+	if err != nil {
+		t.Fatalf("failed to deserialize: %v", err)
+	}
+	if c := p.Calls[1]; !c.Race || c.RaceDelay != 100 {
+		t.Fatalf("race is not parsed: race=%v delay=%v", c.Race, c.RaceDelay)
+	}
+	if p.Calls[0].Race || p.Calls[2].Race {
+		t.Fatalf("non-racing calls are marked with race")
+	}
+	if data := string(p.Clone().Serialize()); data != text {
+		t.Fatalf("program changed after serialization:\n%s\nwant:\n%s", data, text)
+	}
+	for _, bad := range []string{
+		"getpid() (race)\n",
+		"getpid() (foo=1)\n",
+		"getpid() (race=foo)\n",
+		"getpid() (race=1) bar\n",
+	} {
+		if _, err := Deserialize([]byte(bad)); err == nil {
+			t.Fatalf("deserialization of %q did not fail", bad)
+		}
+	}
+}
+
+func TestRacePairs(t *testing.T) {
+	p, err := Deserialize([]byte(
+		"r0 = open(&(0x7f0000001000)=\"2e2f66696c653000\", 0x22c0, 0x1)\n" +
+			"sched_yield()\n" +
+			"read(r0, &(0x7f0000000000)=0x0, 0x1)\n" +
+			"r1 = open(&(0x7f0000001000)=\"2e2f66696c653000\", 0x22c0, 0x1)\n" +
+			"write(r0, &(0x7f0000000000)=\"1122\", 0x2)\n" +
+			"close(r1)\n" +
+			"close(r1)\n"))
+	if err != nil {
+		t.Fatalf("failed to deserialize: %v", err)
+	}
+	want := [][2]int{{2, 4}, {5, 6}}
+	if pairs := p.racePairs(0); !reflect.DeepEqual(pairs, want) {
+		t.Fatalf("got pairs %v, want %v", pairs, want)
+	}
+	if pairs := p.racePairs(3); !reflect.DeepEqual(pairs, want[1:]) {
+		t.Fatalf("got pairs %v with prefix, want %v", pairs, want[1:])
+	}
+}
+
+func TestMutateRace(t *testing.T) {
+	rs, _ := initTest(t)
+	p, err := Deserialize([]byte(
+		"r0 = open(&(0x7f0000001000)=\"2e2f66696c653000\", 0x22c0, 0x1)\n" +
+			"sched_yield()\n" +
+			"read(r0, &(0x7f0000000000)=0x0, 0x1)\n" +
+			"sched_yield()\n" +
+			"write(r0, &(0x7f0000000000)=\"1122\", 0x2)\n"))
+	if err != nil {
+		t.Fatalf("failed to deserialize: %v", err)
+	}
+	if !p.mutateRace(newRand(rs), 0) {
+		t.Fatalf("mutateRace did not change the program")
+	}
+	data := string(p.Serialize())
+	lines := strings.Split(data, "\n")
+	if !strings.HasPrefix(lines[2], "read(") || !strings.Contains(lines[2], " (race=") ||
+		!strings.HasPrefix(lines[3], "write(") {
+		t.Fatalf("write is not raced with read:\n%s", data)
+	}
+	if err := p.validate(); err != nil {
+		t.Fatalf("mutated program is invalid: %v\n%s", err, data)
+	}
+	if _, err := Deserialize([]byte(data)); err != nil {
+		t.Fatalf("failed to deserialize mutated program: %v\n%s", err, data)
+	}
+}
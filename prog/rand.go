@@ -237,10 +237,35 @@ func isSpecialStruct(typ sys.Type) func(r *randGen, s *state) (*Arg, []*Call) {
 		return func(r *randGen, s *state) (*Arg, []*Call) {
 			return r.timespec(s, a, true)
 		}
+	case "fs_image_segment":
+		return func(r *randGen, s *state) (*Arg, []*Call) {
+			return r.fsImageSegment(s, a)
+		}
 	}
 	return nil
 }
 
+// fsImageSegment generates a segment of a filesystem image for syz_mount_image.
+// Filesystems keep superblocks and other metadata at few well-known offsets,
+// so offsets are biased towards them and towards the beginning of the image.
+func (r *randGen) fsImageSegment(s *state, typ *sys.StructType) (arg *Arg, calls []*Call) {
+	data, calls := r.generateArg(s, typ.Fields[0])
+	size, calls1 := r.generateArg(s, typ.Fields[1])
+	calls = append(calls, calls1...)
+	var offset uintptr
+	switch {
+	case r.nOutOf(1, 2):
+		offsets := []uintptr{0, 0x200, 0x400, 0x800, 0x1000, 0x2000, 0x8000, 0x10000}
+		offset = offsets[r.Intn(len(offsets))]
+	case r.nOutOf(2, 3):
+		offset = r.rand(1<<11) << 9
+	default:
+		offset = r.randInt()
+	}
+	arg = groupArg(typ, []*Arg{data, size, constArg(typ.Fields[2], offset)})
+	return
+}
+
 func (r *randGen) timespec(s *state, typ *sys.StructType, usec bool) (arg *Arg, calls []*Call) {
 	// We need to generate timespec/timeval that are either (1) definitely in the past,
 	// or (2) definitely in unreachable fututre, or (3) few ms ahead of now.
@@ -5,15 +5,18 @@ package repro
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/google/syzkaller/config"
 	"github.com/google/syzkaller/csource"
 	"github.com/google/syzkaller/fileutil"
+	"github.com/google/syzkaller/ipc"
 	. "github.com/google/syzkaller/log"
 	"github.com/google/syzkaller/prog"
 	"github.com/google/syzkaller/report"
@@ -38,9 +41,13 @@ type instance struct {
 	index       int
 	execprogBin string
 	executorBin string
+	descs       string // comma-separated description files for execprog (if any)
 }
 
-func Run(crashLog []byte, cfg *config.Config, vmIndexes []int) (*Result, error) {
+// Run reproduces the crash in crashLog. progsLog optionally contains the last programs
+// reported by the fuzzer over RPC (in the same format as crashLog). They are tried first,
+// because console output can be lost or intermixed when the kernel crashes.
+func Run(crashLog, progsLog []byte, cfg *config.Config, vmIndexes []int) (*Result, error) {
 	if len(vmIndexes) == 0 {
 		return nil, fmt.Errorf("no VMs provided")
 	}
@@ -48,7 +55,8 @@ func Run(crashLog []byte, cfg *config.Config, vmIndexes []int) (*Result, error)
 		return nil, fmt.Errorf("bin/syz-execprog is missing (run 'make execprog')")
 	}
 	entries := prog.ParseLog(crashLog)
-	if len(entries) == 0 {
+	recent := prog.ParseLog(progsLog)
+	if len(entries) == 0 && len(recent) == 0 {
 		return nil, fmt.Errorf("crash log does not contain any programs")
 	}
 	crashDesc, _, crashStart, _ := report.Parse(crashLog, cfg.ParsedIgnores)
@@ -56,7 +64,8 @@ func Run(crashLog []byte, cfg *config.Config, vmIndexes []int) (*Result, error)
 		crashStart = len(crashLog) // assuming VM hanged
 		crashDesc = "hang"
 	}
-	Logf(0, "reproducing crash '%v': %v programs, %v VMs", crashDesc, len(entries), len(vmIndexes))
+	Logf(0, "reproducing crash '%v': %v programs, %v recent programs, %v VMs",
+		crashDesc, len(entries), len(recent), len(vmIndexes))
 
 	ctx := &context{
 		cfg:          cfg,
@@ -100,7 +109,14 @@ func Run(crashLog []byte, cfg *config.Config, vmIndexes []int) (*Result, error)
 						time.Sleep(10 * time.Second)
 						continue
 					}
-					inst = &instance{vmInst, vmIndex, execprogBin, executorBin}
+					descs, err := copyDescriptions(vmInst, cfg.ParsedDescriptions)
+					if err != nil {
+						Logf(0, "reproducing crash '%v': failed to copy to VM: %v", crashDesc, err)
+						vmInst.Close()
+						time.Sleep(10 * time.Second)
+						continue
+					}
+					inst = &instance{vmInst, vmIndex, execprogBin, executorBin, descs}
 					break
 				}
 				if inst == nil {
@@ -115,7 +131,7 @@ func Run(crashLog []byte, cfg *config.Config, vmIndexes []int) (*Result, error)
 		close(ctx.instances)
 	}()
 
-	res, err := ctx.repro(entries, crashStart)
+	res, err := ctx.repro(entries, recent, crashStart)
 
 	close(ctx.bootRequests)
 	for inst := range ctx.instances {
@@ -124,7 +140,7 @@ func Run(crashLog []byte, cfg *config.Config, vmIndexes []int) (*Result, error)
 	return res, err
 }
 
-func (ctx *context) repro(entries []*prog.LogEntry, crashStart int) (*Result, error) {
+func (ctx *context) repro(entries, recent []*prog.LogEntry, crashStart int) (*Result, error) {
 	// Cut programs that were executed after crash.
 	for i, ent := range entries {
 		if ent.Start > crashStart {
@@ -132,19 +148,17 @@ func (ctx *context) repro(entries []*prog.LogEntry, crashStart int) (*Result, er
 			break
 		}
 	}
-	// Extract last program on every proc.
-	procs := make(map[int]int)
-	for i, ent := range entries {
-		procs[ent.Proc] = i
-	}
-	var indices []int
-	for _, idx := range procs {
-		indices = append(indices, idx)
-	}
-	sort.Ints(indices)
+	// Programs reported by the fuzzer go first, then programs from console output
+	// that are not among them (they could be executed after the last report reached manager).
 	var suspected []*prog.LogEntry
-	for i := len(indices) - 1; i >= 0; i-- {
-		suspected = append(suspected, entries[indices[i]])
+	seen := make(map[string]bool)
+	for _, ent := range append(lastProgs(recent), lastProgs(entries)...) {
+		key := ent.Config + "\n" + string(ent.P.Serialize())
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		suspected = append(suspected, ent)
 	}
 	Logf(2, "reproducing crash '%v': suspecting %v programs", ctx.crashDesc, len(suspected))
 	opts := csource.Options{
@@ -164,14 +178,15 @@ func (ctx *context) repro(entries []*prog.LogEntry, crashStart int) (*Result, er
 	var duration time.Duration
 	for _, dur := range []time.Duration{10 * time.Second, 5 * time.Minute} {
 		for _, ent := range suspected {
-			crashed, err := ctx.testProg(ent.P, dur, opts)
+			entOpts := entryOpts(ent, opts)
+			crashed, err := ctx.testProg(ent.P, dur, entOpts)
 			if err != nil {
 				return nil, err
 			}
 			if crashed {
 				res = &Result{
 					Prog: ent.P,
-					Opts: opts,
+					Opts: entOpts,
 				}
 				duration = dur * 3 / 2
 				break
@@ -217,7 +232,7 @@ func (ctx *context) repro(entries []*prog.LogEntry, crashStart int) (*Result, er
 			res.Opts = opts
 		}
 	}
-	if res.Opts.Sandbox == "namespace" {
+	if res.Opts.Sandbox == "namespace" || res.Opts.Sandbox == "seccomp" {
 		opts = res.Opts
 		opts.Sandbox = "none"
 		crashed, err := ctx.testProg(res.Prog, duration, opts)
@@ -272,6 +287,42 @@ func (ctx *context) repro(entries []*prog.LogEntry, crashStart int) (*Result, er
 	return res, nil
 }
 
+// entryOpts returns options for execution of log entry ent: executor configuration
+// of the proc that executed the program (if it is recorded in the log) overrides opts.
+func entryOpts(ent *prog.LogEntry, opts csource.Options) csource.Options {
+	if ent.Config == "" {
+		return opts
+	}
+	flags, err := ipc.ParseFlags(ent.Config)
+	if err != nil {
+		Logf(1, "reproducing crash: %v", err)
+		return opts
+	}
+	// Sandbox goes first in FlagsString format.
+	opts.Sandbox = strings.Split(ipc.FlagsString(flags), ",")[0]
+	opts.Threaded = flags&ipc.FlagThreaded != 0
+	opts.Collide = flags&ipc.FlagCollide != 0
+	return opts
+}
+
+// lastProgs returns the last program executed on every proc, the most recent first.
+func lastProgs(entries []*prog.LogEntry) []*prog.LogEntry {
+	procs := make(map[int]int)
+	for i, ent := range entries {
+		procs[ent.Proc] = i
+	}
+	var indices []int
+	for _, idx := range procs {
+		indices = append(indices, idx)
+	}
+	sort.Ints(indices)
+	var res []*prog.LogEntry
+	for i := len(indices) - 1; i >= 0; i-- {
+		res = append(res, entries[indices[i]])
+	}
+	return res
+}
+
 func (ctx *context) testProg(p *prog.Prog, duration time.Duration, opts csource.Options) (crashed bool, err error) {
 	inst := <-ctx.instances
 	if inst == nil {
@@ -294,8 +345,14 @@ func (ctx *context) testProg(p *prog.Prog, duration time.Duration, opts csource.
 	if opts.Repeat {
 		repeat = "0"
 	}
-	command := fmt.Sprintf("%v -executor %v -cover=0 -procs=%v -repeat=%v -sandbox %v -threaded=%v -collide=%v %v",
-		inst.execprogBin, inst.executorBin, opts.Procs, repeat, opts.Sandbox, opts.Threaded, opts.Collide, vmProgFile)
+	descs := ""
+	if inst.descs != "" {
+		descs = "-descriptions=" + inst.descs
+	}
+	// Leaks are detected by kmemleak scans in execprog, they are not visible in console otherwise.
+	leak := strings.HasPrefix(ctx.crashDesc, "memory leak")
+	command := fmt.Sprintf("%v -executor %v -cover=0 -procs=%v -repeat=%v -sandbox %v -threaded=%v -collide=%v -leak=%v %v %v",
+		inst.execprogBin, inst.executorBin, opts.Procs, repeat, opts.Sandbox, opts.Threaded, opts.Collide, leak, descs, vmProgFile)
 	Logf(2, "reproducing crash '%v': testing program (duration=%v, %+v): %s",
 		ctx.crashDesc, duration, opts, p)
 	return ctx.testImpl(inst, command, duration)
@@ -335,3 +392,29 @@ func (ctx *context) returnInstance(inst *instance) {
 	ctx.bootRequests <- inst.index
 	inst.Close()
 }
+
+// copyDescriptions copies syscall descriptions loaded at runtime into the VM
+// and returns their comma-separated paths inside of the VM.
+func copyDescriptions(inst vm.Instance, descs map[string][]byte) (string, error) {
+	if len(descs) == 0 {
+		return "", nil
+	}
+	dir, err := ioutil.TempDir("", "syz-repro")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	var files []string
+	for name, data := range descs {
+		file := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(file, data, 0600); err != nil {
+			return "", fmt.Errorf("failed to write descriptions: %v", err)
+		}
+		vmFile, err := inst.Copy(file)
+		if err != nil {
+			return "", err
+		}
+		files = append(files, vmFile)
+	}
+	return strings.Join(files, ","), nil
+}
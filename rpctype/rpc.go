@@ -18,12 +18,26 @@ type RpcServer struct {
 }
 
 func NewRpcServer(addr string, receiver interface{}) (*RpcServer, error) {
+	return NewNamedRpcServer(addr, "", receiver)
+}
+
+// NewNamedRpcServer is like NewRpcServer, but registers methods of receiver
+// under the given service name instead of the receiver type name (if name is not empty).
+func NewNamedRpcServer(addr, name string, receiver interface{}) (*RpcServer, error) {
 	ln, err := net.Listen("tcp", addr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to listen on %v: %v", addr, err)
 	}
 	s := rpc.NewServer()
-	s.Register(receiver)
+	if name != "" {
+		err = s.RegisterName(name, receiver)
+	} else {
+		err = s.Register(receiver)
+	}
+	if err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("failed to register rpc receiver: %v", err)
+	}
 	serv := &RpcServer{
 		ln: ln,
 		s:  s,
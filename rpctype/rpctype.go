@@ -5,6 +5,15 @@
 // between various parts of the system.
 package rpctype
 
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/syzkaller/cover"
+)
+
 type RpcInput struct {
 	Call      string
 	Prog      []byte
@@ -13,9 +22,13 @@ type RpcInput struct {
 	Cover     []uint32
 }
 
+// RpcCandidate is an untriaged input owned by manager.
 type RpcCandidate struct {
+	ID        uint64 // assigned by manager, fuzzer acknowledges processing in PollArgs.TriageDone
 	Prog      []byte
 	Minimized bool
+	Signal    []uint32 // if not empty, Prog was executed and CallIndex call produced the new signal
+	CallIndex int
 }
 
 type ConnectArgs struct {
@@ -29,6 +42,9 @@ type ConnectRes struct {
 	Candidates   []RpcCandidate
 	EnabledCalls string
 	NeedCheck    bool
+	Descriptions map[string][]byte // syscall descriptions to load at runtime (optional)
+	Quarantined  []string          // names of calls that must not be used (they cause hangs)
+	Targets      cover.Targets     // PC ranges of directed fuzzing targets (optional)
 }
 
 type CheckArgs struct {
@@ -46,12 +62,160 @@ type PollArgs struct {
 	Name      string
 	MaxSignal []uint32
 	Stats     map[string]uint64
+	DescStats map[string]DescStat // keyed by call name or "struct.field" (see prog.UsedFields)
+	Hangs     map[string]uint64   // number of program hangs attributed to the call, keyed by call name
+	CallStats map[string]CallStat // keyed by call name
+	// Inputs with new signal found by the fuzzer since the last poll. The fuzzer triages
+	// the inputs itself, manager only keeps them in case the fuzzer is lost.
+	NewTriage []RpcCandidate
+	// Manager IDs of untriaged inputs the fuzzer has finished triaging since the last poll.
+	TriageDone []uint64
+}
+
+// DescStat describes how useful a syscall description element (call, struct field or union option) is.
+type DescStat struct {
+	Execs     uint64 // number of executed calls that used the element
+	NewSignal uint64 // number of those executions that produced new signal
+}
+
+// CallStat describes executions of a syscall: their results and a histogram of execution times.
+type CallStat struct {
+	Execs      uint64         // number of executed calls (including unfinished ones)
+	Successes  uint64         // number of calls that finished with errno 0
+	NewSignal  uint64         // number of executions that produced new signal
+	Errnos     map[int]uint64 // number of failed calls per errno
+	Hist       []uint64       // number of calls per LatencyBuckets bucket (has len(LatencyBuckets)+1 elements)
+	Blocked    uint64         // number of calls that blocked in threaded mode
+	Unfinished uint64         // number of calls that did not finish before program end
+}
+
+// LatencyBuckets are upper bounds of CallStat.Hist buckets, the last bucket is unbounded.
+var LatencyBuckets = []time.Duration{
+	10 * time.Microsecond,
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+}
+
+// Add accounts a call that executed for time d and finished with errno (if finished is set).
+func (st *CallStat) Add(d time.Duration, errno int, blocked, finished, newSignal bool) {
+	st.Execs++
+	if finished {
+		if errno == 0 {
+			st.Successes++
+		} else {
+			if st.Errnos == nil {
+				st.Errnos = make(map[int]uint64)
+			}
+			st.Errnos[errno]++
+		}
+	} else {
+		st.Unfinished++
+	}
+	if newSignal {
+		st.NewSignal++
+	}
+	if st.Hist == nil {
+		st.Hist = make([]uint64, len(LatencyBuckets)+1)
+	}
+	i := 0
+	for i < len(LatencyBuckets) && d > LatencyBuckets[i] {
+		i++
+	}
+	st.Hist[i]++
+	if blocked {
+		st.Blocked++
+	}
+}
+
+// Merge adds counts from other to st.
+func (st *CallStat) Merge(other CallStat) {
+	st.Execs += other.Execs
+	st.Successes += other.Successes
+	st.NewSignal += other.NewSignal
+	for errno, n := range other.Errnos {
+		if st.Errnos == nil {
+			st.Errnos = make(map[int]uint64)
+		}
+		st.Errnos[errno] += n
+	}
+	if st.Hist == nil {
+		st.Hist = make([]uint64, len(LatencyBuckets)+1)
+	}
+	for i, v := range other.Hist {
+		if i < len(st.Hist) {
+			st.Hist[i] += v
+		}
+	}
+	st.Blocked += other.Blocked
+	st.Unfinished += other.Unfinished
+}
+
+// RecentProgsPerProc is the number of last executed programs per fuzzer proc
+// that manager keeps for crash attribution.
+const RecentProgsPerProc = 10
+
+// RecentProg is a program executed by a fuzzer proc.
+type RecentProg struct {
+	Seq    uint64 // execution sequence number within the fuzzer, consecutive for programs of one executor request
+	Proc   int
+	Config string // executor configuration of the proc (see ipc.FlagsString)
+	Prog   []byte
+}
+
+// RecentProgSet keeps the last RecentProgsPerProc programs of every proc.
+type RecentProgSet map[int][]RecentProg
+
+// Add adds programs to the set, older programs of the same proc are dropped.
+func (set RecentProgSet) Add(progs []RecentProg) {
+	for _, p := range progs {
+		pp := append(set[p.Proc], p)
+		if len(pp) > RecentProgsPerProc {
+			pp = append([]RecentProg{}, pp[len(pp)-RecentProgsPerProc:]...)
+		}
+		set[p.Proc] = pp
+	}
+}
+
+// Log formats the programs as an execution log in the same format as fuzzer console output
+// (see prog.ParseLog), the last executed program goes last. Returns nil if the set is empty.
+func (set RecentProgSet) Log() []byte {
+	var progs []RecentProg
+	for _, pp := range set {
+		progs = append(progs, pp...)
+	}
+	if len(progs) == 0 {
+		return nil
+	}
+	sort.Sort(recentProgArray(progs))
+	buf := new(bytes.Buffer)
+	for _, p := range progs {
+		fmt.Fprintf(buf, "executing program %v (%v):\n%s\n", p.Proc, p.Config, p.Prog)
+	}
+	return buf.Bytes()
+}
+
+type recentProgArray []RecentProg
+
+func (a recentProgArray) Len() int           { return len(a) }
+func (a recentProgArray) Less(i, j int) bool { return a[i].Seq < a[j].Seq }
+func (a recentProgArray) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+// RecentArgs sends programs that a fuzzer is about to execute to manager, so that crashes can be
+// attributed to programs even if console output is lost or intermixed.
+type RecentArgs struct {
+	Name  string
+	Progs []RecentProg
 }
 
 type PollRes struct {
-	Candidates []RpcCandidate
-	NewInputs  []RpcInput
-	MaxSignal  []uint32
+	Candidates  []RpcCandidate
+	NewInputs   []RpcInput
+	MaxSignal   []uint32
+	Quarantined []string // see ConnectRes.Quarantined
+	TriageIDs   []uint64 // manager IDs of PollArgs.NewTriage
 }
 
 type HubConnectArgs struct {
@@ -0,0 +1,58 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package rpctype
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCallStatAdd(t *testing.T) {
+	var st CallStat
+	st.Add(time.Microsecond, 0, false, true, true)
+	st.Add(50*time.Microsecond, 22, false, true, false)
+	st.Add(time.Millisecond, 22, true, true, false)
+	st.Add(time.Hour, 0, true, false, false)
+	want := CallStat{
+		Execs:      4,
+		Successes:  1,
+		NewSignal:  1,
+		Errnos:     map[int]uint64{22: 2},
+		Hist:       []uint64{1, 1, 1, 0, 0, 0, 1},
+		Blocked:    2,
+		Unfinished: 1,
+	}
+	if !reflect.DeepEqual(st, want) {
+		t.Fatalf("got %+v\nwant %+v", st, want)
+	}
+}
+
+func TestCallStatMerge(t *testing.T) {
+	var st1, st2, empty CallStat
+	st1.Add(time.Microsecond, 0, false, true, true)
+	st1.Add(time.Millisecond, 1, false, true, false)
+	st2.Add(time.Second, 1, true, true, false)
+	st2.Add(time.Second, 2, false, false, true)
+	st2.Add(time.Hour, 13, false, true, false)
+	st1.Merge(st2)
+	st1.Merge(empty)
+	want := CallStat{
+		Execs:      5,
+		Successes:  1,
+		NewSignal:  2,
+		Errnos:     map[int]uint64{1: 2, 13: 1},
+		Hist:       []uint64{1, 0, 1, 0, 0, 2, 1},
+		Blocked:    1,
+		Unfinished: 1,
+	}
+	if !reflect.DeepEqual(st1, want) {
+		t.Fatalf("got %+v\nwant %+v", st1, want)
+	}
+	// Merging into an empty stat gives the same stat.
+	empty.Merge(st1)
+	if !reflect.DeepEqual(empty, want) {
+		t.Fatalf("got %+v\nwant %+v", empty, want)
+	}
+}
@@ -607,6 +607,14 @@ var (
 func init() {
 	initCalls()
 	initStructFields()
+	initDescriptions()
+}
+
+// initDescriptions finishes initialization of Calls, Structs and Resources
+// regardless of whether they are compiled-in or loaded at runtime.
+func initDescriptions() {
+	ctors = make(map[string][]*Call)
+	CallMap = make(map[string]*Call)
 	initResources()
 	initAlign()
 
@@ -0,0 +1,752 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package sys
+
+// Runtime loading of syscall descriptions.
+// The loader builds the same Call/Type graph that sysgen emits as Go code,
+// so the two must be kept in sync (see generateArg in sysgen/sysgen.go).
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/syzkaller/sysparser"
+)
+
+// RuntimeDescriptions is set when descriptions were loaded with LoadDescriptions.
+// In this mode executor does not have a matching syscall table,
+// so calls are identified by kernel syscall numbers (see ExecNum).
+var RuntimeDescriptions bool
+
+// ExecNum returns the number that identifies the call in executor programs.
+// Calls without a syscall number (NR == -1) can't be executed
+// when descriptions are loaded at runtime.
+func (c *Call) ExecNum() int {
+	if RuntimeDescriptions {
+		return c.NR
+	}
+	return c.ID
+}
+
+// CallByExecNum returns the call identified by num (see ExecNum), or nil.
+func CallByExecNum(num int) *Call {
+	if !RuntimeDescriptions {
+		if num < 0 || num >= len(Calls) {
+			return nil
+		}
+		return Calls[num]
+	}
+	for _, c := range Calls {
+		if c.NR == num {
+			return c
+		}
+	}
+	return nil
+}
+
+// CallsHash returns hash of the syscall table (see sysparser.CallsHash).
+// It matches the hash compiled into executor only if executor was generated
+// from the same descriptions and descriptions are not loaded at runtime.
+// Only call names are hashed, so changes to arguments of existing calls are not detected.
+func CallsHash() uint32 {
+	names := make([]string, len(Calls))
+	for i, c := range Calls {
+		names[i] = c.Name
+	}
+	return sysparser.CallsHash(names)
+}
+
+// ReadDescriptions reads description (*.txt) and const (*_arch.const) files.
+// Each path is either a file or a directory with such files.
+// All descriptions are merged into a single "sys.txt" file and consts
+// are merged into a single "sys_arch.const" file per arch, so that the result
+// is compact enough to be passed over RPC or copied into a VM.
+func ReadDescriptions(paths []string) (map[string][]byte, error) {
+	var files []string
+	for _, path := range paths {
+		st, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat descriptions: %v", err)
+		}
+		if !st.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		for _, pattern := range []string{"*.txt", "*.const"} {
+			matches, err := filepath.Glob(filepath.Join(path, pattern))
+			if err != nil {
+				return nil, fmt.Errorf("failed to find description files: %v", err)
+			}
+			files = append(files, matches...)
+		}
+	}
+	sort.Strings(files)
+	res := make(map[string][]byte)
+	for _, file := range files {
+		name := filepath.Base(file)
+		switch {
+		case strings.HasSuffix(name, ".txt"):
+			name = "sys.txt"
+		case strings.HasSuffix(name, ".const") && strings.LastIndexByte(name, '_') != -1:
+			name = "sys" + name[strings.LastIndexByte(name, '_'):]
+		default:
+			return nil, fmt.Errorf("unknown description file %v", file)
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read description file: %v", err)
+		}
+		if len(data) != 0 && data[len(data)-1] != '\n' {
+			data = append(data, '\n')
+		}
+		res[name] = append(res[name], data...)
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("no description files found in %v", paths)
+	}
+	return res, nil
+}
+
+// LoadDescriptions replaces compiled-in descriptions with descriptions
+// built from the files returned by ReadDescriptions.
+// Only const files for the current arch are used.
+// Note: syntax errors in descriptions are fatal (sysparser exits the process).
+func LoadDescriptions(files map[string][]byte) (err error) {
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var txt []byte
+	consts := make(map[string]uint64)
+	for _, name := range names {
+		data := files[name]
+		if strings.HasSuffix(name, ".txt") {
+			txt = append(txt, data...)
+			txt = append(txt, '\n')
+		} else if strings.HasSuffix(name, "_"+runtime.GOARCH+".const") {
+			if err := parseConsts(name, data, consts); err != nil {
+				return err
+			}
+		}
+	}
+	if len(txt) == 0 {
+		return fmt.Errorf("no syscall descriptions")
+	}
+	for name, nr := range sysparser.PseudoSyscalls {
+		consts["__NR_"+name] = nr
+	}
+	desc := sysparser.Parse(bytes.NewReader(txt))
+
+	defer func() {
+		if e := recover(); e != nil {
+			le, ok := e.(loadError)
+			if !ok {
+				panic(e)
+			}
+			err = le
+		}
+	}()
+	l := &loader{
+		desc:      desc,
+		consts:    consts,
+		structs:   make(map[string]Type),
+		resources: make(map[string]*ResourceDesc),
+	}
+	l.loadResources()
+	structMap := l.loadStructs()
+	var calls []*Call
+	seen := make(map[string]bool)
+	for _, s := range desc.Syscalls {
+		if seen[s.Name] {
+			return fmt.Errorf("duplicate syscall %v", s.Name)
+		}
+		seen[s.Name] = true
+		calls = append(calls, l.loadCall(s))
+	}
+	for key, str := range structMap {
+		l.loadStructFields(str, key)
+	}
+
+	Calls = calls
+	Structs = l.structs
+	Resources = l.resources
+	RuntimeDescriptions = true
+	initDescriptions()
+	return nil
+}
+
+func parseConsts(file string, data []byte, consts map[string]uint64) error {
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line := s.Text()
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		eq := strings.IndexByte(line, '=')
+		if eq == -1 {
+			return fmt.Errorf("malformed const file %v: no '=' in '%v'", file, line)
+		}
+		name := strings.TrimSpace(line[:eq])
+		val, err := parseValue(strings.TrimSpace(line[eq+1:]))
+		if err != nil {
+			return fmt.Errorf("malformed const file %v: bad value in '%v'", file, line)
+		}
+		if old, ok := consts[name]; ok && old != val {
+			return fmt.Errorf("const %v has different values: %v vs %v", name, old, val)
+		}
+		consts[name] = val
+	}
+	return s.Err()
+}
+
+func parseValue(v string) (uint64, error) {
+	val, err := strconv.ParseUint(v, 0, 64)
+	if err != nil {
+		sval, err1 := strconv.ParseInt(v, 0, 64)
+		if err1 != nil {
+			return 0, err
+		}
+		val = uint64(sval)
+	}
+	return val, nil
+}
+
+type loadError string
+
+func (err loadError) Error() string {
+	return string(err)
+}
+
+type loader struct {
+	desc      *sysparser.Description
+	consts    map[string]uint64
+	structs   map[string]Type
+	resources map[string]*ResourceDesc
+}
+
+type structKey struct {
+	name  string
+	field string
+	dir   string
+}
+
+func (key structKey) String() string {
+	// Must match keys of the generated Structs map.
+	return fmt.Sprintf("{%v %v %v}", key.name, key.field, key.dir)
+}
+
+func (l *loader) failf(msg string, args ...interface{}) {
+	panic(loadError(fmt.Sprintf(msg, args...)))
+}
+
+func (l *loader) value(v string) uintptr {
+	val, err := parseValue(v)
+	if err != nil {
+		l.failf("failed to parse value '%v'", v)
+	}
+	return uintptr(val)
+}
+
+// lookup returns value of a named const or parses v as a number.
+func (l *loader) lookup(v string) uintptr {
+	if val, ok := l.consts[v]; ok {
+		return uintptr(val)
+	}
+	return l.value(v)
+}
+
+// flagVals returns values of the named flags for the current arch.
+func (l *loader) flagVals(name string) []uintptr {
+	vals, ok := l.desc.Flags[name]
+	if !ok {
+		l.failf("unknown flag %v", name)
+	}
+	var res []uintptr
+	for _, val := range vals {
+		if isIdentifier(val) {
+			// Flags that are not defined for this arch are dropped.
+			if v, ok := l.consts[val]; ok {
+				res = append(res, uintptr(v))
+			}
+		} else {
+			res = append(res, l.value(val))
+		}
+	}
+	return res
+}
+
+func (l *loader) loadResources() {
+	for _, res := range l.desc.Resources {
+		underlying := ""
+		name := res.Name
+		kind := []string{name}
+		var values []uintptr
+	loop:
+		for {
+			var values1 []uintptr
+			for _, v := range res.Values {
+				if v1, ok := l.consts[v]; ok {
+					values1 = append(values1, uintptr(v1))
+				} else if !isIdentifier(v) {
+					values1 = append(values1, l.value(v))
+				}
+			}
+			values = append(values1, values...)
+			switch res.Base {
+			case "int8", "int16", "int32", "int64", "intptr":
+				underlying = res.Base
+				break loop
+			default:
+				if _, ok := l.desc.Resources[res.Base]; !ok {
+					l.failf("resource '%v' has unknown parent resource '%v'", name, res.Base)
+				}
+				kind = append([]string{res.Base}, kind...)
+				res = l.desc.Resources[res.Base]
+			}
+		}
+		if len(values) == 0 {
+			values = append(values, 0)
+		}
+		l.resources[name] = &ResourceDesc{
+			Name:   name,
+			Type:   l.arg("", "resource-type", underlying, "inout", nil, true, true),
+			Kind:   kind,
+			Values: values,
+		}
+	}
+}
+
+// loadStructs creates struct/union types without fields, fields are filled in
+// by loadStructFields once all types exist (structs can refer to each other).
+// Like in sysgen, there is an instance of a struct for every direction and
+// every field name under which the struct is used.
+func (l *loader) loadStructs() map[structKey]sysparser.Struct {
+	structMap := make(map[structKey]sysparser.Struct)
+	for _, str := range l.desc.Structs {
+		for _, dir := range []string{"in", "out", "inout"} {
+			structMap[structKey{str.Name, "", dir}] = str
+		}
+		for _, a := range str.Flds {
+			if innerStr, ok := l.desc.Structs[a[1]]; ok {
+				for _, dir := range []string{"in", "out", "inout"} {
+					structMap[structKey{a[1], a[0], dir}] = innerStr
+				}
+			}
+		}
+	}
+	for key, str := range structMap {
+		common := TypeCommon{TypeName: key.name, FldName: key.field, ArgDir: l.dir(key.dir)}
+		if str.IsUnion {
+			l.structs[key.String()] = &UnionType{TypeCommon: common, varlen: str.Varlen}
+		} else {
+			l.structs[key.String()] = &StructType{
+				TypeCommon: common,
+				packed:     str.Packed,
+				align:      uintptr(str.Align),
+				varlen:     str.Varlen,
+			}
+		}
+	}
+	return structMap
+}
+
+func (l *loader) loadStructFields(str sysparser.Struct, key structKey) {
+	switch s := l.structs[key.String()].(type) {
+	case *StructType:
+		for _, a := range str.Flds {
+			s.Fields = append(s.Fields, l.arg(str.Name, a[0], a[1], key.dir, a[2:], false, true))
+		}
+	case *UnionType:
+		for _, a := range str.Flds {
+			s.Options = append(s.Options, l.arg(str.Name, a[0], a[1], key.dir, a[2:], false, true))
+		}
+	}
+}
+
+func (l *loader) loadCall(s sysparser.Syscall) *Call {
+	c := &Call{Name: s.Name, CallName: s.CallName, NR: -1}
+	if nr, ok := l.consts["__NR_"+s.CallName]; ok {
+		c.NR = int(nr)
+	}
+	if len(s.Ret) != 0 {
+		c.Ret = l.arg("", "ret", s.Ret[0], "out", s.Ret[1:], true, false)
+	}
+	c.Args = []Type{}
+	for _, a := range s.Args {
+		c.Args = append(c.Args, l.arg("", a[0], a[1], "in", a[2:], true, false))
+	}
+	return c
+}
+
+var intRegExp = regexp.MustCompile("^int([0-9]+|ptr)(be)?(:[0-9]+)?$")
+
+func (l *loader) arg(parent, name, typ, dir string, a []string, isArg, isField bool) Type {
+	opt := false
+	for i, v := range a {
+		if v == "opt" {
+			opt = true
+			a = append(append([]string{}, a[:i]...), a[i+1:]...)
+			break
+		}
+	}
+	common := func() TypeCommon {
+		return TypeCommon{TypeName: typ, FldName: name, ArgDir: l.dir(dir), IsOptional: opt}
+	}
+	intCommon := func(typeSize uint64, bigEndian bool, bitfieldLen uint64) IntTypeCommon {
+		// BitfieldOff and BitfieldLst will be filled in in initAlign().
+		return IntTypeCommon{TypeCommon: common(), TypeSize: uintptr(typeSize), BigEndian: bigEndian, BitfieldLen: uintptr(bitfieldLen)}
+	}
+	wantArgs := func(want int) {
+		if len(a) != want {
+			l.failf("wrong number of arguments for %v arg %v, want %v, got %v", typ, name, want, len(a))
+		}
+	}
+	// intArgs checks number of arguments of an integer type and decodes
+	// the underlying int type which is present only for struct fields.
+	intArgs := func(want, intArg int) (uint64, bool, uint64) {
+		if !isField {
+			wantArgs(want)
+			return ptrSize, false, 0
+		}
+		wantArgs(want + 1)
+		return l.decodeIntType(a[intArg])
+	}
+	var t Type
+	canBeArg := false
+	switch typ {
+	case "fileoff":
+		canBeArg = true
+		size, bigEndian, bitfieldLen := intArgs(0, 0)
+		t = &IntType{IntTypeCommon: intCommon(size, bigEndian, bitfieldLen), Kind: IntFileoff}
+	case "buffer":
+		canBeArg = true
+		wantArgs(1)
+		ptrCommon := common()
+		dir = a[0]
+		opt = false
+		t = &PtrType{TypeCommon: ptrCommon, Type: &BufferType{TypeCommon: common(), Kind: BufferBlobRand}}
+	case "string":
+		if len(a) > 2 {
+			l.failf("wrong number of arguments for %v arg %v, want 0-2, got %v", typ, name, len(a))
+		}
+		var vals []string
+		subkind := ""
+		if len(a) >= 1 {
+			if a[0][0] == '"' {
+				vals = append(vals, a[0][1:len(a[0])-1])
+			} else {
+				vals1, ok := l.desc.StrFlags[a[0]]
+				if !ok {
+					l.failf("unknown string flags %v", a[0])
+				}
+				vals = append([]string{}, vals1...)
+				subkind = a[0]
+			}
+		}
+		for i, s := range vals {
+			vals[i] = s + "\x00"
+		}
+		var size uint64
+		if len(a) >= 2 {
+			if v, ok := l.consts[a[1]]; ok {
+				size = v
+			} else {
+				v, err := strconv.ParseUint(a[1], 10, 64)
+				if err != nil {
+					l.failf("failed to parse string length %v for %v", a[1], name)
+				}
+				size = v
+			}
+			for i, s := range vals {
+				if uint64(len(s)) > size {
+					l.failf("string value %q exceeds buffer length %v for arg %v", s, size, name)
+				}
+				for uint64(len(s)) < size {
+					s += "\x00"
+				}
+				vals[i] = s
+			}
+		} else {
+			for _, s := range vals {
+				if size != 0 && size != uint64(len(s)) {
+					size = 0
+					break
+				}
+				size = uint64(len(s))
+			}
+		}
+		t = &BufferType{TypeCommon: common(), Kind: BufferString, SubKind: subkind, Values: vals, Length: uintptr(size)}
+	case "vma":
+		canBeArg = true
+		var begin, end uintptr
+		switch len(a) {
+		case 0:
+		case 1:
+			begin, end = l.parseRange(a[0])
+		default:
+			l.failf("wrong number of arguments for %v arg %v, want 0 or 1, got %v", typ, name, len(a))
+		}
+		t = &VmaType{TypeCommon: common(), RangeBegin: int64(begin), RangeEnd: int64(end)}
+	case "len", "bytesize", "bytesize2", "bytesize4", "bytesize8":
+		canBeArg = true
+		size, bigEndian, bitfieldLen := intArgs(1, 1)
+		byteSize := uintptr(0)
+		if typ != "len" {
+			byteSize = 1
+			if typ != "bytesize" {
+				byteSize = uintptr(typ[8] - '0')
+			}
+		}
+		t = &LenType{IntTypeCommon: intCommon(size, bigEndian, bitfieldLen), Buf: a[0], ByteSize: byteSize}
+	case "csum":
+		if len(a) != 3 && len(a) != 4 {
+			l.failf("wrong number of arguments for %v arg %v, want 3-4, got %v", typ, name, len(a))
+		}
+		var size, bitfieldLen, protocol uint64
+		var bigEndian bool
+		var kind CsumKind
+		switch a[1] {
+		case "inet":
+			kind = CsumInet
+			size, bigEndian, bitfieldLen = l.decodeIntType(a[2])
+		case "pseudo":
+			if len(a) != 4 {
+				l.failf("wrong number of arguments for %v arg %v, want 4, got %v", typ, name, len(a))
+			}
+			kind = CsumPseudo
+			size, bigEndian, bitfieldLen = l.decodeIntType(a[3])
+			if v, ok := l.consts[a[2]]; ok {
+				protocol = v
+			} else {
+				v, err := strconv.ParseUint(a[2], 10, 64)
+				if err != nil {
+					l.failf("failed to parse protocol %v", a[2])
+				}
+				protocol = v
+			}
+		default:
+			l.failf("unknown checksum kind '%v'", a[1])
+		}
+		t = &CsumType{IntTypeCommon: intCommon(size, bigEndian, bitfieldLen), Buf: a[0], Kind: kind, Protocol: protocol}
+	case "flags":
+		canBeArg = true
+		size, bigEndian, bitfieldLen := intArgs(1, 1)
+		vals := l.flagVals(a[0])
+		if len(vals) == 0 {
+			t = &IntType{IntTypeCommon: intCommon(size, bigEndian, bitfieldLen)}
+		} else {
+			t = &FlagsType{IntTypeCommon: intCommon(size, bigEndian, bitfieldLen), Vals: vals}
+		}
+	case "const":
+		canBeArg = true
+		size, bigEndian, bitfieldLen := intArgs(1, 1)
+		var val uintptr
+		if v, ok := l.consts[a[0]]; ok {
+			val = uintptr(v)
+		} else if !isIdentifier(a[0]) {
+			val = l.value(a[0])
+		}
+		// Identifiers without a value for this arch are 0 (sysgen does the same).
+		t = &ConstType{IntTypeCommon: intCommon(size, bigEndian, bitfieldLen), Val: val}
+	case "proc":
+		canBeArg = true
+		size, bigEndian, bitfieldLen := intArgs(2, 0)
+		valuesStart, valuesPerProc := a[0], a[1]
+		if isField {
+			valuesStart, valuesPerProc = a[1], a[2]
+		}
+		valuesStartInt, err := strconv.ParseInt(valuesStart, 10, 64)
+		if err != nil {
+			l.failf("couldn't parse '%v' as int64", valuesStart)
+		}
+		valuesPerProcInt, err := strconv.ParseInt(valuesPerProc, 10, 64)
+		if err != nil {
+			l.failf("couldn't parse '%v' as int64", valuesPerProc)
+		}
+		if valuesPerProcInt < 1 {
+			l.failf("values per proc '%v' should be >= 1", valuesPerProcInt)
+		}
+		if size != 8 && valuesStartInt >= (1<<(size*8)) {
+			l.failf("values starting from '%v' overflow desired type of size '%v'", valuesStartInt, size)
+		}
+		const maxPids = 32 // executor knows about this constant (MAX_PIDS)
+		if size != 8 && valuesStartInt+maxPids*valuesPerProcInt >= (1<<(size*8)) {
+			l.failf("not enough values starting from '%v' with step '%v' and type size '%v' for 32 procs", valuesStartInt, valuesPerProcInt, size)
+		}
+		t = &ProcType{IntTypeCommon: intCommon(size, bigEndian, bitfieldLen), ValuesStart: valuesStartInt, ValuesPerProc: uint64(valuesPerProcInt)}
+	case "signalno":
+		canBeArg = true
+		wantArgs(0)
+		t = &IntType{IntTypeCommon: intCommon(4, false, 0), Kind: IntSignalno}
+	case "filename":
+		wantArgs(0)
+		t = &BufferType{TypeCommon: common(), Kind: BufferFilename}
+	case "text":
+		wantArgs(1)
+		var kind TextKind
+		switch a[0] {
+		case "x86_real":
+			kind = Text_x86_real
+		case "x86_16":
+			kind = Text_x86_16
+		case "x86_32":
+			kind = Text_x86_32
+		case "x86_64":
+			kind = Text_x86_64
+		case "arm64":
+			kind = Text_arm64
+		default:
+			l.failf("unknown text type %v for %v arg %v", a[0], typ, name)
+		}
+		t = &BufferType{TypeCommon: common(), Kind: BufferText, Text: kind}
+	case "array":
+		if len(a) != 1 && len(a) != 2 {
+			l.failf("wrong number of arguments for %v arg %v, want 1 or 2, got %v", typ, name, len(a))
+		}
+		if len(a) == 1 {
+			if a[0] == "int8" {
+				t = &BufferType{TypeCommon: common(), Kind: BufferBlobRand}
+			} else {
+				t = &ArrayType{TypeCommon: common(), Type: l.typ(a[0], dir), Kind: ArrayRandLen}
+			}
+		} else {
+			begin, end := l.parseRange(a[1])
+			if a[0] == "int8" {
+				t = &BufferType{TypeCommon: common(), Kind: BufferBlobRange, RangeBegin: begin, RangeEnd: end}
+			} else {
+				t = &ArrayType{TypeCommon: common(), Type: l.typ(a[0], dir), Kind: ArrayRangeLen, RangeBegin: begin, RangeEnd: end}
+			}
+		}
+	case "ptr":
+		canBeArg = true
+		wantArgs(2)
+		dir = "in"
+		t = &PtrType{TypeCommon: common(), Type: l.typ(a[1], a[0])}
+	default:
+		if intRegExp.MatchString(typ) {
+			canBeArg = true
+			size, bigEndian, bitfieldLen := l.decodeIntType(typ)
+			switch len(a) {
+			case 0:
+				t = &IntType{IntTypeCommon: intCommon(size, bigEndian, bitfieldLen)}
+			case 1:
+				begin, end := l.parseRange(a[0])
+				t = &IntType{IntTypeCommon: intCommon(size, bigEndian, bitfieldLen), Kind: IntRange,
+					RangeBegin: int64(begin), RangeEnd: int64(end)}
+			default:
+				l.failf("wrong number of arguments for %v arg %v, want 0 or 1, got %v", typ, name, len(a))
+			}
+		} else if strings.HasPrefix(typ, "unnamed") {
+			inner, ok := l.desc.Unnamed[typ]
+			if !ok {
+				l.failf("unknown unnamed type '%v'", typ)
+			}
+			t = l.arg("", "", inner[0], dir, inner[1:], false, isField)
+		} else if _, ok := l.desc.Structs[typ]; ok {
+			if len(a) != 0 {
+				l.failf("struct '%v' has args", typ)
+			}
+			t = l.structs[structKey{typ, name, dir}.String()]
+		} else if _, ok := l.desc.Resources[typ]; ok {
+			if len(a) != 0 {
+				l.failf("resource '%v' has args", typ)
+			}
+			return &ResourceType{TypeCommon: common(), Desc: l.resources[typ]}
+		} else {
+			l.failf("unknown arg type \"%v\" for %v", typ, name)
+		}
+	}
+	if isArg && !canBeArg {
+		l.failf("%v %v can't be syscall argument/return", name, typ)
+	}
+	return t
+}
+
+func (l *loader) typ(typ, dir string) Type {
+	return l.arg("", "", typ, dir, nil, false, true)
+}
+
+func (l *loader) dir(s string) Dir {
+	switch s {
+	case "in":
+		return DirIn
+	case "out":
+		return DirOut
+	case "inout":
+		return DirInOut
+	default:
+		l.failf("bad direction %v", s)
+		return 0
+	}
+}
+
+func (l *loader) parseRange(buffer string) (uintptr, uintptr) {
+	parts := strings.Split(buffer, ":")
+	switch len(parts) {
+	case 1:
+		v := l.lookup(buffer)
+		return v, v
+	case 2:
+		return l.lookup(parts[0]), l.lookup(parts[1])
+	default:
+		l.failf("bad range: %v", buffer)
+		return 0, 0
+	}
+}
+
+func (l *loader) decodeIntType(typ string) (uint64, bool, uint64) {
+	bigEndian := false
+	bitfieldLen := uint64(0)
+
+	parts := strings.Split(typ, ":")
+	if len(parts) == 2 {
+		var err error
+		bitfieldLen, err = strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			l.failf("failed to parse bitfield length '%v'", parts[1])
+		}
+		typ = parts[0]
+	}
+
+	if strings.HasSuffix(typ, "be") {
+		bigEndian = true
+		typ = typ[:len(typ)-2]
+	}
+
+	switch typ {
+	case "int8", "int16", "int32", "int64", "intptr":
+	default:
+		l.failf("unknown type %v", typ)
+	}
+	sz := int64(ptrSize * 8)
+	if typ != "intptr" {
+		sz, _ = strconv.ParseInt(typ[3:], 10, 64)
+	}
+
+	if bitfieldLen > uint64(sz) {
+		l.failf("bitfield of size %v is too large for base type of size %v", bitfieldLen, sz/8)
+	}
+
+	return uint64(sz / 8), bigEndian, bitfieldLen
+}
+
+func isIdentifier(s string) bool {
+	for i, c := range s {
+		if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || i > 0 && (c >= '0' && c <= '9') {
+			continue
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,60 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package sys
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLoadDescriptions(t *testing.T) {
+	files, err := ReadDescriptions([]string{"."})
+	if err != nil {
+		t.Fatalf("failed to read descriptions: %v", err)
+	}
+	calls, structs, resources, callMap, ctors0 := Calls, Structs, Resources, CallMap, ctors
+	defer func() {
+		Calls, Structs, Resources, CallMap, ctors = calls, structs, resources, callMap, ctors0
+		RuntimeDescriptions = false
+	}()
+	if err := LoadDescriptions(files); err != nil {
+		t.Fatalf("failed to load descriptions: %v", err)
+	}
+	if len(Calls) != len(calls) {
+		t.Fatalf("loaded %v calls, want %v", len(Calls), len(calls))
+	}
+	for i, c := range Calls {
+		if !reflect.DeepEqual(c, calls[i]) {
+			t.Fatalf("loaded call %v does not match compiled-in call %v", c.Name, calls[i].Name)
+		}
+	}
+	if !reflect.DeepEqual(Resources, resources) {
+		t.Fatalf("loaded resources do not match compiled-in resources")
+	}
+	for _, c := range Calls {
+		if c.ExecNum() != c.NR {
+			t.Fatalf("call %v: exec num %v, want %v", c.Name, c.ExecNum(), c.NR)
+		}
+		if c.NR != -1 && CallByExecNum(c.NR).NR != c.NR {
+			t.Fatalf("call %v: can't find call by exec num %v", c.Name, c.NR)
+		}
+	}
+}
+
+func TestLoadDescriptionsErrors(t *testing.T) {
+	tests := []string{
+		"foo(a ptr[in, bar])\n",
+		"foo(a int32[BAR:BAZ:1])\n",
+		"foo(a flags[bar])\n",
+		"foo(a string)\n",
+	}
+	for i, test := range tests {
+		if err := LoadDescriptions(map[string][]byte{"sys.txt": []byte(test)}); err == nil {
+			t.Errorf("#%v: no error for %q", i, test)
+		}
+	}
+	if RuntimeDescriptions {
+		t.Fatalf("descriptions are marked as loaded after errors")
+	}
+}
@@ -22,18 +22,13 @@ var archs = []*Arch{
 	{"ppc64le", []string{"__ppc64__", "__PPC64__", "__powerpc64__"}},
 }
 
-var syzkalls = map[string]uint64{
-	"syz_test":          1000001,
-	"syz_open_dev":      1000002,
-	"syz_open_pts":      1000003,
-	"syz_fuse_mount":    1000004,
-	"syz_fuseblk_mount": 1000005,
-	"syz_emit_ethernet": 1000006,
-	"syz_kvm_setup_cpu": 1000007,
-}
-
 func generateExecutorSyscalls(syscalls []Syscall, consts map[string]map[string]uint64) {
 	var data SyscallsData
+	var names []string
+	for _, c := range syscalls {
+		names = append(names, c.Name)
+	}
+	data.Hash = CallsHash(names)
 	for _, arch := range archs {
 		var calls []SyscallData
 		for _, c := range syscalls {
@@ -45,7 +40,7 @@ func generateExecutorSyscalls(syscalls []Syscall, consts map[string]map[string]u
 		}
 		data.Archs = append(data.Archs, ArchData{arch.CARCH, calls})
 	}
-	for name, nr := range syzkalls {
+	for name, nr := range PseudoSyscalls {
 		data.FakeCalls = append(data.FakeCalls, SyscallData{name, int(nr)})
 	}
 	sort.Sort(SyscallArray(data.FakeCalls))
@@ -62,6 +57,7 @@ func generateExecutorSyscalls(syscalls []Syscall, consts map[string]map[string]u
 type SyscallsData struct {
 	Archs     []ArchData
 	FakeCalls []SyscallData
+	Hash      uint32
 }
 
 type ArchData struct {
@@ -85,6 +81,7 @@ var syscallsTempl = template.Must(template.New("").Parse(
 
 {{range $c := $.FakeCalls}}#define __NR_{{$c.Name}}	{{$c.NR}}
 {{end}}
+#define SYZ_SYSCALLS_HASH	{{printf "0x%08x" $.Hash}}
 
 struct call_t {
 	const char*	name;
@@ -124,7 +124,7 @@ func readConsts(arch string) map[string]uint64 {
 			failf("failed to read const file: %v", err)
 		}
 	}
-	for name, nr := range syzkalls {
+	for name, nr := range PseudoSyscalls {
 		consts["__NR_"+name] = nr
 	}
 	return consts
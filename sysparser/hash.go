@@ -0,0 +1,24 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package sysparser
+
+import (
+	"hash/fnv"
+)
+
+// CallsHash returns hash of the syscall table given names of calls in table order.
+// sysgen embeds the hash into executor (SYZ_SYSCALLS_HASH in executor/syscalls.h)
+// and ipc compares it with the hash of compiled-in descriptions to detect
+// executor binaries built from different descriptions.
+// Only call names and their order are hashed: the hash catches added, removed and
+// reordered calls (which shift call IDs used in the exec format), but not changes
+// to argument types or syscall numbers of existing calls.
+func CallsHash(names []string) uint32 {
+	h := fnv.New32a()
+	for _, name := range names {
+		h.Write([]byte(name))
+		h.Write([]byte{'\n'})
+	}
+	return h.Sum32()
+}
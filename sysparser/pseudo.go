@@ -0,0 +1,18 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package sysparser
+
+// PseudoSyscalls contains fake syscall numbers for syz_* pseudo-syscalls
+// implemented by executor (see execute_syscall in executor/common.h).
+var PseudoSyscalls = map[string]uint64{
+	"syz_test":            1000001,
+	"syz_open_dev":        1000002,
+	"syz_open_pts":        1000003,
+	"syz_fuse_mount":      1000004,
+	"syz_fuseblk_mount":   1000005,
+	"syz_emit_ethernet":   1000006,
+	"syz_kvm_setup_cpu":   1000007,
+	"syz_mount_image":     1000008,
+	"syz_extract_tcp_res": 1000009,
+}
@@ -0,0 +1,69 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/google/syzkaller/hash"
+)
+
+// Compiling a description file against kernel headers takes a while,
+// so results are cached keyed by the file contents, arch and kernel.
+// Kernel is identified by paths and release string, so rebuilding kernel
+// at a different version invalidates the cache, but changing headers
+// in place does not (remove the cache dir in such case).
+
+type cacheEntry struct {
+	Consts     map[string]uint64
+	Undeclared []string
+}
+
+func cacheKey(data []byte) string {
+	if *flagCache == "" {
+		return ""
+	}
+	release, _ := ioutil.ReadFile(filepath.Join(*flagLinuxBld, "include", "generated", "utsrelease.h"))
+	var buf []byte
+	for _, v := range [][]byte{data, []byte(*flagArch), []byte(*flagLinux), []byte(*flagLinuxBld), release} {
+		buf = append(buf, v...)
+		buf = append(buf, 0)
+	}
+	return hash.String(buf)
+}
+
+func loadCache(key string) *cacheEntry {
+	if key == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(filepath.Join(*flagCache, key))
+	if err != nil {
+		return nil
+	}
+	ent := new(cacheEntry)
+	if err := json.Unmarshal(data, ent); err != nil {
+		logf(0, "failed to parse cache entry %v: %v", key, err)
+		return nil
+	}
+	return ent
+}
+
+func saveCache(key string, ent *cacheEntry) {
+	if key == "" {
+		return
+	}
+	data, err := json.Marshal(ent)
+	if err != nil {
+		failf("failed to marshal cache entry: %v", err)
+	}
+	if err := os.MkdirAll(*flagCache, 0750); err != nil {
+		failf("failed to create cache dir: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(*flagCache, key), data, 0640); err != nil {
+		failf("failed to write cache entry: %v", err)
+	}
+}
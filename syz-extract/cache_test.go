@@ -0,0 +1,107 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCacheKey(t *testing.T) {
+	defer func(cache, arch, linux, bld string) {
+		*flagCache, *flagArch, *flagLinux, *flagLinuxBld = cache, arch, linux, bld
+	}(*flagCache, *flagArch, *flagLinux, *flagLinuxBld)
+	dir, err := ioutil.TempDir("", "syz-extract")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	*flagCache = filepath.Join(dir, "cache")
+	*flagLinux = "/linux"
+
+	// The first test gives the reference key, the key of the other tests must match it iff same is set.
+	tests := []struct {
+		data    string
+		arch    string
+		bld     string // kernel build dir
+		release string // kernel release string in the build dir
+		same    bool
+	}{
+		{"foo()\n", "amd64", "bld", "4.13.0", true},
+		{"foo()\n", "amd64", "bld", "4.13.0", true},
+		{"foo()\nbar()\n", "amd64", "bld", "4.13.0", false},
+		{"foo()\n", "arm64", "bld", "4.13.0", false},
+		{"foo()\n", "amd64", "bld2", "4.13.0", false},
+		{"foo()\n", "amd64", "bld", "4.14.0", false},
+		{"foo()\n", "amd64", "bld", "", false},
+	}
+	var ref string
+	for i, test := range tests {
+		*flagArch = test.arch
+		*flagLinuxBld = filepath.Join(dir, test.bld)
+		release := filepath.Join(*flagLinuxBld, "include", "generated", "utsrelease.h")
+		os.Remove(release)
+		if test.release != "" {
+			if err := os.MkdirAll(filepath.Dir(release), 0700); err != nil {
+				t.Fatal(err)
+			}
+			data := []byte("#define UTS_RELEASE \"" + test.release + "\"\n")
+			if err := ioutil.WriteFile(release, data, 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		key := cacheKey([]byte(test.data))
+		if i == 0 {
+			ref = key
+			continue
+		}
+		if same := key == ref; same != test.same {
+			t.Errorf("#%v: key %v, reference key %v, want same %v", i, key, ref, test.same)
+		}
+	}
+	*flagCache = ""
+	if key := cacheKey([]byte("foo()\n")); key != "" {
+		t.Fatalf("got key %v with disabled cache", key)
+	}
+}
+
+func TestCache(t *testing.T) {
+	defer func(cache string) { *flagCache = cache }(*flagCache)
+	dir, err := ioutil.TempDir("", "syz-extract")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	*flagCache = filepath.Join(dir, "cache")
+
+	ent := &cacheEntry{
+		Consts:     map[string]uint64{"FOO_A": 1, "__NR_foo": 2},
+		Undeclared: []string{"FOO_B"},
+	}
+	if got := loadCache("key"); got != nil {
+		t.Fatalf("got entry %+v from empty cache", got)
+	}
+	saveCache("key", ent)
+	if got := loadCache("key"); !reflect.DeepEqual(got, ent) {
+		t.Fatalf("got entry %+v, want %+v", got, ent)
+	}
+	if got := loadCache("other"); got != nil {
+		t.Fatalf("got entry %+v for another key", got)
+	}
+	// Corrupted entries are ignored.
+	if err := ioutil.WriteFile(filepath.Join(*flagCache, "key"), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got := loadCache("key"); got != nil {
+		t.Fatalf("got corrupted entry %+v", got)
+	}
+	// Empty key means that cache is disabled.
+	saveCache("", ent)
+	if got := loadCache(""); got != nil {
+		t.Fatalf("got entry %+v for empty key", got)
+	}
+}
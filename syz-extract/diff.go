@@ -0,0 +1,114 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	. "github.com/google/syzkaller/sysparser"
+)
+
+// diffConsts compares freshly extracted consts with the checked-in const file
+// and prints changed, disappeared and new consts to out. It also prints consts
+// that are referenced by the description, but are undefined for some arch.
+// Returns true if the checked-in file is stale.
+func diffConsts(inname, outname string, desc *Description, consts map[string]uint64, undeclared []string, out io.Writer) bool {
+	old, err := readConsts(outname)
+	if err != nil && !os.IsNotExist(err) {
+		failf("%v", err)
+	}
+	stale := false
+	for _, name := range sortedNames(old) {
+		val, ok := consts[name]
+		if !ok {
+			fmt.Fprintf(out, "%v: %v disappeared (was %v)\n", outname, name, old[name])
+			stale = true
+		} else if val != old[name] {
+			fmt.Fprintf(out, "%v: %v changed: %v -> %v\n", outname, name, old[name], val)
+			stale = true
+		}
+	}
+	for _, name := range sortedNames(consts) {
+		if _, ok := old[name]; !ok {
+			fmt.Fprintf(out, "%v: %v is new (%v)\n", outname, name, consts[name])
+			stale = true
+		}
+	}
+
+	// For the current arch we know precisely what is undefined,
+	// for other archs we use checked-in const files.
+	undefined := make(map[string][]string)
+	for _, name := range undeclared {
+		undefined[name] = append(undefined[name], *flagArch)
+	}
+	for arch := range archs {
+		if arch == *flagArch {
+			continue
+		}
+		archConsts, err := readConsts(strings.TrimSuffix(inname, ".txt") + "_" + arch + ".const")
+		if err != nil {
+			continue
+		}
+		for _, name := range referencedConsts(desc) {
+			if _, ok := archConsts[name]; !ok {
+				undefined[name] = append(undefined[name], arch)
+			}
+		}
+	}
+	var undefinedNames []string
+	for name := range undefined {
+		undefinedNames = append(undefinedNames, name)
+	}
+	sort.Strings(undefinedNames)
+	for _, name := range undefinedNames {
+		sort.Strings(undefined[name])
+		fmt.Fprintf(out, "%v: %v is undefined for %v\n", inname, name, strings.Join(undefined[name], ", "))
+	}
+	return stale
+}
+
+func readConsts(fname string) (map[string]uint64, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	consts := make(map[string]uint64)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := s.Text()
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		eq := strings.IndexByte(line, '=')
+		if eq == -1 {
+			return nil, fmt.Errorf("malformed const file %v: no '=' in '%v'", fname, line)
+		}
+		name := strings.TrimSpace(line[:eq])
+		val, err := strconv.ParseUint(strings.TrimSpace(line[eq+1:]), 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed const file %v: bad value in '%v'", fname, line)
+		}
+		consts[name] = val
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read const file: %v", err)
+	}
+	return consts, nil
+}
+
+func sortedNames(m map[string]uint64) []string {
+	var names []string
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
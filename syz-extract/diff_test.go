@@ -0,0 +1,87 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/google/syzkaller/sysparser"
+)
+
+func TestDiffConsts(t *testing.T) {
+	defer func(arch string) { *flagArch = arch }(*flagArch)
+	*flagArch = "amd64"
+	const description = `
+foo(a flags[foo_flags])
+foo_flags = FOO_A, FOO_B
+`
+	desc := Parse(strings.NewReader(description))
+	tests := []struct {
+		amd64      string // checked-in const file for the current arch, "" if there is no file
+		arm64      string // checked-in const file for another arch
+		consts     map[string]uint64
+		undeclared []string
+		stale      bool
+		out        string
+	}{
+		// Up to date.
+		{
+			amd64:  "# AUTOGENERATED FILE\nFOO_A = 1\nFOO_B = 2\n__NR_foo = 3\n",
+			arm64:  "FOO_A = 1\nFOO_B = 2\n__NR_foo = 4\n",
+			consts: map[string]uint64{"FOO_A": 1, "FOO_B": 2, "__NR_foo": 3},
+		},
+		// Changed, disappeared and new consts.
+		{
+			amd64:  "FOO_A = 1\nFOO_C = 2\n__NR_foo = 3\n",
+			arm64:  "FOO_A = 1\nFOO_B = 2\n__NR_foo = 4\n",
+			consts: map[string]uint64{"FOO_A": 5, "FOO_B": 2, "__NR_foo": 3},
+			stale:  true,
+			out: "foo_amd64.const: FOO_A changed: 1 -> 5\n" +
+				"foo_amd64.const: FOO_C disappeared (was 2)\n" +
+				"foo_amd64.const: FOO_B is new (2)\n",
+		},
+		// No checked-in const file yet.
+		{
+			consts: map[string]uint64{"FOO_A": 1},
+			stale:  true,
+			out:    "foo_amd64.const: FOO_A is new (1)\n",
+		},
+		// Undefined consts are reported for all archs, but don't make the file stale.
+		{
+			amd64:      "FOO_A = 1\n__NR_foo = 3\n",
+			arm64:      "FOO_A = 1\n",
+			consts:     map[string]uint64{"FOO_A": 1, "__NR_foo": 3},
+			undeclared: []string{"FOO_B"},
+			out: "foo.txt: FOO_B is undefined for amd64, arm64\n" +
+				"foo.txt: __NR_foo is undefined for arm64\n",
+		},
+	}
+	for i, test := range tests {
+		dir, err := ioutil.TempDir("", "syz-extract")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		for file, data := range map[string]string{"foo_amd64.const": test.amd64, "foo_arm64.const": test.arm64} {
+			if data == "" {
+				continue
+			}
+			if err := ioutil.WriteFile(filepath.Join(dir, file), []byte(data), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		out := new(bytes.Buffer)
+		stale := diffConsts(filepath.Join(dir, "foo.txt"), filepath.Join(dir, "foo_amd64.const"),
+			desc, test.consts, test.undeclared, out)
+		got := strings.Replace(out.String(), dir+string(filepath.Separator), "", -1)
+		if stale != test.stale || got != test.out {
+			t.Errorf("#%v: got stale %v, output:\n%s\nwant stale %v, output:\n%s", i, stale, got, test.stale, test.out)
+		}
+	}
+}
@@ -10,17 +10,26 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 
+	"github.com/google/syzkaller/sys"
 	. "github.com/google/syzkaller/sysparser"
 )
 
 var (
-	flagLinux    = flag.String("linux", "", "path to linux kernel source checkout")
-	flagLinuxBld = flag.String("linuxbld", "", "path to linux kernel build directory")
-	flagArch     = flag.String("arch", "", "arch to generate")
-	flagV        = flag.Int("v", 0, "verbosity")
+	flagLinux     = flag.String("linux", "", "path to linux kernel source checkout")
+	flagLinuxBld  = flag.String("linuxbld", "", "path to linux kernel build directory")
+	flagArch      = flag.String("arch", "", "arch to generate")
+	flagV         = flag.Int("v", 0, "verbosity")
+	flagDiff      = flag.Bool("diff", false, "compare extracted consts with existing const files instead of writing them")
+	flagCache     = flag.String("cache", "", "directory to cache extracted consts (keyed by input file contents and kernel)")
+	flagLayout    = flag.Bool("layout", false, "verify struct layouts against kernel headers instead of extracting consts")
+	flagLayoutSrc = flag.String("layoutsrc", "", "with -layout, write generated C layout checks to this file (%s is replaced with the input file name)")
+	flagSkeleton  = flag.String("skeleton", "", "generate skeleton descriptions for ioctls in this kernel header (e.g. include/uapi/linux/foo.h) and print them to stdout")
+	flagDev       = flag.String("dev", "", "with -skeleton, device file to open (defaults to /dev/<header name>)")
+	flagName      = flag.String("name", "", "with -skeleton, name used for the resource and openat (defaults to header name)")
 )
 
 type Arch struct {
@@ -51,26 +60,52 @@ func main() {
 	if archs[*flagArch] == nil {
 		failf("unknown arch %v", *flagArch)
 	}
-	if len(flag.Args()) != 1 {
-		failf("usage: syz-extract -linux=/linux/checkout -arch=arch sys/input_file.txt")
+	if *flagSkeleton != "" {
+		generateSkeleton(archs[*flagArch], *flagSkeleton, *flagDev, *flagName, os.Stdout)
+		return
 	}
-
-	inname := flag.Args()[0]
-	outname := strings.TrimSuffix(inname, ".txt") + "_" + *flagArch + ".const"
-
-	inf, err := os.Open(inname)
-	if err != nil {
-		failf("failed to open input file: %v", err)
+	if len(flag.Args()) == 0 {
+		failf("usage: syz-extract -linux=/linux/checkout -arch=arch sys/input_file.txt+")
 	}
-	defer inf.Close()
 
-	desc := Parse(inf)
-	consts := compileConsts(archs[*flagArch], desc)
+	var layouts map[string]sys.Type
+	if *flagLayout {
+		layouts = loadLayouts(flag.Args())
+	}
+	stale := false
+	for _, inname := range flag.Args() {
+		outname := strings.TrimSuffix(inname, ".txt") + "_" + *flagArch + ".const"
+		data, err := ioutil.ReadFile(inname)
+		if err != nil {
+			failf("failed to read input file: %v", err)
+		}
+		desc := Parse(bytes.NewReader(data))
+		if *flagLayout {
+			srcFile := ""
+			if *flagLayoutSrc != "" {
+				srcFile = strings.Replace(*flagLayoutSrc, "%s", strings.TrimSuffix(filepath.Base(inname), ".txt"), -1)
+			}
+			if checkLayout(inname, archs[*flagArch], desc, layouts, srcFile, os.Stdout) {
+				stale = true
+			}
+			continue
+		}
+		consts, undeclared := compileConsts(archs[*flagArch], data, desc)
 
-	out := new(bytes.Buffer)
-	generateConsts(*flagArch, consts, out)
-	if err := ioutil.WriteFile(outname, out.Bytes(), 0660); err != nil {
-		failf("failed to write output file: %v", err)
+		if *flagDiff {
+			if diffConsts(inname, outname, desc, consts, undeclared, os.Stdout) {
+				stale = true
+			}
+			continue
+		}
+		out := new(bytes.Buffer)
+		generateConsts(*flagArch, consts, out)
+		if err := ioutil.WriteFile(outname, out.Bytes(), 0660); err != nil {
+			failf("failed to write output file: %v", err)
+		}
+	}
+	if stale {
+		os.Exit(1)
 	}
 }
 
@@ -87,7 +122,26 @@ func generateConsts(arch string, consts map[string]uint64, out io.Writer) {
 	}
 }
 
-func compileConsts(arch *Arch, desc *Description) map[string]uint64 {
+func compileConsts(arch *Arch, data []byte, desc *Description) (map[string]uint64, []string) {
+	valArr := referencedConsts(desc)
+	if len(valArr) == 0 {
+		return nil, nil
+	}
+	key := cacheKey(data)
+	if ent := loadCache(key); ent != nil {
+		logf(1, "using cached consts for %v", key)
+		return ent.Consts, ent.Undeclared
+	}
+	consts, undeclared, err := fetchValues(arch.KernelHeaderArch, valArr, append(desc.Includes, arch.KernelInclude), desc.Defines, arch.CFlags)
+	if err != nil {
+		failf("%v", err)
+	}
+	saveCache(key, &cacheEntry{consts, undeclared})
+	return consts, undeclared
+}
+
+// referencedConsts returns sorted list of identifiers that desc needs values for.
+func referencedConsts(desc *Description) []string {
 	vals := make(map[string]bool)
 	for _, fvals := range desc.Flags {
 		for _, v := range fvals {
@@ -117,15 +171,8 @@ func compileConsts(arch *Arch, desc *Description) map[string]uint64 {
 		}
 		valArr = append(valArr, v)
 	}
-	if len(valArr) == 0 {
-		return nil
-	}
-
-	consts, err := fetchValues(arch.KernelHeaderArch, valArr, append(desc.Includes, arch.KernelInclude), desc.Defines, arch.CFlags)
-	if err != nil {
-		failf("%v", err)
-	}
-	return consts
+	sort.Strings(valArr)
+	return valArr
 }
 
 func isIdentifier(s string) bool {
@@ -9,6 +9,7 @@ import (
 	"os"
 	"os/exec"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -16,10 +17,11 @@ import (
 // fetchValues converts literal constants (e.g. O_APPEND) or any other C expressions
 // into their respective numeric values. It does so by builting and executing a C program
 // that prints values of the provided expressions.
-func fetchValues(arch string, vals []string, includes []string, defines map[string]string, cflags []string) (map[string]uint64, error) {
+// It also returns the list of values that are not declared in kernel headers.
+func fetchValues(arch string, vals []string, includes []string, defines map[string]string, cflags []string) (map[string]uint64, []string, error) {
 	bin, out, err := runCompiler(arch, nil, includes, nil, cflags, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to run gcc: %v\n%v", err, string(out))
+		return nil, nil, fmt.Errorf("failed to run gcc: %v\n%v", err, string(out))
 	}
 	os.Remove(bin)
 
@@ -32,8 +34,8 @@ func fetchValues(arch string, vals []string, includes []string, defines map[stri
 	bin, out, err = runCompiler(arch, vals, includes, defines, cflags, undeclared)
 	if err != nil {
 		for _, errMsg := range []string{
-			"error: ‘([a-zA-Z0-9_]+)’ undeclared",
-			"note: in expansion of macro ‘([a-zA-Z0-9_]+)’",
+			"error: [‘']([a-zA-Z0-9_]+)[’'] undeclared",
+			"note: in expansion of macro [‘']([a-zA-Z0-9_]+)[’']",
 		} {
 			re := regexp.MustCompile(errMsg)
 			matches := re.FindAllSubmatch(out, -1)
@@ -47,14 +49,14 @@ func fetchValues(arch string, vals []string, includes []string, defines map[stri
 		}
 		bin, out, err = runCompiler(arch, vals, includes, defines, cflags, undeclared)
 		if err != nil {
-			return nil, fmt.Errorf("failed to run gcc: %v\n%v", err, string(out))
+			return nil, nil, fmt.Errorf("failed to run gcc: %v\n%v", err, string(out))
 		}
 	}
 	defer os.Remove(bin)
 
 	out, err = exec.Command(bin).CombinedOutput()
 	if err != nil {
-		return nil, fmt.Errorf("failed to run flags binary: %v\n%v", err, string(out))
+		return nil, nil, fmt.Errorf("failed to run flags binary: %v\n%v", err, string(out))
 	}
 
 	flagVals := strings.Split(string(out), " ")
@@ -76,7 +78,12 @@ func fetchValues(arch string, vals []string, includes []string, defines map[stri
 		}
 		res[name] = n
 	}
-	return res, nil
+	var undeclaredArr []string
+	for name := range undeclared {
+		undeclaredArr = append(undeclaredArr, name)
+	}
+	sort.Strings(undeclaredArr)
+	return res, undeclaredArr, nil
 }
 
 func runCompiler(arch string, vals []string, includes []string, defines map[string]string, cflags []string, undeclared map[string]bool) (bin string, out []byte, err error) {
@@ -101,6 +108,11 @@ func runCompiler(arch string, vals []string, includes []string, defines map[stri
 	src := strings.Replace(fetchSrc, "[[INCLUDES]]", includeText, 1)
 	src = strings.Replace(src, "[[DEFAULTS]]", definesText, 1)
 	src = strings.Replace(src, "[[VALS]]", valsText, 1)
+	return compileSrc(arch, src, cflags)
+}
+
+// compileSrc compiles C source src against kernel headers into a temp binary.
+func compileSrc(arch, src string, cflags []string) (bin string, out []byte, err error) {
 	binFile, err := ioutil.TempFile("", "")
 	if err != nil {
 		return "", nil, fmt.Errorf("failed to create temp file: %v", err)
@@ -0,0 +1,224 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/syzkaller/sys"
+	. "github.com/google/syzkaller/sysparser"
+)
+
+// layoutCheck is a single sizeof/offsetof assertion about a kernel type.
+type layoutCheck struct {
+	typ   string // "struct foo" or "union foo"
+	field string // empty for sizeof checks
+	val   uintptr
+}
+
+func (c *layoutCheck) expr() string {
+	if c.field == "" {
+		return fmt.Sprintf("sizeof(%v)", c.typ)
+	}
+	return fmt.Sprintf("__builtin_offsetof(%v, %v)", c.typ, c.field)
+}
+
+func (c *layoutCheck) String() string {
+	if c.field == "" {
+		return fmt.Sprintf("%v: size", c.typ)
+	}
+	return fmt.Sprintf("%v: field %v: offset", c.typ, c.field)
+}
+
+// loadLayouts loads descriptions from directories of the input files
+// so that layout is computed from the current descriptions rather than
+// from the compiled-in ones.
+func loadLayouts(innames []string) map[string]sys.Type {
+	if *flagArch != runtime.GOARCH {
+		failf("layout checking is supported only for the host arch (%v)", runtime.GOARCH)
+	}
+	dirs := make(map[string]bool)
+	var paths []string
+	for _, inname := range innames {
+		dir := filepath.Dir(inname)
+		if !dirs[dir] {
+			dirs[dir] = true
+			paths = append(paths, dir)
+		}
+	}
+	files, err := sys.ReadDescriptions(paths)
+	if err != nil {
+		failf("%v", err)
+	}
+	if err := sys.LoadDescriptions(files); err != nil {
+		failf("%v", err)
+	}
+	types := make(map[string]sys.Type)
+	for _, t := range sys.Structs {
+		// All instances of a struct (with different dirs) have the same layout.
+		types[t.Name()] = t
+	}
+	return types
+}
+
+// layoutChecks returns checks for all structs described in desc.
+func layoutChecks(desc *Description, types map[string]sys.Type) []*layoutCheck {
+	var names []string
+	for name := range desc.Structs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var checks []*layoutCheck
+	for _, name := range names {
+		switch t := types[name].(type) {
+		case *sys.StructType:
+			typ := "struct " + name
+			off := uintptr(0)
+			for _, f := range t.Fields {
+				if sys.IsPad(f) {
+					off += f.Size()
+					continue
+				}
+				if f.BitfieldLength() != 0 {
+					// Can't take offsetof of a bitfield.
+					if f.BitfieldLast() {
+						off += f.Size()
+					}
+					continue
+				}
+				checks = append(checks, &layoutCheck{typ, f.FieldName(), off})
+				if f.Varlen() {
+					break
+				}
+				off += f.Size()
+			}
+			if !t.Varlen() {
+				checks = append(checks, &layoutCheck{typ, "", t.Size()})
+			}
+		case *sys.UnionType:
+			if !t.Varlen() {
+				checks = append(checks, &layoutCheck{"union " + name, "", t.Size()})
+			}
+		default:
+			logf(1, "struct %v is not used by any syscall, skipping", name)
+		}
+	}
+	return checks
+}
+
+// generateLayoutSrc generates a C file with a static assert for every check.
+// lines maps source line numbers to checks.
+func generateLayoutSrc(desc *Description, arch *Arch, checks []*layoutCheck) (src string, lines map[int]*layoutCheck) {
+	buf := new(bytes.Buffer)
+	for _, inc := range append(desc.Includes, arch.KernelInclude) {
+		fmt.Fprintf(buf, "#include <%v>\n", inc)
+	}
+	for k, v := range desc.Defines {
+		fmt.Fprintf(buf, "#ifndef %v\n#define %v %v\n#endif\n", k, k, v)
+	}
+	line := strings.Count(buf.String(), "\n") + 1
+	lines = make(map[int]*layoutCheck)
+	for _, c := range checks {
+		fmt.Fprintf(buf, "_Static_assert(%v == %v, \"%v %v\");\n", c.expr(), c.val, c, c.val)
+		lines[line] = c
+		line++
+	}
+	buf.WriteString("int main() { return 0; }\n")
+	return buf.String(), lines
+}
+
+var layoutErrorRe = regexp.MustCompile(`(?m)^<stdin>:([0-9]+):[0-9]+: error: (.*)$`)
+
+// checkLayout compiles static asserts for structs in desc against kernel headers
+// and reports mismatching fields. Checks that refer to types or fields that
+// don't exist in kernel are silently dropped. Mismatches are printed to out.
+// Returns true if any mismatches are found.
+func checkLayout(inname string, arch *Arch, desc *Description, types map[string]sys.Type, srcFile string, out io.Writer) bool {
+	checks := layoutChecks(desc, types)
+	if len(checks) == 0 {
+		return false
+	}
+	var src string
+	var failed []*layoutCheck
+	for {
+		var lines map[int]*layoutCheck
+		src, lines = generateLayoutSrc(desc, arch, checks)
+		bin, output, err := compileSrc(arch.KernelHeaderArch, src, arch.CFlags)
+		if err == nil {
+			os.Remove(bin)
+			break
+		}
+		dropped := make(map[*layoutCheck]bool)
+		failedSet := make(map[*layoutCheck]bool)
+		for _, match := range layoutErrorRe.FindAllSubmatch(output, -1) {
+			line, _ := strconv.Atoi(string(match[1]))
+			c := lines[line]
+			if c == nil {
+				failf("failed to compile layout checks for %v: %v\n%s", inname, err, output)
+			}
+			if strings.Contains(string(match[2]), "static assertion failed") {
+				failedSet[c] = true
+			} else {
+				if !dropped[c] {
+					logf(1, "%v: no matching kernel type or field, skipping", c.expr())
+				}
+				dropped[c] = true
+			}
+		}
+		if len(dropped) == 0 {
+			if len(failedSet) == 0 {
+				failf("failed to compile layout checks for %v: %v\n%s", inname, err, output)
+			}
+			for _, c := range checks {
+				if failedSet[c] {
+					failed = append(failed, c)
+				}
+			}
+			break
+		}
+		// Assertion failures are re-detected on the next iteration.
+		var rest []*layoutCheck
+		for _, c := range checks {
+			if !dropped[c] {
+				rest = append(rest, c)
+			}
+		}
+		checks = rest
+		if len(checks) == 0 {
+			src = ""
+			break
+		}
+	}
+	if srcFile != "" && src != "" {
+		if err := ioutil.WriteFile(srcFile, []byte(src), 0640); err != nil {
+			failf("failed to write layout checks: %v", err)
+		}
+	}
+	if len(failed) == 0 {
+		logf(0, "%v: %v layout checks passed", inname, len(checks))
+		return false
+	}
+	var exprs []string
+	for _, c := range failed {
+		exprs = append(exprs, c.expr())
+	}
+	vals, _, err := fetchValues(arch.KernelHeaderArch, exprs, append(desc.Includes, arch.KernelInclude), desc.Defines, arch.CFlags)
+	if err != nil {
+		failf("%v", err)
+	}
+	for _, c := range failed {
+		fmt.Fprintf(out, "%v: %v %v, kernel %v\n", inname, c, c.val, vals[c.expr()])
+	}
+	return true
+}
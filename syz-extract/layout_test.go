@@ -0,0 +1,217 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/google/syzkaller/sys"
+	. "github.com/google/syzkaller/sysparser"
+)
+
+const layoutDesc = `
+include <linux/foo.h>
+
+foo(a ptr[in, foo_plain], b ptr[in, foo_packed], c ptr[in, foo_bits], d ptr[in, foo_var], e ptr[in, foo_union])
+
+foo_plain {
+	a	int8
+	b	int32
+	c	int16
+}
+
+foo_packed {
+	a	int8
+	b	int32
+} [packed]
+
+foo_bits {
+	a	int8
+	b	int16:4
+	c	int16:12
+	d	int32
+}
+
+foo_var {
+	a	int32
+	b	array[int8]
+}
+
+foo_union [
+	a	int8
+	b	int64
+]
+
+foo_unused {
+	a	int8
+}
+`
+
+// loadTestLayouts loads layoutDesc and returns its parsed description and types.
+func loadTestLayouts(t *testing.T) (*Description, map[string]sys.Type) {
+	if archs[runtime.GOARCH] == nil {
+		t.Skipf("unsupported host arch %v", runtime.GOARCH)
+	}
+	defer func(arch string) { *flagArch = arch }(*flagArch)
+	*flagArch = runtime.GOARCH
+	dir, err := ioutil.TempDir("", "syz-extract")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"foo.txt":                          layoutDesc,
+		"foo_" + runtime.GOARCH + ".const": "__NR_foo = 1000\n",
+	}
+	for file, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, file), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	types := loadLayouts([]string{filepath.Join(dir, "foo.txt")})
+	return Parse(strings.NewReader(layoutDesc)), types
+}
+
+func TestLayoutChecks(t *testing.T) {
+	desc, types := loadTestLayouts(t)
+	tests := []struct {
+		name   string
+		checks []string
+	}{
+		{"foo_plain", []string{
+			"__builtin_offsetof(struct foo_plain, a) == 0",
+			"__builtin_offsetof(struct foo_plain, b) == 4",
+			"__builtin_offsetof(struct foo_plain, c) == 8",
+			"sizeof(struct foo_plain) == 12",
+		}},
+		{"foo_packed", []string{
+			"__builtin_offsetof(struct foo_packed, a) == 0",
+			"__builtin_offsetof(struct foo_packed, b) == 1",
+			"sizeof(struct foo_packed) == 5",
+		}},
+		// Offsets of bitfields can't be checked.
+		{"foo_bits", []string{
+			"__builtin_offsetof(struct foo_bits, a) == 0",
+			"__builtin_offsetof(struct foo_bits, d) == 4",
+			"sizeof(struct foo_bits) == 8",
+		}},
+		// Size of varlen structs is not known.
+		{"foo_var", []string{
+			"__builtin_offsetof(struct foo_var, a) == 0",
+			"__builtin_offsetof(struct foo_var, b) == 4",
+		}},
+		{"foo_union", []string{
+			"sizeof(union foo_union) == 8",
+		}},
+		// Not loaded.
+		{"foo_missing", nil},
+	}
+	for _, test := range tests {
+		single := &Description{Structs: map[string]Struct{test.name: desc.Structs[test.name]}}
+		var got []string
+		for _, c := range layoutChecks(single, types) {
+			got = append(got, fmt.Sprintf("%v == %v", c.expr(), c.val))
+		}
+		if strings.Join(got, "\n") != strings.Join(test.checks, "\n") {
+			t.Errorf("%v: got checks:\n%v\nwant:\n%v", test.name, strings.Join(got, "\n"), strings.Join(test.checks, "\n"))
+		}
+	}
+}
+
+func TestGenerateLayoutSrc(t *testing.T) {
+	desc := &Description{Includes: []string{"linux/foo.h"}}
+	checks := []*layoutCheck{
+		{"struct foo", "a", 0},
+		{"struct foo", "", 16},
+		{"union bar", "", 8},
+	}
+	src, lines := generateLayoutSrc(desc, archs["amd64"], checks)
+	want := `#include <linux/foo.h>
+#include <asm/unistd.h>
+_Static_assert(__builtin_offsetof(struct foo, a) == 0, "struct foo: field a: offset 0");
+_Static_assert(sizeof(struct foo) == 16, "struct foo: size 16");
+_Static_assert(sizeof(union bar) == 8, "union bar: size 8");
+int main() { return 0; }
+`
+	if src != want {
+		t.Fatalf("got source:\n%v\nwant:\n%v", src, want)
+	}
+	if len(lines) != len(checks) {
+		t.Fatalf("got %v lines, want %v", len(lines), len(checks))
+	}
+	for i, c := range checks {
+		if lines[i+3] != c {
+			t.Fatalf("line %v: got check %v, want %v", i+3, lines[i+3], c)
+		}
+	}
+}
+
+func TestCheckLayout(t *testing.T) {
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc is not available")
+	}
+	defer func(linux, bld string) { *flagLinux, *flagLinuxBld = linux, bld }(*flagLinux, *flagLinuxBld)
+	desc, types := loadTestLayouts(t)
+	const plain = "struct foo_plain { char a; int b; short c; };\n"
+	const others = `
+struct foo_packed { char a; int b; } __attribute__((packed));
+struct foo_bits { char a; short b:4; short c:12; int d; };
+struct foo_var { int a; char b[]; };
+union foo_union { char a; long long b; };
+struct foo_unused { char a; };
+`
+	tests := []struct {
+		header   string
+		mismatch bool
+		out      string
+	}{
+		// Matching layouts.
+		{plain + others, false, ""},
+		{"struct foo_plain { char a; short b; short c; };\n" + others, true,
+			"foo.txt: struct foo_plain: field b: offset 4, kernel 2\n" +
+				"foo.txt: struct foo_plain: field c: offset 8, kernel 4\n" +
+				"foo.txt: struct foo_plain: size 12, kernel 6\n"},
+		// Types and fields missing in kernel are skipped.
+		{"struct foo_plain { char a; int b; int d; };\n", false, ""},
+	}
+	for i, test := range tests {
+		dir := makeTestKernel(t, map[string]string{"include/uapi/linux/foo.h": test.header})
+		defer os.RemoveAll(dir)
+		out := new(bytes.Buffer)
+		mismatch := checkLayout("foo.txt", archs[runtime.GOARCH], desc, types, "", out)
+		if mismatch != test.mismatch || out.String() != test.out {
+			t.Errorf("#%v: got mismatch %v, output:\n%s\nwant mismatch %v, output:\n%s",
+				i, mismatch, out.String(), test.mismatch, test.out)
+		}
+	}
+}
+
+// makeTestKernel creates a fake kernel checkout with the given files
+// and points -linux and -linuxbld flags to it.
+func makeTestKernel(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "syz-extract-linux")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files["include/linux/kconfig.h"] = ""
+	for file, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	*flagLinux, *flagLinuxBld = dir, dir
+	return dir
+}
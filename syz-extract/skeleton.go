@@ -0,0 +1,414 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// skelIoctl is an ioctl command found in a header.
+type skelIoctl struct {
+	name string
+	dir  string // in/out/inout, empty for _IO
+	typ  string // C type of the argument
+}
+
+// skelStruct is a struct or union definition found in a header.
+type skelStruct struct {
+	name    string
+	union   bool
+	packed  bool
+	fields  []*skelField
+	invalid bool // couldn't parse fields, emit as opaque byte array
+}
+
+func (s *skelStruct) ctype() string {
+	if s.union {
+		return "union " + s.name
+	}
+	return "struct " + s.name
+}
+
+// skelField is a single struct field declaration.
+type skelField struct {
+	name     string
+	typ      string // C type without array dimensions, pointers and bitfield width
+	ptr      bool
+	dims     int
+	bitfield string
+}
+
+var (
+	skelCommentRe   = regexp.MustCompile(`(?s)/\*.*?\*/|//[^\n]*`)
+	skelIoctlRe     = regexp.MustCompile(`(?m)^[ \t]*#[ \t]*define[ \t]+([A-Za-z_][A-Za-z0-9_]*)[ \t]+_IO(R|W|WR)?[ \t]*\(`)
+	skelStructRe    = regexp.MustCompile(`\b(struct|union)[ \t\n]+([A-Za-z_][A-Za-z0-9_]*)[ \t\n]*\{`)
+	skelAttributeRe = regexp.MustCompile(`__attribute__[ \t\n]*\(\((?:[^()]|\([^()]*\))*\)\)|__aligned_u64|__user|__packed`)
+	skelIdentRe     = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*`)
+)
+
+// generateSkeleton scans header (a path relative to the kernel checkout) for
+// ioctl commands and emits skeleton descriptions for them and their argument types.
+func generateSkeleton(arch *Arch, header, dev, name string, out io.Writer) {
+	header = strings.TrimPrefix(strings.TrimPrefix(header, *flagLinux), "/")
+	data, err := ioutil.ReadFile(filepath.Join(*flagLinux, header))
+	if err != nil {
+		failf("failed to read header: %v", err)
+	}
+	text := strings.Replace(string(data), "\\\n", " ", -1)
+	text = skelCommentRe.ReplaceAllString(text, " ")
+	ioctls := parseIoctls(text)
+	if len(ioctls) == 0 {
+		failf("no ioctl commands found in %v", header)
+	}
+	structs := parseStructs(text)
+	include := headerInclude(header)
+	includes := []string{include, arch.KernelInclude}
+
+	// Figure out which types are referenced and query their sizes from the compiler.
+	used := make(map[string]bool)
+	var order []*skelStruct
+	var use func(typ string)
+	use = func(typ string) {
+		s := structs[typ]
+		if s == nil || used[typ] {
+			return
+		}
+		used[typ] = true
+		order = append(order, s)
+		for _, f := range s.fields {
+			if !f.ptr {
+				use(f.typ)
+			}
+		}
+	}
+	for _, ioc := range ioctls {
+		use(ioc.typ)
+	}
+	var exprs []string
+	for _, ioc := range ioctls {
+		if ioc.typ != "" {
+			exprs = append(exprs, fmt.Sprintf("sizeof(%v)", ioc.typ))
+		}
+	}
+	for _, s := range order {
+		exprs = append(exprs, fmt.Sprintf("sizeof(%v)", s.ctype()))
+		for _, f := range s.fields {
+			exprs = append(exprs, f.exprs(s)...)
+		}
+	}
+	valid := validExprs(arch, includes, exprs)
+	var validArr []string
+	for _, e := range exprs {
+		if valid[e] {
+			validArr = append(validArr, e)
+		}
+	}
+	sizes, _, err := fetchValues(arch.KernelHeaderArch, validArr, includes, nil, arch.CFlags)
+	if err != nil {
+		failf("%v", err)
+	}
+	for _, s := range order {
+		for _, f := range s.fields {
+			for _, e := range f.exprs(s) {
+				if !valid[e] {
+					s.invalid = true
+				}
+			}
+		}
+	}
+
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(header), ".h")
+	}
+	if dev == "" {
+		dev = "/dev/" + name
+	}
+	fd := "fd_" + name
+	fmt.Fprintf(out, "# AUTOGENERATED from %v by syz-extract -skeleton.\n", header)
+	fmt.Fprintf(out, "# This is only a starting point: refine types, flags, lengths and directions by hand.\n\n")
+	fmt.Fprintf(out, "include <%v>\n\n", include)
+	fmt.Fprintf(out, "resource %v[fd]\n\n", fd)
+	fmt.Fprintf(out, "openat$%v(fd const[AT_FDCWD], file ptr[in, string[\"%v\"]], flags flags[open_flags], mode const[0]) %v\n\n", name, dev, fd)
+	for _, ioc := range ioctls {
+		if ioc.typ == "" {
+			fmt.Fprintf(out, "ioctl$%v(fd %v, cmd const[%v], arg intptr)\n", ioc.name, fd, ioc.name)
+			continue
+		}
+		typ := "array[int8]"
+		if s := structs[ioc.typ]; s != nil {
+			typ = s.name
+		} else if size, ok := sizes[fmt.Sprintf("sizeof(%v)", ioc.typ)]; ok {
+			typ = skelIntType(size)
+		}
+		fmt.Fprintf(out, "ioctl$%v(fd %v, cmd const[%v], arg ptr[%v, %v])\n", ioc.name, fd, ioc.name, ioc.dir, typ)
+	}
+	for _, s := range order {
+		open, close := "{", "}"
+		if s.union {
+			open, close = "[", "]"
+		}
+		fmt.Fprintf(out, "\n%v %v\n", s.name, open)
+		size, ok := sizes[fmt.Sprintf("sizeof(%v)", s.ctype())]
+		switch {
+		case !ok:
+			logf(0, "can't determine size of %v, skipping", s.ctype())
+			fmt.Fprintf(out, "\tdata\tarray[int8]\n")
+		case s.invalid || len(s.fields) == 0:
+			logf(0, "failed to parse fields of %v, describing as opaque buffer", s.ctype())
+			fmt.Fprintf(out, "\tdata\tarray[int8, %v]\n", size)
+		default:
+			for _, f := range s.fields {
+				fmt.Fprintf(out, "\t%v\t%v\n", f.name, f.descType(s, structs, sizes))
+			}
+		}
+		fmt.Fprintf(out, "%v", close)
+		if s.packed && !s.union {
+			fmt.Fprintf(out, " [packed]")
+		}
+		fmt.Fprintf(out, "\n")
+	}
+}
+
+// exprs returns C expressions whose values are needed to describe the field.
+func (f *skelField) exprs(s *skelStruct) []string {
+	if f.bitfield != "" {
+		return []string{fmt.Sprintf("sizeof(%v)", f.typ)}
+	}
+	member := fmt.Sprintf("((%v*)0)->%v", s.ctype(), f.name)
+	res := []string{fmt.Sprintf("sizeof(%v)", member)}
+	if f.dims != 0 {
+		res = append(res, fmt.Sprintf("sizeof(%v%v)", member, strings.Repeat("[0]", f.dims)))
+	}
+	return res
+}
+
+func (f *skelField) descType(s *skelStruct, structs map[string]*skelStruct, sizes map[string]uint64) string {
+	if f.bitfield != "" {
+		return fmt.Sprintf("%v:%v", skelIntType(sizes[f.exprs(s)[0]]), f.bitfield)
+	}
+	exprs := f.exprs(s)
+	size := sizes[exprs[0]]
+	elem := size
+	if f.dims != 0 {
+		elem = sizes[exprs[1]]
+	}
+	typ := skelIntType(elem)
+	if f.ptr {
+		typ = "ptr[in, array[int8]]"
+	} else if inner := structs[f.typ]; inner != nil {
+		typ = inner.name
+	}
+	if f.dims == 0 {
+		return typ
+	}
+	if elem == 0 {
+		return fmt.Sprintf("array[%v]", typ)
+	}
+	return fmt.Sprintf("array[%v, %v]", typ, size/elem)
+}
+
+func skelIntType(size uint64) string {
+	switch size {
+	case 1, 2, 4, 8:
+		return fmt.Sprintf("int%v", size*8)
+	default:
+		return fmt.Sprintf("array[int8, %v]", size)
+	}
+}
+
+// headerInclude converts kernel source path of a header to the form used in include directives.
+func headerInclude(header string) string {
+	header = regexp.MustCompile(`^arch/[^/]+/`).ReplaceAllString(header, "")
+	header = strings.TrimPrefix(header, "include/")
+	header = strings.TrimPrefix(header, "uapi/")
+	return header
+}
+
+func parseIoctls(text string) []*skelIoctl {
+	var ioctls []*skelIoctl
+	for _, m := range skelIoctlRe.FindAllStringSubmatchIndex(text, -1) {
+		args := splitArgs(text[m[1]:])
+		ioc := &skelIoctl{name: text[m[2]:m[3]]}
+		if m[4] != -1 {
+			if len(args) != 3 {
+				logf(0, "can't parse arguments of %v, skipping", ioc.name)
+				continue
+			}
+			ioc.dir = map[string]string{"R": "out", "W": "in", "WR": "inout"}[text[m[4]:m[5]]]
+			ioc.typ = normalizeSpace(args[2])
+		}
+		ioctls = append(ioctls, ioc)
+	}
+	return ioctls
+}
+
+// splitArgs splits text starting right after an opening paren into top-level arguments.
+func splitArgs(text string) []string {
+	var args []string
+	depth, start := 0, 0
+	for i, c := range text {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				return append(args, text[start:i])
+			}
+			depth--
+		case ',':
+			if depth == 0 {
+				args = append(args, text[start:i])
+				start = i + 1
+			}
+		case '\n':
+			return nil
+		}
+	}
+	return nil
+}
+
+// parseStructs returns struct and union definitions keyed by C type ("struct foo").
+func parseStructs(text string) map[string]*skelStruct {
+	structs := make(map[string]*skelStruct)
+	for _, m := range skelStructRe.FindAllStringSubmatchIndex(text, -1) {
+		s := &skelStruct{
+			name:  text[m[4]:m[5]],
+			union: text[m[2]:m[3]] == "union",
+		}
+		depth, end := 1, -1
+		for i := m[1]; i < len(text) && end == -1; i++ {
+			switch text[i] {
+			case '{':
+				depth++
+			case '}':
+				depth--
+				if depth == 0 {
+					end = i
+				}
+			}
+		}
+		if end == -1 {
+			continue
+		}
+		body := text[m[1]:end]
+		if tail := text[end:]; strings.Contains(tail[:strings.IndexByte(tail+";", ';')], "packed") {
+			s.packed = true
+		}
+		s.fields, s.invalid = parseFields(body)
+		structs[s.ctype()] = s
+	}
+	return structs
+}
+
+func parseFields(body string) ([]*skelField, bool) {
+	if strings.ContainsAny(body, "{}#") {
+		// Nested anonymous structs/unions and preprocessor conditionals.
+		return nil, true
+	}
+	var fields []*skelField
+	for _, decl := range strings.Split(body, ";") {
+		decl = normalizeSpace(skelAttributeRe.ReplaceAllStringFunc(decl, func(s string) string {
+			if s == "__aligned_u64" {
+				return "__u64"
+			}
+			return " "
+		}))
+		if decl == "" {
+			continue
+		}
+		// Split "int a, *b, c[2]" into the common type and declarators.
+		parts := strings.Split(decl, ",")
+		head := parts[0]
+		if i := strings.IndexAny(head, ":["); i != -1 {
+			head = head[:i]
+		}
+		ids := skelIdentRe.FindAllStringIndex(head, -1)
+		if len(ids) < 2 {
+			return nil, true
+		}
+		typ := strings.TrimSpace(strings.TrimRight(head[:ids[len(ids)-1][0]], " *"))
+		parts[0] = parts[0][len(typ):]
+		for _, d := range parts {
+			f, ok := parseDeclarator(typ, d)
+			if !ok {
+				return nil, true
+			}
+			fields = append(fields, f)
+		}
+	}
+	return fields, false
+}
+
+func parseDeclarator(typ, d string) (*skelField, bool) {
+	f := &skelField{typ: typ}
+	d = strings.TrimSpace(d)
+	for strings.HasPrefix(d, "*") {
+		f.ptr = true
+		d = strings.TrimSpace(d[1:])
+	}
+	if i := strings.IndexByte(d, ':'); i != -1 {
+		f.bitfield = strings.TrimSpace(d[i+1:])
+		if _, err := strconv.ParseUint(f.bitfield, 0, 64); err != nil {
+			return nil, false
+		}
+		d = strings.TrimSpace(d[:i])
+	}
+	f.dims = strings.Count(d, "[")
+	if i := strings.IndexByte(d, '['); i != -1 {
+		d = strings.TrimSpace(d[:i])
+	}
+	if !isIdentifier(d) || d == "" {
+		return nil, false
+	}
+	f.name = d
+	return f, true
+}
+
+func normalizeSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// validExprs compiles every expression on a separate line and returns
+// the ones that the compiler accepts.
+func validExprs(arch *Arch, includes, exprs []string) map[string]bool {
+	buf := new(bytes.Buffer)
+	for _, inc := range includes {
+		fmt.Fprintf(buf, "#include <%v>\n", inc)
+	}
+	line := strings.Count(buf.String(), "\n") + 1
+	lines := make(map[int]string)
+	for i, e := range exprs {
+		fmt.Fprintf(buf, "unsigned long skel_val%v = %v;\n", i, e)
+		lines[line] = e
+		line++
+	}
+	buf.WriteString("int main() { return 0; }\n")
+	valid := make(map[string]bool)
+	for _, e := range exprs {
+		valid[e] = true
+	}
+	bin, out, err := compileSrc(arch.KernelHeaderArch, buf.String(), arch.CFlags)
+	if err == nil {
+		os.Remove(bin)
+		return valid
+	}
+	for _, match := range layoutErrorRe.FindAllSubmatch(out, -1) {
+		line, _ := strconv.Atoi(string(match[1]))
+		e, ok := lines[line]
+		if !ok {
+			failf("failed to compile header: %v\n%s", err, out)
+		}
+		logf(1, "can't compute %v: %s", e, match[2])
+		valid[e] = false
+	}
+	return valid
+}
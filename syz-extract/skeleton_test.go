@@ -0,0 +1,232 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestParseIoctls(t *testing.T) {
+	tests := []struct {
+		text   string
+		ioctls []string // name, dir and type of each ioctl
+	}{
+		{"#define FOO_RESET _IO('F', 1)\n", []string{"FOO_RESET  "}},
+		{"#define FOO_GET _IOR('F', 2, struct foo_info)\n", []string{"FOO_GET out struct foo_info"}},
+		{"#define FOO_SET _IOW('F', 3, __u32)\n", []string{"FOO_SET in __u32"}},
+		{"# define FOO_XCHG\t_IOWR(FOO_MAGIC, 4, struct  foo_info)\n", []string{"FOO_XCHG inout struct foo_info"}},
+		{"#define FOO_ARR _IOR('F', 5, char[(16)])\n", []string{"FOO_ARR out char[(16)]"}},
+		// Unparsable arguments and non-ioctl defines are skipped.
+		{"#define FOO_BAD _IOR('F', 6)\n#define FOO_MAGIC 'F'\n#define FOO_IO(x) _IO('F', x)\n", nil},
+	}
+	for i, test := range tests {
+		var got []string
+		for _, ioc := range parseIoctls(test.text) {
+			got = append(got, fmt.Sprintf("%v %v %v", ioc.name, ioc.dir, ioc.typ))
+		}
+		if strings.Join(got, "\n") != strings.Join(test.ioctls, "\n") {
+			t.Errorf("#%v: got ioctls %q, want %q", i, got, test.ioctls)
+		}
+	}
+}
+
+func TestParseStructs(t *testing.T) {
+	tests := []struct {
+		text    string
+		typ     string
+		union   bool
+		packed  bool
+		invalid bool
+		fields  []string // name, type, pointer, array dimensions and bitfield width of each field
+	}{
+		{
+			text: "struct foo { __u32 a; char *b, c[2][3]; __u8 d:3; };",
+			typ:  "struct foo",
+			fields: []string{
+				"a __u32 false 0 ",
+				"b char true 0 ",
+				"c char false 2 ",
+				"d __u8 false 0 3",
+			},
+		},
+		{
+			text:   "struct foo {\n\tunsigned int a;\n\t__aligned_u64 b;\n\tvoid __user *c;\n} __attribute__((packed));",
+			typ:    "struct foo",
+			packed: true,
+			fields: []string{
+				"a unsigned int false 0 ",
+				"b __u64 false 0 ",
+				"c void true 0 ",
+			},
+		},
+		{
+			text:  "union foo { struct bar b; __s16 s; };",
+			typ:   "union foo",
+			union: true,
+			fields: []string{
+				"b struct bar false 0 ",
+				"s __s16 false 0 ",
+			},
+		},
+		// Nested anonymous unions and conditionals are not parsed.
+		{
+			text:    "struct foo { int a; union { int b; long c; }; };",
+			typ:     "struct foo",
+			invalid: true,
+		},
+		{
+			text:    "struct foo { int a;\n#ifdef BAR\n\tint b;\n#endif\n};",
+			typ:     "struct foo",
+			invalid: true,
+		},
+		{
+			text:    "struct foo { int (*fn)(void); };",
+			typ:     "struct foo",
+			invalid: true,
+		},
+	}
+	for i, test := range tests {
+		s := parseStructs(test.text)[test.typ]
+		if s == nil {
+			t.Errorf("#%v: %v is not parsed", i, test.typ)
+			continue
+		}
+		var fields []string
+		for _, f := range s.fields {
+			fields = append(fields, fmt.Sprintf("%v %v %v %v %v", f.name, f.typ, f.ptr, f.dims, f.bitfield))
+		}
+		if s.union != test.union || s.packed != test.packed || s.invalid != test.invalid ||
+			strings.Join(fields, "\n") != strings.Join(test.fields, "\n") {
+			t.Errorf("#%v: got union %v, packed %v, invalid %v, fields %q\nwant union %v, packed %v, invalid %v, fields %q",
+				i, s.union, s.packed, s.invalid, fields, test.union, test.packed, test.invalid, test.fields)
+		}
+	}
+}
+
+func TestHeaderInclude(t *testing.T) {
+	tests := []struct {
+		header  string
+		include string
+	}{
+		{"include/uapi/linux/foo.h", "linux/foo.h"},
+		{"include/linux/foo.h", "linux/foo.h"},
+		{"arch/x86/include/uapi/asm/foo.h", "asm/foo.h"},
+		{"include/uapi/sound/asound.h", "sound/asound.h"},
+	}
+	for _, test := range tests {
+		if include := headerInclude(test.header); include != test.include {
+			t.Errorf("%v: got include %v, want %v", test.header, include, test.include)
+		}
+	}
+}
+
+func TestGenerateSkeleton(t *testing.T) {
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc is not available")
+	}
+	if archs[runtime.GOARCH] == nil {
+		t.Skipf("unsupported host arch %v", runtime.GOARCH)
+	}
+	defer func(linux, bld string) { *flagLinux, *flagLinuxBld = linux, bld }(*flagLinux, *flagLinuxBld)
+	const header = `
+#include <linux/types.h>
+
+struct foo_range {
+	__s64 start;
+	__s64 end;
+};
+
+struct foo_info {
+	__u32 flags;
+	__u16 ids[4];
+	struct foo_range range;
+	__u64 *data;
+	__u8 kind:3, mode:5;
+};
+
+union foo_arg {
+	__u32 id;
+	struct foo_range range;
+};
+
+struct foo_opaque {
+	int a;
+	union {
+		int b;
+		long c;
+	};
+};
+
+#define FOO_RESET	_IO('F', 0)
+#define FOO_GET_INFO	_IOR('F', 1, struct foo_info)
+#define FOO_SET_ID	_IOW('F', 2, __u32)
+#define FOO_XCHG	_IOWR('F', 3, union foo_arg)
+#define FOO_OPAQUE	_IOW('F', 4, struct foo_opaque)
+`
+	// Descriptions reference the name given by the test as NAME and the device as DEV.
+	const skeleton = `# AUTOGENERATED from include/uapi/linux/foo.h by syz-extract -skeleton.
+# This is only a starting point: refine types, flags, lengths and directions by hand.
+
+include <linux/foo.h>
+
+resource fd_NAME[fd]
+
+openat$NAME(fd const[AT_FDCWD], file ptr[in, string["DEV"]], flags flags[open_flags], mode const[0]) fd_NAME
+
+ioctl$FOO_RESET(fd fd_NAME, cmd const[FOO_RESET], arg intptr)
+ioctl$FOO_GET_INFO(fd fd_NAME, cmd const[FOO_GET_INFO], arg ptr[out, foo_info])
+ioctl$FOO_SET_ID(fd fd_NAME, cmd const[FOO_SET_ID], arg ptr[in, int32])
+ioctl$FOO_XCHG(fd fd_NAME, cmd const[FOO_XCHG], arg ptr[inout, foo_arg])
+ioctl$FOO_OPAQUE(fd fd_NAME, cmd const[FOO_OPAQUE], arg ptr[in, foo_opaque])
+
+foo_info {
+	flags	int32
+	ids	array[int16, 4]
+	range	foo_range
+	data	ptr[in, array[int8]]
+	kind	int8:3
+	mode	int8:5
+}
+
+foo_range {
+	start	int64
+	end	int64
+}
+
+foo_arg [
+	id	int32
+	range	foo_range
+]
+
+foo_opaque {
+	data	array[int8, 16]
+}
+`
+	tests := []struct {
+		dev      string // -dev flag
+		name     string // -name flag
+		wantDev  string
+		wantName string
+	}{
+		{"", "", "/dev/foo", "foo"},
+		{"/dev/foo0", "foo0", "/dev/foo0", "foo0"},
+		{"/dev/bar/1", "", "/dev/bar/1", "foo"},
+	}
+	dir := makeTestKernel(t, map[string]string{"include/uapi/linux/foo.h": header})
+	defer os.RemoveAll(dir)
+	for i, test := range tests {
+		out := new(bytes.Buffer)
+		generateSkeleton(archs[runtime.GOARCH], dir+"/include/uapi/linux/foo.h", test.dev, test.name, out)
+		want := strings.Replace(strings.Replace(skeleton, "NAME", test.wantName, -1), "DEV", test.wantDev, -1)
+		if out.String() != want {
+			t.Errorf("#%v: got skeleton:\n%v\nwant:\n%v", i, out.String(), want)
+		}
+	}
+}
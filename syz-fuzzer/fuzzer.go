@@ -29,6 +29,7 @@ import (
 	"github.com/google/syzkaller/hash"
 	"github.com/google/syzkaller/host"
 	"github.com/google/syzkaller/ipc"
+	"github.com/google/syzkaller/ipc/ipcconfig"
 	. "github.com/google/syzkaller/log"
 	"github.com/google/syzkaller/prog"
 	. "github.com/google/syzkaller/rpctype"
@@ -38,25 +39,75 @@ import (
 var (
 	flagName     = flag.String("name", "", "unique name for manager")
 	flagExecutor = flag.String("executor", "", "path to executor binary")
-	flagManager  = flag.String("manager", "", "manager rpc address")
+	flagManager  = flag.String("manager", "", "manager rpc address (if not set, fuzzer works in standalone mode)")
 	flagProcs    = flag.Int("procs", 1, "number of parallel test processes")
 	flagLeak     = flag.Bool("leak", false, "detect memory leaks")
 	flagOutput   = flag.String("output", "stdout", "write programs to none/stdout/dmesg/file")
 	flagPprof    = flag.String("pprof", "", "address to serve pprof profiles")
+	flagBatch    = flag.Int("batch", 4, "number of generated/mutated programs executed per executor request")
+	flagPrefix   = flag.Bool("prefix", true, "sometimes mutate only tails of a program and execute the shared prefix once")
+
+	// Executor configurations of procs in ipc.FlagsString format separated by '/',
+	// e.g. "setuid,threaded,collide,cover/namespace,threaded,cover".
+	flagExecConfigs = flag.String("exec_configs", "", "executor configurations of procs (by default all procs use -sandbox/-threaded/-collide/-cover)")
+
+	// Standalone mode (see standalone.go).
+	flagWorkdir = flag.String("workdir", "", "working dir with corpus and crashes for standalone mode")
+	flagHttp    = flag.String("http", "", "status page address for standalone mode")
+
+	flagErrnoSignal = flag.Bool("errno_signal", false, "use (syscall, errno) pairs as additional feedback signal")
+
+	// Relative weights of what a fuzzing process does next (candidates from manager always go first).
+	flagGenWeight    = flag.Int("gen_weight", 1, "relative weight of generation of new programs")
+	flagMutateWeight = flag.Int("mutate_weight", 99, "relative weight of mutation of corpus programs")
+	flagTriageWeight = flag.Int("triage_weight", -1, "relative weight of triage of new inputs (-1: triage always goes first)")
 )
 
 const (
 	programLength = 30
+
+	// Calls that block in more than half of executions (after blockedMinExecs executions)
+	// get their priorities multiplied by blockedPenalty.
+	blockedMinExecs = 100
+	blockedPenalty  = 0.1
+
+	// Local IDs of untriaged inputs have this bit set, manager IDs never reach it.
+	localTriageID = uint64(1) << 63
 )
 
 type Input struct {
+	id        uint64 // manager ID of the untriaged input this input originates from (or local ID, see workMu)
 	p         *prog.Prog
 	call      int
 	signal    []uint32
 	minimized bool
 }
 
+// procDescStats are per-description stats of a single proc since last poll.
+// They are per proc, so that procs don't contend for them.
+// Fields are keyed by types, names are built only on poll.
+type procDescStats struct {
+	mu     sync.Mutex
+	stats  map[string]DescStat   // per call
+	fields map[fieldKey]DescStat // per struct field and union option
+	seen   map[fieldKey]bool     // fields used by the current call, reused across calls
+}
+
+type fieldKey struct {
+	parent sys.Type
+	field  sys.Type
+}
+
+func newProcDescStats() *procDescStats {
+	return &procDescStats{
+		stats:  make(map[string]DescStat),
+		fields: make(map[fieldKey]DescStat),
+		seen:   make(map[fieldKey]bool),
+	}
+}
+
 type Candidate struct {
+	id        uint64
 	p         *prog.Prog
 	minimized bool
 }
@@ -72,12 +123,41 @@ var (
 	corpusMu     sync.RWMutex
 	corpus       []*prog.Prog
 	corpusHashes map[hash.Sig]struct{}
+	sched        *seedScheduler
 
 	triageMu        sync.RWMutex
 	triage          []Input
 	triageCandidate []Input
 	candidates      []Candidate
 
+	// Manager owns untriaged inputs (see syz-manager/triage.go).
+	// work counts pending local tasks (candidate execution, triage) by manager input ID,
+	// when all tasks are done the input is acknowledged with the next poll (PollArgs.TriageDone).
+	// Inputs found by the fuzzer itself get local IDs (with localTriageID bit set)
+	// and are registered with manager on the next poll. Inputs that are triaged
+	// before that are not registered at all.
+	workMu        sync.Mutex
+	work          map[uint64]int
+	lastLocalID   uint64
+	triagePending map[uint64]Input  // not yet registered inputs by local ID
+	triageIDs     map[uint64]uint64 // manager IDs of registered inputs by local ID (0 while poll is in flight)
+	triageDoneIDs []uint64          // manager IDs of triaged inputs to acknowledge with the next poll
+
+	descStats []*procDescStats // per proc, merged on poll
+
+	callStatsMu sync.Mutex
+	callStats   map[string]CallStat // since last poll
+	callBlocked map[*sys.Call]*blockedStat
+	callHangs   map[string]uint64 // number of program hangs attributed to the call since last poll
+
+	failuresMu       sync.Mutex
+	executorFailures map[string]uint64 // executor setup failures by reason since last poll
+
+	ctMu sync.RWMutex
+	ct   *prog.ChoiceTable
+
+	quarantined map[string]bool // calls quarantined by manager, excluded from choice table
+
 	gate *ipc.Gate
 
 	statExecGen       uint64
@@ -86,6 +166,11 @@ var (
 	statExecTriage    uint64
 	statExecMinimize  uint64
 	statNewInput      uint64
+	statPrefixBatch   uint64
+	statErrnoSignal   uint64 // new errno signal seen in executed programs
+	statErrnoInput    uint64 // new inputs with only errno signal
+	statSchedMutate   uint64 // mutations of seeds selected by scheduler
+	statSchedFound    uint64 // mutations of seeds that produced new signal
 
 	allTriaged uint32
 	noCover    bool
@@ -100,6 +185,18 @@ func main() {
 		fmt.Fprintf(os.Stderr, "-output flag must be one of none/stdout/dmesg/file\n")
 		os.Exit(1)
 	}
+	if *flagGenWeight < 0 || *flagMutateWeight < 0 || *flagGenWeight+*flagMutateWeight == 0 || *flagTriageWeight < -1 {
+		fmt.Fprintf(os.Stderr, "bad -gen_weight/-mutate_weight/-triage_weight flags\n")
+		os.Exit(1)
+	}
+	if *flagBatch < 1 || *flagBatch > ipc.MaxBatch {
+		fmt.Fprintf(os.Stderr, "-batch flag must be in [1, %v]\n", ipc.MaxBatch)
+		os.Exit(1)
+	}
+	if *flagManager == "" && *flagWorkdir == "" {
+		fmt.Fprintf(os.Stderr, "either -manager or -workdir (for standalone mode) must be specified\n")
+		os.Exit(1)
+	}
 	Logf(0, "fuzzer started")
 
 	go func() {
@@ -120,40 +217,38 @@ func main() {
 		runtime.MemProfileRate = 0
 	}
 
-	corpusSignal = make(map[uint32]struct{})
-	maxSignal = make(map[uint32]struct{})
-	newSignal = make(map[uint32]struct{})
-	corpusHashes = make(map[hash.Sig]struct{})
+	initState()
 
+	if *flagManager == "" {
+		if *flagName == "" {
+			*flagName = "standalone"
+		}
+		*flagManager = startStandalone(*flagWorkdir, *flagHttp)
+	}
 	Logf(0, "dialing manager at %v", *flagManager)
 	a := &ConnectArgs{*flagName}
 	r := &ConnectRes{}
 	if err := RpcCall(*flagManager, "Manager.Connect", a, r); err != nil {
 		panic(err)
 	}
+	if r.Descriptions != nil {
+		if err := sys.LoadDescriptions(r.Descriptions); err != nil {
+			panic(err)
+		}
+		Logf(0, "loaded %v syscall descriptions", len(sys.Calls))
+	}
 	calls := buildCallList(r.EnabledCalls)
-	ct := prog.BuildChoiceTable(r.Prios, calls)
+	prios := r.Prios
+	setQuarantined(r.Quarantined)
+	sched.targets = r.Targets
+	ct = buildChoiceTable(prios, calls)
+	lastChoiceTable := time.Now()
 	for _, inp := range r.Inputs {
 		addInput(inp)
 	}
 	for _, s := range r.MaxSignal {
 		maxSignal[s] = struct{}{}
 	}
-	for _, candidate := range r.Candidates {
-		p, err := prog.Deserialize(candidate.Prog)
-		if err != nil {
-			panic(err)
-		}
-		if noCover {
-			corpusMu.Lock()
-			corpus = append(corpus, p)
-			corpusMu.Unlock()
-		} else {
-			triageMu.Lock()
-			candidates = append(candidates, Candidate{p, candidate.Minimized})
-			triageMu.Unlock()
-		}
-	}
 
 	if r.NeedCheck {
 		a := &CheckArgs{Name: *flagName}
@@ -181,103 +276,59 @@ func main() {
 
 	kmemleakInit()
 
-	flags, timeout, err := ipc.DefaultFlags()
+	flags, timeout, err := ipcconfig.Default()
 	if err != nil {
 		panic(err)
 	}
 	if _, ok := calls[sys.CallMap["syz_emit_ethernet"]]; ok {
 		flags |= ipc.FlagEnableTun
 	}
-	noCover = flags&ipc.FlagSignal == 0
-	leakCallback := func() {
-		if atomic.LoadUint32(&allTriaged) != 0 {
-			// Scan for leaks once in a while (it is damn slow).
-			kmemleakScan(true)
+	if _, ok := calls[sys.CallMap["syz_extract_tcp_res"]]; ok {
+		flags |= ipc.FlagEnableTun
+	}
+	procFlags, err := makeProcFlags(flags)
+	if err != nil {
+		Fatalf("%v", err)
+	}
+	// Procs without coverage only fuzz, triage is done by procs with coverage.
+	noCover = true
+	for _, f := range procFlags {
+		if f&ipc.FlagSignal != 0 {
+			noCover = false
 		}
 	}
-	if !*flagLeak {
-		leakCallback = nil
+	if noCover && *flagErrnoSignal {
+		// Errno signal is triaged along with coverage signal.
+		Logf(0, "errno signal requires coverage, disabling")
+		*flagErrnoSignal = false
+	}
+	// Candidates are handled after we know whether coverage is enabled and have manager connection.
+	addCandidates(r.Candidates)
+	envs := make([]*ipc.Env, *flagProcs)
+	var leakCallback func()
+	if *flagLeak {
+		// All procs are stopped when the callback runs, so it can use their envs.
+		leakCallback = func() { leakCheck(envs) }
 	}
 	gate = ipc.NewGate(2**flagProcs, leakCallback)
 	needPoll := make(chan struct{}, 1)
 	needPoll <- struct{}{}
-	envs := make([]*ipc.Env, *flagProcs)
 	for pid := 0; pid < *flagProcs; pid++ {
-		env, err := ipc.MakeEnv(*flagExecutor, timeout, flags, pid)
+		env, err := ipc.MakeEnv(*flagExecutor, timeout, procFlags[pid], pid)
 		if err != nil {
+			if reason := ipc.FailureReason(err); reason != "" {
+				executorFailed(reason, err)
+			}
 			panic(err)
 		}
 		envs[pid] = env
 
-		pid := pid
-		go func() {
-			rs := rand.NewSource(time.Now().UnixNano() + int64(pid)*1e12)
-			rnd := rand.New(rs)
-
-			for i := 0; ; i++ {
-				triageMu.RLock()
-				if len(triageCandidate) != 0 || len(candidates) != 0 || len(triage) != 0 {
-					triageMu.RUnlock()
-					triageMu.Lock()
-					if len(triageCandidate) != 0 {
-						last := len(triageCandidate) - 1
-						inp := triageCandidate[last]
-						triageCandidate = triageCandidate[:last]
-						triageMu.Unlock()
-						Logf(1, "triaging candidate: %s", inp.p)
-						triageInput(pid, env, inp)
-						continue
-					} else if len(candidates) != 0 {
-						last := len(candidates) - 1
-						candidate := candidates[last]
-						candidates = candidates[:last]
-						wakePoll := len(candidates) < *flagProcs
-						triageMu.Unlock()
-						if wakePoll {
-							select {
-							case needPoll <- struct{}{}:
-							default:
-							}
-						}
-						Logf(1, "executing candidate: %s", candidate.p)
-						execute(pid, env, candidate.p, false, candidate.minimized, true, &statExecCandidate)
-						continue
-					} else if len(triage) != 0 {
-						last := len(triage) - 1
-						inp := triage[last]
-						triage = triage[:last]
-						triageMu.Unlock()
-						Logf(1, "triaging : %s", inp.p)
-						triageInput(pid, env, inp)
-						continue
-					} else {
-						triageMu.Unlock()
-					}
-				} else {
-					triageMu.RUnlock()
-				}
-
-				corpusMu.RLock()
-				if len(corpus) == 0 || i%100 == 0 {
-					// Generate a new prog.
-					corpusMu.RUnlock()
-					p := prog.Generate(rnd, programLength, ct)
-					Logf(1, "#%v: generated: %s", i, p)
-					execute(pid, env, p, false, false, false, &statExecGen)
-				} else {
-					// Mutate an existing prog.
-					p := corpus[rnd.Intn(len(corpus))].Clone()
-					corpusMu.RUnlock()
-					p.Mutate(rs, programLength, ct, corpus)
-					Logf(1, "#%v: mutated: %s", i, p)
-					execute(pid, env, p, false, false, false, &statExecFuzz)
-				}
-			}
-		}()
+		go proc(pid, env, needPoll, 0)
 	}
 
 	var execTotal uint64
 	var lastPoll time.Time
+	quarantineChanged := false
 	var lastPrint time.Time
 	ticker := time.NewTicker(3 * time.Second).C
 	for {
@@ -292,6 +343,14 @@ func main() {
 			Logf(0, "alive, executed %v", execTotal)
 			lastPrint = time.Now()
 		}
+		if time.Since(lastChoiceTable) > 10*time.Minute || quarantineChanged {
+			newCt := buildChoiceTable(prios, calls)
+			ctMu.Lock()
+			ct = newCt
+			ctMu.Unlock()
+			lastChoiceTable = time.Now()
+			quarantineChanged = false
+		}
 		if poll || time.Since(lastPoll) > 10*time.Second {
 			triageMu.RLock()
 			if len(candidates) > *flagProcs {
@@ -311,6 +370,19 @@ func main() {
 			}
 			newSignal = make(map[uint32]struct{})
 			signalMu.Unlock()
+			a.DescStats = takeDescStats()
+			callStatsMu.Lock()
+			a.CallStats = callStats
+			callStats = make(map[string]CallStat)
+			a.Hangs = callHangs
+			callHangs = make(map[string]uint64)
+			callStatsMu.Unlock()
+			failuresMu.Lock()
+			for reason, n := range executorFailures {
+				a.Stats["executor failure "+reason] += n
+			}
+			executorFailures = make(map[string]uint64)
+			failuresMu.Unlock()
 			for _, env := range envs {
 				a.Stats["exec total"] += atomic.SwapUint64(&env.StatExecs, 0)
 				a.Stats["executor restarts"] += atomic.SwapUint64(&env.StatRestarts, 0)
@@ -331,10 +403,21 @@ func main() {
 			a.Stats["exec minimize"] = execMinimize
 			execTotal += execMinimize
 			a.Stats["fuzzer new inputs"] = atomic.SwapUint64(&statNewInput, 0)
+			a.Stats["prefix batches"] = atomic.SwapUint64(&statPrefixBatch, 0)
+			a.Stats["sched mutations"] = atomic.SwapUint64(&statSchedMutate, 0)
+			a.Stats["sched new signal"] = atomic.SwapUint64(&statSchedFound, 0)
+			if *flagErrnoSignal {
+				a.Stats["errno new signal"] = atomic.SwapUint64(&statErrnoSignal, 0)
+				a.Stats["errno only inputs"] = atomic.SwapUint64(&statErrnoInput, 0)
+			}
+			var localIDs []uint64
+			a.NewTriage, localIDs = takeTriage()
+			a.TriageDone = takeTriageDone()
 			r := &PollRes{}
 			if err := manager.Call("Manager.Poll", a, r); err != nil {
 				panic(err)
 			}
+			triageRegistered(localIDs, r.TriageIDs)
 			if len(r.MaxSignal) != 0 {
 				signalMu.Lock()
 				for _, s := range r.MaxSignal {
@@ -345,21 +428,10 @@ func main() {
 			for _, inp := range r.NewInputs {
 				addInput(inp)
 			}
-			for _, candidate := range r.Candidates {
-				p, err := prog.Deserialize(candidate.Prog)
-				if err != nil {
-					panic(err)
-				}
-				if noCover {
-					corpusMu.Lock()
-					corpus = append(corpus, p)
-					corpusMu.Unlock()
-				} else {
-					triageMu.Lock()
-					candidates = append(candidates, Candidate{p, candidate.Minimized})
-					triageMu.Unlock()
-				}
+			if setQuarantined(r.Quarantined) {
+				quarantineChanged = true
 			}
+			addCandidates(r.Candidates)
 			if len(r.Candidates) == 0 && atomic.LoadUint32(&allTriaged) == 0 {
 				if *flagLeak {
 					kmemleakScan(false)
@@ -373,6 +445,292 @@ func main() {
 	}
 }
 
+// proc is the main loop of a fuzzing process: it triages new inputs, executes candidates
+// and generates/mutates new programs. It runs iters iterations, or forever if iters is 0.
+func proc(pid int, env *ipc.Env, needPoll chan struct{}, iters int) {
+	rs := rand.NewSource(time.Now().UnixNano() + int64(pid)*1e12)
+	rnd := rand.New(rs)
+	hasCover := env.Flags()&ipc.FlagSignal != 0
+
+	for i := 0; iters == 0 || i < iters; i++ {
+		// Decide whether we triage pending inputs or fuzz in this iteration.
+		doTriage := *flagTriageWeight < 0 ||
+			rnd.Intn(*flagTriageWeight+*flagGenWeight+*flagMutateWeight) < *flagTriageWeight
+		triageMu.RLock()
+		if hasCover && (len(triageCandidate) != 0 || len(candidates) != 0 || doTriage && len(triage) != 0) {
+			triageMu.RUnlock()
+			triageMu.Lock()
+			if len(triageCandidate) != 0 {
+				last := len(triageCandidate) - 1
+				inp := triageCandidate[last]
+				triageCandidate = triageCandidate[:last]
+				triageMu.Unlock()
+				Logf(1, "triaging candidate: %s", inp.p)
+				triageInput(pid, env, inp)
+				continue
+			} else if len(candidates) != 0 {
+				last := len(candidates) - 1
+				candidate := candidates[last]
+				candidates = candidates[:last]
+				wakePoll := len(candidates) < *flagProcs
+				triageMu.Unlock()
+				if wakePoll {
+					select {
+					case needPoll <- struct{}{}:
+					default:
+					}
+				}
+				Logf(1, "executing candidate: %s", candidate.p)
+				execute(pid, env, candidate.p, false, candidate.minimized, candidate.id, &statExecCandidate)
+				workDone(candidate.id)
+				continue
+			} else if doTriage && len(triage) != 0 {
+				last := len(triage) - 1
+				inp := triage[last]
+				triage = triage[:last]
+				triageMu.Unlock()
+				Logf(1, "triaging : %s", inp.p)
+				triageInput(pid, env, inp)
+				continue
+			} else {
+				triageMu.Unlock()
+			}
+		} else {
+			triageMu.RUnlock()
+		}
+
+		// Generate/mutate a batch of programs and execute them in one executor request.
+		var progs []*prog.Prog
+		var seeds []*seed
+		var stats []*uint64
+		ct := choiceTable()
+		if *flagPrefix && *flagBatch > 1 && rnd.Intn(10) == 0 {
+			// Mutate only tails of a program, so that executor runs the prefix once.
+			if tails, s, prefix := mutateTails(rnd, rs, ct); tails != nil {
+				for j, p := range tails {
+					Logf(1, "#%v: mutated tail after %v calls: %s", i+j, prefix, p)
+					seeds = append(seeds, s)
+					stats = append(stats, &statExecFuzz)
+				}
+				i += len(tails) - 1
+				atomic.AddUint64(&statPrefixBatch, 1)
+				executeBatch(pid, env, tails, seeds, prefix, stats)
+				continue
+			}
+		}
+		for j := 0; j < *flagBatch; j++ {
+			if j != 0 {
+				i++
+			}
+			var s *seed
+			if rnd.Intn(*flagGenWeight+*flagMutateWeight) >= *flagGenWeight {
+				s = sched.choose(rnd)
+			}
+			if s == nil {
+				// Generate a new prog.
+				p := prog.Generate(rnd, programLength, ct)
+				Logf(1, "#%v: generated: %s", i, p)
+				progs = append(progs, p)
+				seeds = append(seeds, nil)
+				stats = append(stats, &statExecGen)
+			} else {
+				// Mutate an existing prog.
+				p := s.p.Clone()
+				corpusMu.RLock()
+				p.Mutate(rs, programLength, ct, corpus)
+				corpusMu.RUnlock()
+				Logf(1, "#%v: mutated: %s", i, p)
+				progs = append(progs, p)
+				seeds = append(seeds, s)
+				stats = append(stats, &statExecFuzz)
+			}
+		}
+		executeBatch(pid, env, progs, seeds, 0, stats)
+	}
+}
+
+// addCandidates queues untriaged inputs received from manager.
+func addCandidates(cands []RpcCandidate) {
+	var done []uint64
+	for _, candidate := range cands {
+		p, err := prog.Deserialize(candidate.Prog)
+		if err != nil {
+			panic(err)
+		}
+		if noCover {
+			corpusMu.Lock()
+			corpus = append(corpus, p)
+			corpusMu.Unlock()
+			sched.add(p, nil, nil)
+			done = append(done, candidate.ID)
+			continue
+		}
+		workStart(candidate.ID)
+		triageMu.Lock()
+		if len(candidate.Signal) != 0 {
+			// The input was found by another fuzzer that was lost before triaging it.
+			triageCandidate = append(triageCandidate, Input{
+				id:        candidate.ID,
+				p:         p,
+				call:      candidate.CallIndex,
+				signal:    candidate.Signal,
+				minimized: candidate.Minimized,
+			})
+		} else {
+			candidates = append(candidates, Candidate{candidate.ID, p, candidate.Minimized})
+		}
+		triageMu.Unlock()
+	}
+	if len(done) != 0 {
+		triageDone(done)
+	}
+}
+
+// workStart notes a new local task for the manager input id.
+func workStart(id uint64) {
+	workMu.Lock()
+	work[id]++
+	workMu.Unlock()
+}
+
+// workDone notes completion of a local task for the manager input id
+// and queues acknowledgement of the input if it was the last task.
+func workDone(id uint64) {
+	workMu.Lock()
+	work[id]--
+	last := work[id] == 0
+	if last {
+		delete(work, id)
+		if id&localTriageID != 0 {
+			local := id
+			id = 0
+			if _, ok := triagePending[local]; ok {
+				delete(triagePending, local)
+			} else {
+				// If the input is being registered (id is 0), it is acknowledged in triageRegistered.
+				id = triageIDs[local]
+				delete(triageIDs, local)
+			}
+		}
+		if id != 0 {
+			triageDoneIDs = append(triageDoneIDs, id)
+		}
+	}
+	workMu.Unlock()
+}
+
+// registerTriage assigns local IDs to new inputs and queues them for registration with manager.
+// Registration is done with the next poll, so that procs don't wait for manager.
+func registerTriage(inputs []Input) {
+	workMu.Lock()
+	defer workMu.Unlock()
+	for i := range inputs {
+		lastLocalID++
+		inputs[i].id = localTriageID | lastLocalID
+		triagePending[inputs[i].id] = inputs[i]
+	}
+}
+
+// takeTriage returns queued inputs for registration with manager and their local IDs.
+func takeTriage() ([]RpcCandidate, []uint64) {
+	workMu.Lock()
+	pending := triagePending
+	triagePending = make(map[uint64]Input)
+	var ids []uint64
+	for id := range pending {
+		triageIDs[id] = 0
+		ids = append(ids, id)
+	}
+	workMu.Unlock()
+	var inputs []RpcCandidate
+	for _, id := range ids {
+		inp := pending[id]
+		inputs = append(inputs, RpcCandidate{
+			Prog:      inp.p.Serialize(),
+			Minimized: inp.minimized,
+			Signal:    inp.signal,
+			CallIndex: inp.call,
+		})
+	}
+	return inputs, ids
+}
+
+// triageRegistered remembers manager IDs of inputs returned by takeTriage
+// and acknowledges inputs that were triaged while they were being registered.
+func triageRegistered(ids, mgrIDs []uint64) {
+	workMu.Lock()
+	defer workMu.Unlock()
+	for i, local := range ids {
+		if _, ok := triageIDs[local]; ok {
+			triageIDs[local] = mgrIDs[i]
+		} else {
+			triageDoneIDs = append(triageDoneIDs, mgrIDs[i])
+		}
+	}
+}
+
+// triageDone queues triaged inputs to acknowledge with the next poll.
+func triageDone(ids []uint64) {
+	workMu.Lock()
+	triageDoneIDs = append(triageDoneIDs, ids...)
+	workMu.Unlock()
+}
+
+// takeTriageDone returns manager IDs of inputs to acknowledge.
+func takeTriageDone() []uint64 {
+	workMu.Lock()
+	defer workMu.Unlock()
+	ids := triageDoneIDs
+	triageDoneIDs = nil
+	return ids
+}
+
+// initState initializes global fuzzing state.
+func initState() {
+	corpusSignal = make(map[uint32]struct{})
+	maxSignal = make(map[uint32]struct{})
+	newSignal = make(map[uint32]struct{})
+	corpusHashes = make(map[hash.Sig]struct{})
+	sched = newSeedScheduler()
+	work = make(map[uint64]int)
+	triagePending = make(map[uint64]Input)
+	triageIDs = make(map[uint64]uint64)
+	triageDoneIDs = nil
+	descStats = make([]*procDescStats, *flagProcs)
+	for pid := range descStats {
+		descStats[pid] = newProcDescStats()
+	}
+	callStats = make(map[string]CallStat)
+	callBlocked = make(map[*sys.Call]*blockedStat)
+	callHangs = make(map[string]uint64)
+	executorFailures = make(map[string]uint64)
+}
+
+// makeProcFlags returns executor flags for each proc: flags with configuration
+// (sandbox, threaded, collide, cover) replaced according to -exec_configs.
+func makeProcFlags(flags uint64) ([]uint64, error) {
+	procFlags := make([]uint64, *flagProcs)
+	if *flagExecConfigs == "" {
+		for pid := range procFlags {
+			procFlags[pid] = flags
+		}
+		return procFlags, nil
+	}
+	configs := strings.Split(*flagExecConfigs, "/")
+	if len(configs) != *flagProcs {
+		return nil, fmt.Errorf("got %v executor configurations for %v procs", len(configs), *flagProcs)
+	}
+	for pid, config := range configs {
+		f, err := ipc.ParseFlags(config)
+		if err != nil {
+			return nil, err
+		}
+		procFlags[pid] = f | flags&(ipc.FlagDebug|ipc.FlagEnableTun)
+		Logf(0, "proc %v: %v", pid, ipc.FlagsString(procFlags[pid]))
+	}
+	return procFlags, nil
+}
+
 func buildCallList(enabledCalls string) map[*sys.Call]bool {
 	calls := make(map[*sys.Call]bool)
 	if enabledCalls != "" {
@@ -430,6 +788,7 @@ func addInput(inp RpcInput) {
 	if _, ok := corpusHashes[sig]; !ok {
 		corpus = append(corpus, p)
 		corpusHashes[sig] = struct{}{}
+		sched.add(p, inp.Signal, inp.Cover)
 	}
 	if diff := cover.SignalDiff(maxSignal, inp.Signal); len(diff) != 0 {
 		cover.SignalAdd(corpusSignal, diff)
@@ -441,6 +800,7 @@ func triageInput(pid int, env *ipc.Env, inp Input) {
 	if noCover {
 		panic("should not be called when coverage is disabled")
 	}
+	defer workDone(inp.id)
 
 	signalMu.RLock()
 	newSignal := cover.SignalDiff(corpusSignal, inp.signal)
@@ -451,10 +811,7 @@ func triageInput(pid int, env *ipc.Env, inp Input) {
 	newSignal = cover.Canonicalize(newSignal)
 
 	call := inp.p.Calls[inp.call].Meta
-	data := inp.p.Serialize()
-	sig := hash.Hash(data)
-
-	Logf(3, "triaging input for %v (new signal=%v):\n%s", call.CallName, len(newSignal), data)
+	Logf(3, "triaging input for %v (new signal=%v):\n%s", call.CallName, len(newSignal), inp.p)
 	var inputCover cover.Cover
 	if inp.minimized {
 		// We just need to get input coverage.
@@ -469,6 +826,7 @@ func triageInput(pid int, env *ipc.Env, inp Input) {
 	} else {
 		// We need to compute input coverage and non-flaky signal for minimization.
 		notexecuted := false
+		var errnoSignal uint32
 		for i := 0; i < 3; i++ {
 			info := execute1(pid, env, inp.p, &statExecTriage, true)
 			if len(info) == 0 || len(info[inp.call].Signal) == 0 {
@@ -484,15 +842,19 @@ func triageInput(pid int, env *ipc.Env, inp Input) {
 			if len(newSignal) == 0 {
 				return
 			}
+			errnoSignal = cover.ErrnoSignal(call.ID, inf.Errno)
 			if len(inputCover) == 0 {
 				inputCover = append([]uint32{}, inf.Cover...)
 			} else {
 				inputCover = cover.Union(inputCover, inf.Cover)
 			}
 		}
+		if *flagErrnoSignal && len(newSignal) == 1 && newSignal[0] == errnoSignal {
+			atomic.AddUint64(&statErrnoInput, 1)
+		}
 
 		inp.p, inp.call = prog.Minimize(inp.p, inp.call, func(p1 *prog.Prog, call1 int) bool {
-			info := execute(pid, env, p1, false, false, false, &statExecMinimize)
+			info := execute(pid, env, p1, false, false, 0, &statExecMinimize)
 			if len(info) == 0 || len(info[call1].Signal) == 0 {
 				return false // The call was not executed.
 			}
@@ -507,6 +869,9 @@ func triageInput(pid int, env *ipc.Env, inp Input) {
 		}, false)
 	}
 
+	// Serialize after minimization, so that manager and corpus get the minimized program.
+	data := inp.p.Serialize()
+	sig := hash.Hash(data)
 	atomic.AddUint64(&statNewInput, 1)
 	Logf(2, "added new input for %v to corpus:\n%s", call.CallName, data)
 	a := &NewInputArgs{
@@ -531,20 +896,79 @@ func triageInput(pid int, env *ipc.Env, inp Input) {
 	if _, ok := corpusHashes[sig]; !ok {
 		corpus = append(corpus, inp.p)
 		corpusHashes[sig] = struct{}{}
+		sched.add(inp.p, inp.signal, inputCover)
 	}
 	corpusMu.Unlock()
 }
 
-func execute(pid int, env *ipc.Env, p *prog.Prog, needCover, minimized, candidate bool, stat *uint64) []ipc.CallInfo {
+func execute(pid int, env *ipc.Env, p *prog.Prog, needCover, minimized bool, candidate uint64, stat *uint64) []ipc.CallInfo {
 	info := execute1(pid, env, p, stat, needCover)
+	handleResult(pid, p, info, minimized, candidate)
+	return info
+}
+
+// executeBatch executes fresh generated/mutated programs in a single executor request.
+// seeds[i] is the seed progs[i] was mutated from (nil for generated programs).
+// If prefix is not 0, all progs start with the same prefix calls (see ipc.Env.ExecPrefix).
+// Programs after a hanged one are not executed and are dropped.
+func executeBatch(pid int, env *ipc.Env, progs []*prog.Prog, seeds []*seed, prefix int, stats []*uint64) {
+	infos := execute1Batch(pid, env, progs, prefix, stats, false)
+	for i, info := range infos {
+		found := handleResult(pid, progs[i], info, false, 0)
+		if seeds[i] == nil {
+			continue
+		}
+		atomic.AddUint64(&statSchedMutate, 1)
+		if found {
+			atomic.AddUint64(&statSchedFound, 1)
+		}
+		sched.noteMutation(seeds[i], found)
+	}
+}
+
+// mutateTails mutates tails of a seed program after a random prefix of it.
+// Returns nil if the chosen seed is not suitable.
+func mutateTails(rnd *rand.Rand, rs rand.Source, ct *prog.ChoiceTable) ([]*prog.Prog, *seed, int) {
+	s := sched.choose(rnd)
+	if s == nil || len(s.p.Calls) < 2 {
+		return nil, nil, 0
+	}
+	prefix := 1 + rnd.Intn(len(s.p.Calls)-1)
+	corpusMu.RLock()
+	defer corpusMu.RUnlock()
+	var progs []*prog.Prog
+	for i := 0; i < *flagBatch; i++ {
+		p := s.p.Clone()
+		p.MutateTail(rs, programLength, ct, corpus, prefix)
+		progs = append(progs, p)
+	}
+	return progs, s, prefix
+}
+
+// handleResult queues calls of p that produced new signal for triage.
+// candidate is the manager ID of p if p is a candidate, or 0 otherwise.
+// Returns true if any call produced new signal.
+func handleResult(pid int, p *prog.Prog, info []ipc.CallInfo, minimized bool, candidate uint64) bool {
 	signalMu.RLock()
-	defer signalMu.RUnlock()
 
+	// Signal of a candidate can be already in max signal if the candidate was handed out
+	// to a fuzzer that was lost, so check candidates against corpus signal.
+	base := maxSignal
+	if candidate != 0 {
+		base = corpusSignal
+	}
+	newSignalCalls := make([]bool, len(p.Calls))
+	var newInputs []Input
 	for i, inf := range info {
-		if !cover.SignalNew(maxSignal, inf.Signal) {
+		if !cover.SignalNew(base, inf.Signal) {
 			continue
 		}
+		newSignalCalls[i] = true
 		diff := cover.SignalDiff(maxSignal, inf.Signal)
+		if *flagErrnoSignal && inf.Finished && len(diff) != 0 &&
+			diff[len(diff)-1] == cover.ErrnoSignal(p.Calls[i].Meta.ID, inf.Errno) {
+			atomic.AddUint64(&statErrnoSignal, 1)
+		}
 
 		signalMu.RUnlock()
 		signalMu.Lock()
@@ -553,26 +977,225 @@ func execute(pid int, env *ipc.Env, p *prog.Prog, needCover, minimized, candidat
 		signalMu.Unlock()
 		signalMu.RLock()
 
-		inp := Input{
+		newInputs = append(newInputs, Input{
+			id:        candidate,
 			p:         p.Clone(),
 			call:      i,
 			signal:    append([]uint32{}, inf.Signal...),
 			minimized: minimized,
+		})
+	}
+	signalMu.RUnlock()
+
+	if len(newInputs) != 0 {
+		if candidate == 0 {
+			registerTriage(newInputs)
 		}
 		triageMu.Lock()
-		if candidate {
-			triageCandidate = append(triageCandidate, inp)
-		} else {
-			triage = append(triage, inp)
+		for _, inp := range newInputs {
+			workStart(inp.id)
+			if candidate != 0 {
+				triageCandidate = append(triageCandidate, inp)
+			} else {
+				triage = append(triage, inp)
+			}
 		}
 		triageMu.Unlock()
 	}
-	return info
+	noteDescStats(pid, p, newSignalCalls)
+	noteCallStats(p, info, newSignalCalls)
+	for _, found := range newSignalCalls {
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+// noteDescStats accounts execution of p by proc pid in per-description stats.
+func noteDescStats(pid int, p *prog.Prog, newSignalCalls []bool) {
+	ds := descStats[pid]
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	for i, c := range p.Calls {
+		newSig := newSignalCalls[i]
+		ds.stats[c.Meta.Name] = addDescExec(ds.stats[c.Meta.Name], newSig)
+		for k := range ds.seen {
+			delete(ds.seen, k)
+		}
+		prog.ForeachUsedField(c, func(parent, field sys.Type) {
+			k := fieldKey{parent, field}
+			if !ds.seen[k] {
+				ds.seen[k] = true
+				ds.fields[k] = addDescExec(ds.fields[k], newSig)
+			}
+		})
+	}
+}
+
+func addDescExec(st DescStat, newSig bool) DescStat {
+	st.Execs++
+	if newSig {
+		st.NewSignal++
+	}
+	return st
+}
+
+// takeDescStats returns per-description stats of all procs since last poll
+// keyed by call name or "struct.field" (see prog.UsedFields).
+func takeDescStats() map[string]DescStat {
+	res := make(map[string]DescStat)
+	merge := func(name string, st DescStat) {
+		total := res[name]
+		total.Execs += st.Execs
+		total.NewSignal += st.NewSignal
+		res[name] = total
+	}
+	for _, ds := range descStats {
+		fresh := newProcDescStats()
+		ds.mu.Lock()
+		stats, fields := ds.stats, ds.fields
+		ds.stats, ds.fields = fresh.stats, fresh.fields
+		ds.mu.Unlock()
+		for name, st := range stats {
+			merge(name, st)
+		}
+		for k, st := range fields {
+			merge(k.parent.Name()+"."+k.field.FieldName(), st)
+		}
+	}
+	return res
+}
+
+type blockedStat struct {
+	execs   uint64
+	blocked uint64
+}
+
+// noteCallStats accounts execution times and results of calls of p.
+func noteCallStats(p *prog.Prog, info []ipc.CallInfo, newSignalCalls []bool) {
+	callStatsMu.Lock()
+	defer callStatsMu.Unlock()
+	for i, inf := range info {
+		if !inf.Finished && !inf.Blocked {
+			// The call was not executed.
+			continue
+		}
+		c := p.Calls[i].Meta
+		cs := callStats[c.Name]
+		cs.Add(inf.Time, inf.Errno, inf.Blocked, inf.Finished, i < len(newSignalCalls) && newSignalCalls[i])
+		callStats[c.Name] = cs
+		st := callBlocked[c]
+		if st == nil {
+			st = new(blockedStat)
+			callBlocked[c] = st
+		}
+		st.execs++
+		if inf.Blocked || !inf.Finished {
+			st.blocked++
+		}
+	}
+}
+
+// noteHang accounts a hang of program p that was killed to the call that hanged it.
+func noteHang(p *prog.Prog, info []ipc.CallInfo) {
+	idx := hangedCall(p, info)
+	if idx == -1 {
+		return
+	}
+	callStatsMu.Lock()
+	callHangs[p.Calls[idx].Meta.Name]++
+	callStatsMu.Unlock()
+}
+
+// hangedCall returns index of the call that most likely hanged program p given its partial info:
+// the first call that did not finish (it was executing when the program was killed,
+// or it was still running when the program ended), or the last call if all calls finished.
+// Returns -1 for an empty program.
+func hangedCall(p *prog.Prog, info []ipc.CallInfo) int {
+	if len(info) == len(p.Calls) {
+		for i, inf := range info {
+			if !inf.Finished {
+				return i
+			}
+		}
+	}
+	return len(p.Calls) - 1
+}
+
+// setQuarantined sets calls quarantined by manager, returns true if the set has changed.
+func setQuarantined(names []string) bool {
+	changed := len(names) != len(quarantined)
+	for _, name := range names {
+		if !quarantined[name] {
+			changed = true
+		}
+	}
+	if !changed {
+		return false
+	}
+	Logf(0, "quarantined calls: %v", names)
+	quarantined = make(map[string]bool)
+	for _, name := range names {
+		quarantined[name] = true
+	}
+	return true
+}
+
+// buildChoiceTable builds choice table without quarantined calls and with priorities
+// of calls that chronically block reduced, blocking calls waste time and make executor wait for them.
+func buildChoiceTable(prios [][]float32, calls map[*sys.Call]bool) *prog.ChoiceTable {
+	if len(quarantined) != 0 {
+		calls1 := make(map[*sys.Call]bool)
+		for c := range calls {
+			if !quarantined[c.Name] {
+				calls1[c] = true
+			}
+		}
+		// Don't leave the fuzzer without calls.
+		if len(calls1) != 0 {
+			calls = calls1
+		}
+	}
+	var blocked []*sys.Call
+	callStatsMu.Lock()
+	for c, st := range callBlocked {
+		if st.execs >= blockedMinExecs && st.blocked*2 > st.execs {
+			blocked = append(blocked, c)
+		}
+	}
+	callStatsMu.Unlock()
+	if len(blocked) == 0 {
+		return prog.BuildChoiceTable(prios, calls)
+	}
+	Logf(0, "deprioritizing %v chronically blocking calls", len(blocked))
+	prios1 := make([][]float32, len(prios))
+	for i := range prios {
+		prios1[i] = append([]float32{}, prios[i]...)
+		for _, c := range blocked {
+			prios1[i][c.ID] *= blockedPenalty
+		}
+	}
+	return prog.BuildChoiceTable(prios1, calls)
+}
+
+func choiceTable() *prog.ChoiceTable {
+	ctMu.RLock()
+	defer ctMu.RUnlock()
+	return ct
 }
 
 var logMu sync.Mutex
 
 func execute1(pid int, env *ipc.Env, p *prog.Prog, stat *uint64, needCover bool) []ipc.CallInfo {
+	infos := execute1Batch(pid, env, []*prog.Prog{p}, 0, []*uint64{stat}, needCover)
+	if len(infos) == 0 {
+		return nil
+	}
+	return infos[0]
+}
+
+func execute1Batch(pid int, env *ipc.Env, progs []*prog.Prog, prefix int, stats []*uint64, needCover bool) [][]ipc.CallInfo {
 	if false {
 		// For debugging, this function must not be executed with locks held.
 		corpusMu.Lock()
@@ -586,45 +1209,90 @@ func execute1(pid int, env *ipc.Env, p *prog.Prog, stat *uint64, needCover bool)
 	// Limit concurrency window and do leak checking once in a while.
 	idx := gate.Enter()
 	defer gate.Leave(idx)
+	if *flagLeak {
+		noteLeakWindow(pid, progs)
+	}
 
 	// The following output helps to understand what program crashed kernel.
 	// It must not be intermixed.
+	config := ipc.FlagsString(env.Flags())
+	noteRecent(pid, config, progs)
 	switch *flagOutput {
 	case "none":
 		// This case intentionally left blank.
 	case "stdout":
-		data := p.Serialize()
 		logMu.Lock()
-		Logf(0, "executing program %v:\n%s", pid, data)
+		for _, p := range progs {
+			Logf(0, "executing program %v (%v):\n%s", pid, config, p.Serialize())
+		}
 		logMu.Unlock()
 	case "dmesg":
 		fd, err := syscall.Open("/dev/kmsg", syscall.O_WRONLY, 0)
 		if err == nil {
-			buf := new(bytes.Buffer)
-			fmt.Fprintf(buf, "syzkaller: executing program %v:\n%s", pid, p.Serialize())
-			syscall.Write(fd, buf.Bytes())
+			for _, p := range progs {
+				buf := new(bytes.Buffer)
+				fmt.Fprintf(buf, "syzkaller: executing program %v (%v):\n%s", pid, config, p.Serialize())
+				syscall.Write(fd, buf.Bytes())
+			}
 			syscall.Close(fd)
 		}
 	case "file":
 		f, err := os.Create(fmt.Sprintf("%v-%v.prog", *flagName, pid))
 		if err == nil {
-			f.Write(p.Serialize())
+			for _, p := range progs {
+				f.Write(p.Serialize())
+			}
 			f.Close()
 		}
 	}
 
 	try := 0
 retry:
-	atomic.AddUint64(stat, 1)
-	output, info, failed, hanged, err := env.Exec(p, needCover, true)
+	var output []byte
+	var infos [][]ipc.CallInfo
+	var failed, hanged bool
+	var killed int
+	var err error
+	if prefix != 0 {
+		output, infos, failed, hanged, killed, err = env.ExecPrefix(progs, prefix, needCover, true)
+	} else {
+		output, infos, failed, hanged, killed, err = env.ExecBatch(progs, needCover, true)
+	}
+	executed := len(progs)
+	if killed != -1 {
+		// Programs after the killed one were not executed.
+		executed = killed + 1
+	}
+	for _, stat := range stats[:executed] {
+		atomic.AddUint64(stat, 1)
+	}
 	if failed {
 		// BUG in output should be recognized by manager.
 		Logf(0, "BUG: executor-detected bug:\n%s", output)
 		// Don't return any cover so that the input is not added to corpus.
 		return nil
 	}
+	if killed != -1 {
+		// The program hanged and was killed by executor watchdog or,
+		// if hanged is set, executor hanged as well and was killed by ipc.
+		noteHang(progs[killed], infos[killed])
+	}
+	if hanged {
+		// Don't retry, the same programs will most likely hang again.
+		Logf(1, "program hanged: %v", err)
+		return nil
+	}
 	if err != nil {
-		if _, ok := err.(ipc.ExecutorFailure); ok || try > 10 {
+		if reason := ipc.FailureReason(err); reason != "" {
+			// The test environment is broken, but it may be a temporal problem,
+			// so count it and retry with a new executor.
+			failuresMu.Lock()
+			executorFailures[reason]++
+			failuresMu.Unlock()
+			if try > 10 {
+				executorFailed(reason, err)
+			}
+		} else if _, ok := err.(ipc.ExecutorFailure); ok || try > 10 {
 			panic(err)
 		}
 		try++
@@ -634,78 +1302,30 @@ retry:
 		goto retry
 	}
 	Logf(2, "result failed=%v hanged=%v: %v\n", failed, hanged, string(output))
-	return info
-}
-
-func kmemleakInit() {
-	fd, err := syscall.Open("/sys/kernel/debug/kmemleak", syscall.O_RDWR, 0)
-	if err != nil {
-		if *flagLeak {
-			Fatalf("BUG: /sys/kernel/debug/kmemleak is missing (%v). Enable CONFIG_KMEMLEAK and mount debugfs.", err)
-		} else {
-			return
-		}
-	}
-	defer syscall.Close(fd)
-	what := "scan=off"
-	if !*flagLeak {
-		what = "off"
-	}
-	if _, err := syscall.Write(fd, []byte(what)); err != nil {
-		// kmemleak returns EBUSY when kmemleak is already turned off.
-		if err != syscall.EBUSY {
-			panic(err)
+	if *flagErrnoSignal {
+		for i, info := range infos {
+			addErrnoSignal(progs[i], info)
 		}
 	}
+	return infos
 }
 
-var kmemleakBuf []byte
+// executorFailed exits on a persistent executor setup failure.
+// Manager recognizes the message and counts the failure in stats
+// instead of reporting it as a crash.
+func executorFailed(reason string, err error) {
+	Fatalf("SYZ-FUZZER: EXECUTOR FAILURE (%v): %v", reason, err)
+}
 
-func kmemleakScan(report bool) {
-	fd, err := syscall.Open("/sys/kernel/debug/kmemleak", syscall.O_RDWR, 0)
-	if err != nil {
-		panic(err)
-	}
-	defer syscall.Close(fd)
-	// Kmemleak has false positives. To mitigate most of them, it checksums
-	// potentially leaked objects, and reports them only on the next scan
-	// iff the checksum does not change. Because of that we do the following
-	// intricate dance:
-	// Scan, sleep, scan again. At this point we can get some leaks.
-	// If there are leaks, we sleep and scan again, this can remove
-	// false leaks. Then, read kmemleak again. If we get leaks now, then
-	// hopefully these are true positives during the previous testing cycle.
-	if _, err := syscall.Write(fd, []byte("scan")); err != nil {
-		panic(err)
-	}
-	time.Sleep(time.Second)
-	if _, err := syscall.Write(fd, []byte("scan")); err != nil {
-		panic(err)
-	}
-	if report {
-		if kmemleakBuf == nil {
-			kmemleakBuf = make([]byte, 128<<10)
-		}
-		n, err := syscall.Read(fd, kmemleakBuf)
-		if err != nil {
-			panic(err)
-		}
-		if n != 0 {
-			time.Sleep(time.Second)
-			if _, err := syscall.Write(fd, []byte("scan")); err != nil {
-				panic(err)
-			}
-			n, err := syscall.Read(fd, kmemleakBuf)
-			if err != nil {
-				panic(err)
-			}
-			if n != 0 {
-				// BUG in output should be recognized by manager.
-				Logf(0, "BUG: memory leak:\n%s\n", kmemleakBuf[:n])
-			}
+// addErrnoSignal appends (syscall, errno) signal to signal of every finished call.
+// Signal slices point into executor output and have limited capacity,
+// so append copies them.
+func addErrnoSignal(p *prog.Prog, info []ipc.CallInfo) {
+	for i := range info {
+		inf := &info[i]
+		if !inf.Finished {
+			continue
 		}
-	}
-	if _, err := syscall.Write(fd, []byte("clear")); err != nil {
-		panic(err)
+		inf.Signal = append(inf.Signal, cover.ErrnoSignal(p.Calls[i].Meta.ID, inf.Errno))
 	}
 }
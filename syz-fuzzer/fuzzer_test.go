@@ -0,0 +1,353 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math/rand"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/syzkaller/cover"
+	"github.com/google/syzkaller/ipc"
+	"github.com/google/syzkaller/ipc/fakeexec"
+	"github.com/google/syzkaller/prog"
+	. "github.com/google/syzkaller/rpctype"
+	"github.com/google/syzkaller/sys"
+)
+
+func TestMain(m *testing.M) {
+	fakeexec.Main()
+	os.Exit(m.Run())
+}
+
+// Manager is a fake manager that records new inputs and recent programs
+// (the type name is used as rpc service name).
+type Manager struct {
+	mu     sync.Mutex
+	inputs []RpcInput
+	recent []RecentProg
+}
+
+func newManager() *Manager {
+	return &Manager{}
+}
+
+func (mgr *Manager) NewInput(a *NewInputArgs, r *int) error {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+	mgr.inputs = append(mgr.inputs, a.RpcInput)
+	return nil
+}
+
+func (mgr *Manager) Recent(a *RecentArgs, r *int) error {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+	mgr.recent = append(mgr.recent, a.Progs...)
+	return nil
+}
+
+// startFuzzer starts fake executor with the script and a fake manager,
+// and initializes fuzzer state.
+func startFuzzer(t *testing.T, script *fakeexec.Script) (*Manager, *ipc.Env, func()) {
+	bin, cleanup, err := fakeexec.Command(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mgr := newManager()
+	serv, err := NewRpcServer("localhost:0", mgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go serv.Serve()
+	manager, err = NewRpcClient(serv.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	initState()
+	*flagOutput = "none"
+	calls := make(map[*sys.Call]bool)
+	for _, name := range []string{"getpid", "getuid", "getgid", "sched_yield"} {
+		calls[sys.CallMap[name]] = true
+	}
+	ct = buildChoiceTable(prog.CalculatePriorities(nil), calls)
+	gate = ipc.NewGate(2, nil)
+	env, err := ipc.MakeEnv(bin, time.Minute, ipc.FlagSignal, 0)
+	if err != nil {
+		t.Fatalf("failed to create env: %v", err)
+	}
+	return mgr, env, func() {
+		env.Close()
+		manager.Close()
+		cleanup()
+	}
+}
+
+// TestFuzzLoop runs the fuzzing loop with the fake executor
+// and checks that the fuzzer finds and triages all signal.
+func TestFuzzLoop(t *testing.T) {
+	script := &fakeexec.Script{
+		Calls: map[string]*fakeexec.Call{
+			"getpid":      {Signal: []uint32{1}, Cover: []uint32{100}},
+			"getuid":      {Signal: []uint32{2}, Cover: []uint32{200}},
+			"sched_yield": {Signal: []uint32{3, 4}, Cover: []uint32{300}, Errno: 22},
+		},
+	}
+	mgr, env, cleanup := startFuzzer(t, script)
+	defer cleanup()
+
+	proc(0, env, make(chan struct{}, 1), 50)
+
+	want := []uint32{1, 2, 3, 4}
+	if got := sortedSignal(maxSignal); !equalSignal(got, want) {
+		t.Fatalf("max signal %v, want %v", got, want)
+	}
+	if got := sortedSignal(corpusSignal); !equalSignal(got, want) {
+		t.Fatalf("corpus signal %v, want %v", got, want)
+	}
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+	inputSignal := make(map[uint32]struct{})
+	for _, inp := range mgr.inputs {
+		p, err := prog.Deserialize(inp.Prog)
+		if err != nil {
+			t.Fatalf("manager got bad program: %v\n%s", err, inp.Prog)
+		}
+		if len(p.Calls) != 1 {
+			t.Fatalf("input is not minimized:\n%s", inp.Prog)
+		}
+		if len(inp.Cover) != 1 {
+			t.Fatalf("input for %v has cover %v", inp.Call, inp.Cover)
+		}
+		cover.SignalAdd(inputSignal, inp.Signal)
+	}
+	if got := sortedSignal(inputSignal); !equalSignal(got, want) {
+		t.Fatalf("manager got signal %v, want %v", got, want)
+	}
+	// All inputs were triaged before the next poll, so there is nothing to register or acknowledge.
+	if len(work) != 0 || len(triagePending) != 0 || len(triageIDs) != 0 || len(triageDoneIDs) != 0 {
+		t.Fatalf("untriaged inputs are left: work %v, pending %v, registered %v, acknowledged %v",
+			work, triagePending, triageIDs, triageDoneIDs)
+	}
+}
+
+// TestTriageRegistration checks that inputs found by the fuzzer are registered with manager
+// on poll only if they are not triaged yet, and that registered inputs are acknowledged.
+func TestTriageRegistration(t *testing.T) {
+	_, _, cleanup := startFuzzer(t, &fakeexec.Script{})
+	defer cleanup()
+
+	p, err := prog.Deserialize([]byte("getpid()\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputs := make([]Input, 4)
+	for i := range inputs {
+		inputs[i] = Input{p: p, signal: []uint32{uint32(i)}}
+	}
+	registerTriage(inputs)
+	for _, inp := range inputs {
+		if inp.id&localTriageID == 0 {
+			t.Fatalf("input got non-local ID %x", inp.id)
+		}
+		workStart(inp.id)
+	}
+	// Triaged before poll: not registered.
+	workDone(inputs[0].id)
+	sent, ids := takeTriage()
+	if len(sent) != 3 || len(ids) != 3 {
+		t.Fatalf("registering %v inputs, want 3", len(sent))
+	}
+	mgrIDs := make([]uint64, len(ids))
+	for i, id := range ids {
+		mgrIDs[i] = id &^ localTriageID
+	}
+	// Triaged while poll is in flight: acknowledged when manager ID is known.
+	workDone(inputs[1].id)
+	triageRegistered(ids, mgrIDs)
+	// Triaged after poll.
+	workDone(inputs[2].id)
+	workDone(inputs[3].id)
+
+	if sent, _ := takeTriage(); len(sent) != 0 {
+		t.Fatalf("registering %v inputs again", len(sent))
+	}
+	acked := takeTriageDone()
+	done := make(map[uint64]bool)
+	for _, id := range acked {
+		done[id] = true
+	}
+	if len(acked) != 3 || len(done) != 3 || done[inputs[0].id&^localTriageID] {
+		t.Fatalf("acknowledged inputs %v, want all but %v", acked, inputs[0].id&^localTriageID)
+	}
+	if acked := takeTriageDone(); len(acked) != 0 {
+		t.Fatalf("acknowledged inputs %v again", acked)
+	}
+	if len(work) != 0 || len(triagePending) != 0 || len(triageIDs) != 0 {
+		t.Fatalf("untriaged inputs are left: work %v, pending %v, registered %v", work, triagePending, triageIDs)
+	}
+}
+
+// TestCandidateRequeued checks that a candidate handed out again after its fuzzer was lost
+// is triaged even though its signal is already in max signal, and that it is acknowledged.
+func TestCandidateRequeued(t *testing.T) {
+	script := &fakeexec.Script{
+		Calls: map[string]*fakeexec.Call{
+			"getpid": {Signal: []uint32{1}, Cover: []uint32{100}},
+		},
+	}
+	mgr, env, cleanup := startFuzzer(t, script)
+	defer cleanup()
+
+	maxSignal[1] = struct{}{}
+	addCandidates([]RpcCandidate{
+		{ID: 1, Prog: []byte("getpid()\n"), Minimized: true},
+		{ID: 2, Prog: []byte("getpid()\n"), Signal: []uint32{1}},
+	})
+	proc(0, env, make(chan struct{}, 1), 3)
+
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+	if len(mgr.inputs) != 1 {
+		t.Fatalf("manager got %v inputs, want 1", len(mgr.inputs))
+	}
+	if got := sortedSignal(corpusSignal); !equalSignal(got, []uint32{1}) {
+		t.Fatalf("corpus signal %v, want [1]", got)
+	}
+	if acked := takeTriageDone(); len(acked) != 2 || acked[0]+acked[1] != 3 {
+		t.Fatalf("acknowledged inputs %v, want [1 2]", acked)
+	}
+}
+
+func sortedSignal(signal map[uint32]struct{}) []uint32 {
+	var res []uint32
+	for s := range signal {
+		res = append(res, s)
+	}
+	return cover.Canonicalize(res)
+}
+
+func equalSignal(a, b []uint32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// TestHangAttribution checks that programs killed by executor are accounted as hanged.
+func TestHangAttribution(t *testing.T) {
+	script := &fakeexec.Script{
+		Calls: map[string]*fakeexec.Call{
+			"pause": {Kill: true},
+		},
+	}
+	_, env, cleanup := startFuzzer(t, script)
+	defer cleanup()
+	var progs []*prog.Prog
+	for _, src := range []string{"getpid()\n", "getpid()\npause()\ngetuid()\n", "getgid()\n"} {
+		p, err := prog.Deserialize([]byte(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		progs = append(progs, p)
+	}
+	var stats [3]uint64
+	infos := execute1Batch(0, env, progs, 0, []*uint64{&stats[0], &stats[1], &stats[2]}, false)
+	if len(infos) != 2 {
+		t.Fatalf("got info for %v programs, want 2", len(infos))
+	}
+	if stats != [3]uint64{1, 1, 0} {
+		t.Fatalf("got stats %v, want only executed programs counted", stats)
+	}
+	// The hang is attributed only to the call that hanged.
+	if len(callHangs) != 1 || callHangs["pause"] != 1 {
+		t.Fatalf("got hangs %v", callHangs)
+	}
+}
+
+func TestHangedCall(t *testing.T) {
+	p, err := prog.Deserialize([]byte("getpid()\npause()\ngetuid()\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := ipc.CallInfo{Finished: true}
+	notDone := ipc.CallInfo{Errno: -1}
+	blocked := ipc.CallInfo{Errno: -1, Blocked: true}
+	tests := []struct {
+		info []ipc.CallInfo
+		call int
+	}{
+		{[]ipc.CallInfo{done, notDone, notDone}, 1},
+		{[]ipc.CallInfo{done, blocked, done}, 1},
+		{[]ipc.CallInfo{notDone, notDone, notDone}, 0},
+		{[]ipc.CallInfo{done, done, done}, 2},
+		{nil, 2},
+	}
+	for i, test := range tests {
+		if call := hangedCall(p, test.info); call != test.call {
+			t.Errorf("#%v: got call %v, want %v", i, call, test.call)
+		}
+	}
+	if call := hangedCall(new(prog.Prog), nil); call != -1 {
+		t.Errorf("got call %v for empty program", call)
+	}
+}
+
+func TestDescStats(t *testing.T) {
+	initState()
+	p, err := prog.Deserialize([]byte("syz_test$union0(&(0x7f0000000000)={0x1, @f2=0x2})\n" +
+		"syz_test$align6(&(0x7f0000000000)={0x0, [0x1, 0x2]})\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	noteDescStats(0, p, []bool{true, false})
+	noteDescStats(0, p, []bool{false, false})
+	got := takeDescStats()
+	want := map[string]DescStat{
+		"syz_test$union0":     {Execs: 2, NewSignal: 1},
+		"syz_union0_struct.f": {Execs: 2, NewSignal: 1},
+		"syz_union0_struct.u": {Execs: 2, NewSignal: 1},
+		"syz_union0.f2":       {Execs: 2, NewSignal: 1},
+		"syz_test$align6":     {Execs: 2},
+		"syz_align6.f1":       {Execs: 2},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got stats %+v, want %+v", got, want)
+	}
+	if got := takeDescStats(); len(got) != 0 {
+		t.Fatalf("got stats %+v after take", got)
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	defer setQuarantined(nil)
+	calls := make(map[*sys.Call]bool)
+	for _, name := range []string{"getpid", "getuid", "pause"} {
+		calls[sys.CallMap[name]] = true
+	}
+	if !setQuarantined([]string{"pause"}) {
+		t.Fatalf("quarantine did not change")
+	}
+	if setQuarantined([]string{"pause"}) {
+		t.Fatalf("the same quarantine changed")
+	}
+	ct := buildChoiceTable(prog.CalculatePriorities(nil), calls)
+	rs := rand.NewSource(0)
+	for i := 0; i < 100; i++ {
+		p := prog.Generate(rs, 10, ct)
+		for _, c := range p.Calls {
+			if c.Meta.Name == "pause" {
+				t.Fatalf("generated quarantined call:\n%s", p.Serialize())
+			}
+		}
+	}
+}
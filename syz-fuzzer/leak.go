@@ -0,0 +1,137 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/syzkaller/hash"
+	"github.com/google/syzkaller/host"
+	"github.com/google/syzkaller/ipc"
+	. "github.com/google/syzkaller/log"
+	"github.com/google/syzkaller/prog"
+)
+
+// Leak hunting attributes kmemleak reports to programs.
+// Kmemleak is scanned once per gate window, so a leak can be caused by any program
+// executed in the window. When a scan detects a leak, we re-execute programs
+// of the window one-by-one (all procs are stopped by the gate) and scan after each.
+// The first program that leaks on its own is printed right before the leak report,
+// so that the crash log contains the guilty program and repro can minimize it.
+
+const (
+	leakWindowMax = 500                 // max programs remembered since the last scan
+	leakHuntMax   = 30                  // max programs re-executed in isolation when hunting a leak
+	leakMinAge    = host.KmemleakMinAge // kmemleak does not report objects younger than this
+)
+
+type leakProg struct {
+	pid int
+	p   *prog.Prog
+}
+
+var (
+	leakMu     sync.Mutex
+	leakWindow []leakProg // programs executed since the last scan
+)
+
+// noteLeakWindow remembers programs executed by proc pid in the current gate window.
+func noteLeakWindow(pid int, progs []*prog.Prog) {
+	leakMu.Lock()
+	defer leakMu.Unlock()
+	for _, p := range progs {
+		leakWindow = append(leakWindow, leakProg{pid, p})
+	}
+	if len(leakWindow) > leakWindowMax {
+		leakWindow = leakWindow[len(leakWindow)-leakWindowMax:]
+	}
+}
+
+// leakCheck scans for leaks and hunts for the guilty program.
+// It is called by gate when all procs are stopped, envs are not used by anybody else.
+func leakCheck(envs []*ipc.Env) {
+	leakMu.Lock()
+	window := leakWindow
+	leakWindow = nil
+	leakMu.Unlock()
+	if atomic.LoadUint32(&allTriaged) == 0 {
+		return
+	}
+	// Scan for leaks once in a while (it is damn slow).
+	leaks := kmemleakScan(true)
+	if leaks == nil {
+		return
+	}
+	suspects := leakSuspects(window)
+	Logf(0, "detected memory leak, hunting for the guilty program among %v programs", len(suspects))
+	// Programs must be printed regardless of -output, otherwise they don't get into crash log.
+	logProg := func(lp *leakProg) {
+		Logf(0, "executing program %v (%v):\n%s", lp.pid, ipc.FlagsString(envs[lp.pid].Flags()), lp.p.Serialize())
+	}
+	if guilty, guiltyLeaks := huntLeak(envs, suspects); guilty != nil {
+		logProg(guilty)
+		leaks = guiltyLeaks
+	} else {
+		Logf(0, "failed to attribute memory leak to a single program")
+		for i := len(suspects) - 1; i >= 0; i-- {
+			logProg(&suspects[i])
+		}
+	}
+	// BUG in output should be recognized by manager.
+	Logf(0, "BUG: memory leak:\n%s\n", leaks)
+}
+
+// leakSuspects returns distinct programs of the window, most recently executed first.
+func leakSuspects(window []leakProg) []leakProg {
+	var suspects []leakProg
+	seen := make(map[hash.Sig]bool)
+	for i := len(window) - 1; i >= 0 && len(suspects) < leakHuntMax; i-- {
+		sig := hash.Hash(window[i].p.Serialize())
+		if seen[sig] {
+			continue
+		}
+		seen[sig] = true
+		suspects = append(suspects, window[i])
+	}
+	return suspects
+}
+
+// huntLeak executes suspects in isolation (each in env of the proc that executed it)
+// and returns the first one that leaks and its leak report.
+func huntLeak(envs []*ipc.Env, suspects []leakProg) (*leakProg, []byte) {
+	for i := range suspects {
+		// This also serves as keep-alive for manager, hunting can take minutes
+		// ("executed programs:" is recognized by vm.MonitorExecution).
+		// The message must not contain "executing program", otherwise prog.ParseLog
+		// takes it for a program header and repro chokes on the execution log.
+		Logf(0, "hunting memory leak, executed programs: %v/%v", i, len(suspects))
+		env := envs[suspects[i].pid]
+		if _, _, failed, hanged, err := env.Exec(suspects[i].p, false, false); failed || hanged || err != nil {
+			Logf(1, "program failed: failed=%v hanged=%v err=%v", failed, hanged, err)
+			kmemleakScan(false)
+			continue
+		}
+		time.Sleep(leakMinAge)
+		if leaks := kmemleakScan(true); leaks != nil {
+			return &suspects[i], leaks
+		}
+	}
+	return nil, nil
+}
+
+func kmemleakInit() {
+	if err := host.KmemleakInit(*flagLeak); err != nil {
+		Fatalf("BUG: %v", err)
+	}
+}
+
+func kmemleakScan(report bool) []byte {
+	leaks, err := host.KmemleakScan(report)
+	if err != nil {
+		panic(err)
+	}
+	return leaks
+}
@@ -0,0 +1,105 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sync"
+
+	"github.com/google/syzkaller/prog"
+	. "github.com/google/syzkaller/rpctype"
+)
+
+// Programs are sent to manager before execution, so that manager knows what was executed
+// right before a crash even if "executing program" lines did not make it to the console.
+// noteRecent returns only after manager has received the programs. To not make a request
+// per batch, concurrent procs share requests: a proc that finds no request in flight sends
+// everything queued so far (including programs of other procs) and wakes up the procs
+// whose programs were sent, others wait for the request in flight and then for the next one.
+
+type recentProg struct {
+	seq    uint64
+	config string
+	p      *prog.Prog
+}
+
+var (
+	recentMu      sync.Mutex
+	recentCond    = sync.NewCond(&recentMu)
+	recentSeq     uint64               // sequence number of the last queued program
+	recentSent    uint64               // all programs up to this sequence number are sent
+	recentSending bool                 // a request to manager is in flight
+	recentProgs   map[int][]recentProg // not yet sent programs per proc
+)
+
+// noteRecent sends programs that proc pid is about to execute to manager.
+func noteRecent(pid int, config string, progs []*prog.Prog) {
+	recentMu.Lock()
+	defer recentMu.Unlock()
+	seq := queueRecent(pid, config, progs)
+	for recentSent < seq {
+		if recentSending {
+			recentCond.Wait()
+			continue
+		}
+		recentSending = true
+		queued, last := takeRecent()
+		recentMu.Unlock()
+		a := &RecentArgs{
+			Name:  *flagName,
+			Progs: serializeRecent(queued),
+		}
+		if err := manager.Call("Manager.Recent", a, nil); err != nil {
+			panic(err)
+		}
+		recentMu.Lock()
+		recentSending = false
+		recentSent = last
+		recentCond.Broadcast()
+	}
+}
+
+// queueRecent queues programs of proc pid and returns sequence number of the last of them.
+// recentMu must be held.
+func queueRecent(pid int, config string, progs []*prog.Prog) uint64 {
+	if recentProgs == nil {
+		recentProgs = make(map[int][]recentProg)
+	}
+	queue := recentProgs[pid]
+	for _, p := range progs {
+		recentSeq++
+		queue = append(queue, recentProg{recentSeq, config, p})
+	}
+	// Manager keeps only the last programs anyway.
+	if len(queue) > RecentProgsPerProc {
+		queue = append([]recentProg{}, queue[len(queue)-RecentProgsPerProc:]...)
+	}
+	recentProgs[pid] = queue
+	return recentSeq
+}
+
+// takeRecent returns all queued programs and sequence number of the last of them.
+// recentMu must be held.
+func takeRecent() (map[int][]recentProg, uint64) {
+	queued := recentProgs
+	recentProgs = nil
+	return queued, recentSeq
+}
+
+// serializeRecent converts queued programs to the rpc format.
+// Procs don't modify the programs while they wait in noteRecent,
+// so it is done without holding recentMu.
+func serializeRecent(queued map[int][]recentProg) []RecentProg {
+	var res []RecentProg
+	for pid, queue := range queued {
+		for _, rp := range queue {
+			res = append(res, RecentProg{
+				Seq:    rp.seq,
+				Proc:   pid,
+				Config: rp.config,
+				Prog:   rp.p.Serialize(),
+			})
+		}
+	}
+	return res
+}
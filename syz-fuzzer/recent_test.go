@@ -0,0 +1,110 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/google/syzkaller/ipc/fakeexec"
+	"github.com/google/syzkaller/prog"
+	. "github.com/google/syzkaller/rpctype"
+)
+
+func TestRecentProgs(t *testing.T) {
+	recentMu.Lock()
+	takeRecent()
+	recentMu.Unlock()
+	p, err := prog.Deserialize([]byte("getpid()\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recentMu.Lock()
+	for i := 0; i < RecentProgsPerProc+5; i++ {
+		queueRecent(0, "none", []*prog.Prog{p})
+	}
+	queueRecent(1, "setuid,cover", []*prog.Prog{p, p})
+	queued, _ := takeRecent()
+	recentMu.Unlock()
+	progs := serializeRecent(queued)
+	if len(progs) != RecentProgsPerProc+2 {
+		t.Fatalf("got %v programs, want %v", len(progs), RecentProgsPerProc+2)
+	}
+	last := make(map[int]uint64)
+	count := make(map[int]int)
+	for _, rp := range progs {
+		if rp.Seq <= last[rp.Proc] {
+			t.Fatalf("proc %v: seq %v after %v", rp.Proc, rp.Seq, last[rp.Proc])
+		}
+		last[rp.Proc] = rp.Seq
+		count[rp.Proc]++
+		if string(rp.Prog) != "getpid()\n" {
+			t.Fatalf("bad program %q", rp.Prog)
+		}
+	}
+	if count[0] != RecentProgsPerProc || count[1] != 2 {
+		t.Fatalf("got programs per proc %v", count)
+	}
+	if last[1] <= last[0] {
+		t.Fatalf("last program of proc 1 has seq %v, proc 0 has %v", last[1], last[0])
+	}
+	recentMu.Lock()
+	queued, _ = takeRecent()
+	recentMu.Unlock()
+	if len(queued) != 0 {
+		t.Fatalf("got programs of %v procs after take", len(queued))
+	}
+}
+
+// TestRecentSent checks that noteRecent returns only after manager got the programs.
+func TestRecentSent(t *testing.T) {
+	mgr, _, cleanup := startFuzzer(t, &fakeexec.Script{})
+	defer cleanup()
+	p, err := prog.Deserialize([]byte("getpid()\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const procs = 4
+	const iters = 20
+	var wg sync.WaitGroup
+	errc := make(chan string, procs)
+	for pid := 0; pid < procs; pid++ {
+		wg.Add(1)
+		go func(pid int) {
+			defer wg.Done()
+			for i := 0; i < iters; i++ {
+				recentMu.Lock()
+				seq := recentSeq + 1
+				recentMu.Unlock()
+				noteRecent(pid, "none", []*prog.Prog{p})
+				if !mgr.gotRecent(pid, seq) {
+					errc <- "noteRecent returned before manager got the program"
+					return
+				}
+			}
+		}(pid)
+	}
+	wg.Wait()
+	close(errc)
+	for err := range errc {
+		t.Fatal(err)
+	}
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+	if len(mgr.recent) != procs*iters {
+		t.Fatalf("manager got %v programs, want %v", len(mgr.recent), procs*iters)
+	}
+}
+
+// gotRecent returns true if manager got a program of proc pid with sequence number seq or later.
+func (mgr *Manager) gotRecent(pid int, seq uint64) bool {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+	for _, rp := range mgr.recent {
+		if rp.Proc == pid && rp.Seq >= seq {
+			return true
+		}
+	}
+	return false
+}
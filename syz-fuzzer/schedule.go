@@ -0,0 +1,185 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/syzkaller/cover"
+	"github.com/google/syzkaller/prog"
+)
+
+// Seed scheduler selects corpus programs for mutation.
+// Each corpus program (seed) gets energy and seeds are selected with probability
+// proportional to energy. Energy is higher for seeds that:
+//  - have rare signal (signal that few other corpus programs have),
+//  - were recently added to corpus,
+//  - were productive (mutations of the seed found new signal),
+//  - cover PCs in or near directed fuzzing targets (if manager config has targets),
+// and lower for seeds that were mutated many times without finding new signal.
+
+const (
+	schedRecentBoost   = 3.0              // max energy multiplier for just added seeds
+	schedRecentPeriod  = 10 * time.Minute // time during which the recency boost decays by e
+	schedExecsPeriod   = 100.0            // unproductive mutations that halve energy (roughly)
+	schedRebuildPeriod = 100              // selections between energy recalculations
+	schedTargetBoost   = 2.0              // energy boost per doubling of target score
+)
+
+type seed struct {
+	p      *prog.Prog
+	signal []uint32
+	added  time.Time
+	execs  uint64  // number of executed mutations of the seed
+	found  uint64  // number of mutations of the seed that produced new signal
+	target float64 // how close seed coverage gets to targets (see cover.Targets.Score)
+}
+
+// Energy of all seeds is recalculated without holding mu (see rebuild),
+// so that procs can continue to choose seeds using the old energy in the meantime.
+// Signal counts are used only for energy calculation and are protected by a separate mutex.
+type seedScheduler struct {
+	mu         sync.Mutex
+	seeds      []*seed
+	energy     []float64     // cumulative energy of seeds
+	selections int           // since last energy recalculation
+	rebuilding bool          // energy recalculation is in progress
+	targets    cover.Targets // directed fuzzing targets, set once on start
+
+	countMu     sync.Mutex
+	signalCount map[uint32]int // number of seeds with the signal
+}
+
+func newSeedScheduler() *seedScheduler {
+	return &seedScheduler{
+		signalCount: make(map[uint32]int),
+	}
+}
+
+// add adds program p with the given signal to the set of seeds.
+// cov is coverage of p, it may contain only PCs near targets.
+func (sched *seedScheduler) add(p *prog.Prog, signal, cov []uint32) {
+	s := &seed{
+		p:      p,
+		signal: append([]uint32{}, signal...),
+		added:  time.Now(),
+		target: sched.targets.Score(cov),
+	}
+	// Recalculating energy of all seeds is expensive, so only the new seed's energy is calculated.
+	// Energy of other seeds (their signal is now less rare) is updated on the next rebuild.
+	sched.countMu.Lock()
+	for _, sig := range s.signal {
+		sched.signalCount[sig]++
+	}
+	energy := s.calcEnergy(sched.signalCount, s.added)
+	sched.countMu.Unlock()
+
+	sched.mu.Lock()
+	defer sched.mu.Unlock()
+	sched.seeds = append(sched.seeds, s)
+	total := 0.0
+	if n := len(sched.energy); n != 0 {
+		total = sched.energy[n-1]
+	}
+	sched.energy = append(sched.energy, total+energy)
+}
+
+// choose selects a seed for mutation, returns nil if there are no seeds.
+func (sched *seedScheduler) choose(rnd *rand.Rand) *seed {
+	sched.mu.Lock()
+	if len(sched.seeds) == 0 {
+		sched.mu.Unlock()
+		return nil
+	}
+	var snapshot []seed
+	if sched.selections >= schedRebuildPeriod && !sched.rebuilding {
+		snapshot = sched.startRebuild()
+	}
+	sched.selections++
+	total := sched.energy[len(sched.energy)-1]
+	idx := sort.SearchFloat64s(sched.energy, rnd.Float64()*total)
+	if idx >= len(sched.seeds) {
+		idx = len(sched.seeds) - 1
+	}
+	s := sched.seeds[idx]
+	sched.mu.Unlock()
+	if snapshot != nil {
+		sched.finishRebuild(snapshot)
+	}
+	return s
+}
+
+// noteMutation records execution of a mutation of seed s, found says if it produced new signal.
+// The result affects energy of the seed on the next rebuild.
+func (sched *seedScheduler) noteMutation(s *seed, found bool) {
+	sched.mu.Lock()
+	defer sched.mu.Unlock()
+	s.execs++
+	if found {
+		s.found++
+	}
+}
+
+// rebuild recalculates energy of all seeds.
+func (sched *seedScheduler) rebuild() {
+	sched.mu.Lock()
+	if sched.rebuilding {
+		sched.mu.Unlock()
+		return
+	}
+	snapshot := sched.startRebuild()
+	sched.mu.Unlock()
+	sched.finishRebuild(snapshot)
+}
+
+// startRebuild starts energy recalculation and returns copies of seeds to calculate energy for
+// (execs and found of seeds change concurrently). mu must be held.
+func (sched *seedScheduler) startRebuild() []seed {
+	sched.rebuilding = true
+	snapshot := make([]seed, len(sched.seeds))
+	for i, s := range sched.seeds {
+		snapshot[i] = *s
+	}
+	return snapshot
+}
+
+// finishRebuild calculates energy of the snapshot seeds without holding mu and installs it.
+func (sched *seedScheduler) finishRebuild(snapshot []seed) {
+	now := time.Now()
+	energy := make([]float64, 0, len(snapshot))
+	total := 0.0
+	sched.countMu.Lock()
+	for i := range snapshot {
+		total += snapshot[i].calcEnergy(sched.signalCount, now)
+		energy = append(energy, total)
+	}
+	sched.countMu.Unlock()
+
+	sched.mu.Lock()
+	defer sched.mu.Unlock()
+	// Seeds added during recalculation keep energy calculated in add.
+	for i := len(snapshot); i < len(sched.seeds); i++ {
+		total += sched.energy[i] - sched.energy[i-1]
+		energy = append(energy, total)
+	}
+	sched.energy = energy
+	sched.selections = 0
+	sched.rebuilding = false
+}
+
+func (s *seed) calcEnergy(signalCount map[uint32]int, now time.Time) float64 {
+	energy := 1.0
+	for _, sig := range s.signal {
+		energy += 1 / float64(signalCount[sig])
+	}
+	age := float64(now.Sub(s.added)) / float64(schedRecentPeriod)
+	energy *= 1 + schedRecentBoost*math.Exp(-age)
+	energy *= 1 + schedTargetBoost*math.Log2(1+s.target)
+	energy *= (1 + math.Log2(1+float64(s.found))) / (1 + math.Log2(1+float64(s.execs)/schedExecsPeriod))
+	return energy
+}
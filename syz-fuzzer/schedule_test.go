@@ -0,0 +1,140 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/google/syzkaller/cover"
+	"github.com/google/syzkaller/prog"
+)
+
+func TestSeedSchedulerEmpty(t *testing.T) {
+	sched := newSeedScheduler()
+	if s := sched.choose(rand.New(rand.NewSource(0))); s != nil {
+		t.Fatalf("empty scheduler returned a seed")
+	}
+}
+
+func TestSeedSchedulerEnergy(t *testing.T) {
+	rs := rand.NewSource(0)
+	rnd := rand.New(rs)
+	ct := prog.BuildChoiceTable(prog.CalculatePriorities(nil), nil)
+	sched := newSeedScheduler()
+	// Seeds with the same common signal, the last one also has rare signal.
+	const common = 10
+	var seeds []*seed
+	for i := 0; i < common; i++ {
+		sched.add(prog.Generate(rs, 3, ct), []uint32{1, 2, 3}, nil)
+		seeds = append(seeds, sched.seeds[i])
+	}
+	sched.add(prog.Generate(rs, 3, ct), []uint32{1, 2, 3, 4, 5, 6}, nil)
+	rare := sched.seeds[common]
+	// Make all seeds old, so that recency does not affect selection.
+	for _, s := range sched.seeds {
+		s.added = time.Now().Add(-100 * schedRecentPeriod)
+	}
+	sched.rebuild()
+
+	const iters = 10000
+	counts := make(map[*seed]int)
+	for i := 0; i < iters; i++ {
+		s := sched.choose(rnd)
+		sched.noteMutation(s, false)
+		counts[s]++
+	}
+	if counts[rare] <= counts[seeds[0]]*2 {
+		t.Fatalf("seed with rare signal is selected %v times, seed with common signal %v times",
+			counts[rare], counts[seeds[0]])
+	}
+	if rare.execs != uint64(counts[rare]) {
+		t.Fatalf("seed execs %v, mutated %v times", rare.execs, counts[rare])
+	}
+
+	// Productive seed should get more energy than the rare one.
+	productive := seeds[1]
+	for i := 0; i < 100; i++ {
+		sched.noteMutation(productive, true)
+	}
+	sched.rebuild()
+	counts = make(map[*seed]int)
+	for i := 0; i < iters; i++ {
+		counts[sched.choose(rnd)]++
+	}
+	if counts[productive] <= counts[rare] {
+		t.Fatalf("productive seed is selected %v times, seed with rare signal %v times",
+			counts[productive], counts[rare])
+	}
+
+	// Just added seed should get more energy than old seeds with the same signal.
+	sched.add(prog.Generate(rs, 3, ct), []uint32{1, 2, 3}, nil)
+	fresh := sched.seeds[len(sched.seeds)-1]
+	counts = make(map[*seed]int)
+	for i := 0; i < iters; i++ {
+		counts[sched.choose(rnd)]++
+	}
+	if counts[fresh] <= counts[seeds[2]]*2 {
+		t.Fatalf("fresh seed is selected %v times, old seed %v times", counts[fresh], counts[seeds[2]])
+	}
+}
+
+// TestSeedSchedulerAddDuringRebuild checks that seeds added during energy recalculation
+// keep their energy after the new energy of the other seeds is installed.
+func TestSeedSchedulerAddDuringRebuild(t *testing.T) {
+	rs := rand.NewSource(0)
+	ct := prog.BuildChoiceTable(prog.CalculatePriorities(nil), nil)
+	sched := newSeedScheduler()
+	sched.add(prog.Generate(rs, 3, ct), []uint32{1, 2}, nil)
+	sched.add(prog.Generate(rs, 3, ct), []uint32{1, 3}, nil)
+
+	sched.mu.Lock()
+	snapshot := sched.startRebuild()
+	sched.mu.Unlock()
+	sched.rebuild() // does nothing while the other recalculation is in progress
+	sched.add(prog.Generate(rs, 3, ct), []uint32{4, 5, 6}, nil)
+	added := sched.energy[2] - sched.energy[1]
+	sched.finishRebuild(snapshot)
+
+	if len(sched.energy) != len(sched.seeds) || sched.rebuilding || sched.selections != 0 {
+		t.Fatalf("bad scheduler state after rebuild: %v energies, %v seeds, rebuilding %v, selections %v",
+			len(sched.energy), len(sched.seeds), sched.rebuilding, sched.selections)
+	}
+	for i := range sched.energy {
+		prev := 0.0
+		if i != 0 {
+			prev = sched.energy[i-1]
+		}
+		if sched.energy[i] <= prev {
+			t.Fatalf("energy of seed %v is not positive: %v", i, sched.energy)
+		}
+	}
+	if got := sched.energy[2] - sched.energy[1]; math.Abs(got-added) > 1e-9 {
+		t.Fatalf("energy of the added seed changed from %v to %v", added, got)
+	}
+}
+
+func TestSeedSchedulerTargets(t *testing.T) {
+	rs := rand.NewSource(0)
+	rnd := rand.New(rs)
+	ct := prog.BuildChoiceTable(prog.CalculatePriorities(nil), nil)
+	sched := newSeedScheduler()
+	sched.targets = cover.MakeTargets([]cover.PCRange{{Start: 0x1000, End: 0x1100}})
+	sched.add(prog.Generate(rs, 3, ct), []uint32{1, 2, 3}, []uint32{0x100000})
+	sched.add(prog.Generate(rs, 3, ct), []uint32{1, 2, 3}, []uint32{0x1010, 0x1020, 0x100000})
+	far, near := sched.seeds[0], sched.seeds[1]
+	if far.target != 0 || near.target != 2 {
+		t.Fatalf("bad target scores: %v, %v", far.target, near.target)
+	}
+	const iters = 10000
+	counts := make(map[*seed]int)
+	for i := 0; i < iters; i++ {
+		counts[sched.choose(rnd)]++
+	}
+	if counts[near] <= counts[far]*2 {
+		t.Fatalf("seed covering target is selected %v times, other seed %v times", counts[near], counts[far])
+	}
+}
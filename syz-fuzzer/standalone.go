@@ -0,0 +1,415 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/syzkaller/cover"
+	"github.com/google/syzkaller/db"
+	"github.com/google/syzkaller/hash"
+	. "github.com/google/syzkaller/log"
+	"github.com/google/syzkaller/prog"
+	"github.com/google/syzkaller/report"
+	. "github.com/google/syzkaller/rpctype"
+)
+
+// Standalone mode allows to fuzz on a single machine without syz-manager (no -manager flag).
+// The fuzzer starts an in-process manager that serves the manager RPC interface on a loopback
+// address, so the rest of the fuzzer works as usual. The local manager keeps corpus
+// in workdir/corpus.db in the same format as syz-manager (the file can be copied
+// into manager workdir later), detects kernel crashes in /dev/kmsg and saves them
+// into workdir/crashes with the same layout as syz-manager. Status page is served on -http.
+// Note: the kernel must not panic on oops (panic_on_oops=0), otherwise crashes are lost.
+
+const (
+	kmsgMaxOutput   = 256 << 10        // kernel output kept for crash logs
+	crashOutputWait = 10 * time.Second // time to wait for the rest of a crash report
+	maxCrashLogs    = 100              // logs saved per crash type
+)
+
+type localManager struct {
+	mu           sync.Mutex
+	workdir      string
+	crashdir     string
+	startTime    time.Time
+	corpusDB     *db.DB
+	corpusSignal map[uint32]struct{}
+	candidates   []RpcCandidate
+	lastID       uint64
+	stats        map[string]uint64
+	recent       RecentProgSet // last executed programs (see recent.go)
+	crashes      map[string]*localCrash
+}
+
+type localCrash struct {
+	desc  string
+	dir   string
+	count int
+	last  time.Time
+}
+
+// startStandalone starts the local manager and returns its RPC address.
+func startStandalone(workdir, httpAddr string) string {
+	mgr := newLocalManager(workdir)
+	s, err := NewNamedRpcServer("localhost:0", "Manager", mgr)
+	if err != nil {
+		Fatalf("failed to create rpc server: %v", err)
+	}
+	go s.Serve()
+	go mgr.watchKmsg()
+	if httpAddr != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/", mgr.httpSummary)
+		go func() {
+			err := http.ListenAndServe(httpAddr, mux)
+			Fatalf("failed to serve status page: %v", err)
+		}()
+		Logf(0, "serving status page on http://%v", httpAddr)
+	}
+	return s.Addr().String()
+}
+
+// newLocalManager creates local manager and loads corpus from workdir.
+func newLocalManager(workdir string) *localManager {
+	mgr := &localManager{
+		workdir:      workdir,
+		crashdir:     filepath.Join(workdir, "crashes"),
+		startTime:    time.Now(),
+		corpusSignal: make(map[uint32]struct{}),
+		stats:        make(map[string]uint64),
+		recent:       make(RecentProgSet),
+		crashes:      make(map[string]*localCrash),
+	}
+	if err := os.MkdirAll(mgr.crashdir, 0700); err != nil {
+		Fatalf("failed to create workdir: %v", err)
+	}
+	var err error
+	mgr.corpusDB, err = db.Open(filepath.Join(workdir, "corpus.db"))
+	if err != nil {
+		Fatalf("failed to open corpus database: %v", err)
+	}
+	for key, rec := range mgr.corpusDB.Records {
+		if _, err := prog.Deserialize(rec.Val); err != nil {
+			Logf(0, "deleting broken program: %v\n%s", err, rec.Val)
+			mgr.corpusDB.Delete(key)
+			continue
+		}
+		mgr.lastID++
+		mgr.candidates = append(mgr.candidates, RpcCandidate{
+			ID:        mgr.lastID,
+			Prog:      rec.Val,
+			Minimized: true,
+		})
+	}
+	mgr.corpusDB.Flush()
+	Logf(0, "loaded %v programs from %v", len(mgr.candidates), workdir)
+	return mgr
+}
+
+func (mgr *localManager) Connect(a *ConnectArgs, r *ConnectRes) error {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	var corpus []*prog.Prog
+	for _, rec := range mgr.corpusDB.Records {
+		if p, err := prog.Deserialize(rec.Val); err == nil {
+			corpus = append(corpus, p)
+		}
+	}
+	r.Prios = prog.CalculatePriorities(corpus)
+	r.Candidates = mgr.takeCandidates()
+	return nil
+}
+
+func (mgr *localManager) Check(a *CheckArgs, r *int) error {
+	return nil
+}
+
+func (mgr *localManager) NewInput(a *NewInputArgs, r *int) error {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	if !cover.SignalNew(mgr.corpusSignal, a.Signal) {
+		return nil
+	}
+	cover.SignalAdd(mgr.corpusSignal, a.Signal)
+	sig := hash.String(a.Prog)
+	if _, ok := mgr.corpusDB.Records[sig]; ok {
+		return nil
+	}
+	mgr.stats["manager new inputs"]++
+	mgr.corpusDB.Save(sig, a.Prog, 0)
+	if err := mgr.corpusDB.Flush(); err != nil {
+		Logf(0, "failed to save corpus database: %v", err)
+	}
+	return nil
+}
+
+func (mgr *localManager) Poll(a *PollArgs, r *PollRes) error {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	for k, v := range a.Stats {
+		mgr.stats[k] += v
+	}
+	// There is only one fuzzer and it never gets lost,
+	// so untriaged inputs only need IDs, there is no need to track them.
+	for range a.NewTriage {
+		mgr.lastID++
+		r.TriageIDs = append(r.TriageIDs, mgr.lastID)
+	}
+	r.Candidates = mgr.takeCandidates()
+	return nil
+}
+
+func (mgr *localManager) Recent(a *RecentArgs, r *int) error {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	mgr.recent.Add(a.Progs)
+	return nil
+}
+
+// takeCandidates hands out up to procs corpus programs to the fuzzer.
+func (mgr *localManager) takeCandidates() []RpcCandidate {
+	n := *flagProcs
+	if n > len(mgr.candidates) {
+		n = len(mgr.candidates)
+	}
+	res := mgr.candidates[len(mgr.candidates)-n:]
+	mgr.candidates = mgr.candidates[:len(mgr.candidates)-n]
+	return res
+}
+
+// watchKmsg reads kernel messages and saves crashes.
+func (mgr *localManager) watchKmsg() {
+	fd, err := syscall.Open("/dev/kmsg", syscall.O_RDONLY, 0)
+	if err != nil {
+		Logf(0, "failed to open /dev/kmsg, crash detection is disabled: %v", err)
+		return
+	}
+	// Skip messages logged before start.
+	if _, err := syscall.Seek(fd, 0, 2); err != nil {
+		Logf(0, "failed to seek /dev/kmsg: %v", err)
+	}
+	msgs := make(chan []byte, 1000)
+	go func() {
+		// Every read returns one record.
+		buf := make([]byte, 8<<10)
+		for {
+			n, err := syscall.Read(fd, buf)
+			if err == syscall.EPIPE || err == syscall.EINTR {
+				// EPIPE means that some records were overwritten before we read them.
+				continue
+			}
+			if err != nil {
+				Logf(0, "failed to read /dev/kmsg, crash detection is disabled: %v", err)
+				return
+			}
+			msgs <- kmsgText(buf[:n])
+		}
+	}()
+	var output []byte
+	var crashTimer <-chan time.Time
+	for {
+		select {
+		case msg := <-msgs:
+			output = append(output, msg...)
+			if crashTimer == nil {
+				if report.ContainsCrash(msg, nil) {
+					// Give the kernel some time to finish printing the report.
+					crashTimer = time.After(crashOutputWait)
+				} else if len(output) > kmsgMaxOutput {
+					output = append([]byte{}, output[len(output)-kmsgMaxOutput/2:]...)
+				}
+			}
+		case <-crashTimer:
+			mgr.saveCrash(output)
+			output = nil
+			crashTimer = nil
+		}
+	}
+}
+
+// kmsgText converts a /dev/kmsg record ("prio,seq,usec,flags;message\n KEY=value\n")
+// to a console-like line.
+func kmsgText(rec []byte) []byte {
+	semi := bytes.IndexByte(rec, ';')
+	if semi == -1 {
+		return nil
+	}
+	var usec uint64
+	fields := bytes.Split(rec[:semi], []byte{','})
+	if len(fields) >= 3 {
+		fmt.Sscanf(string(fields[2]), "%d", &usec)
+	}
+	msg := rec[semi+1:]
+	// Continuation lines contain device properties, they are not printed on console.
+	if nl := bytes.IndexByte(msg, '\n'); nl != -1 {
+		msg = msg[:nl]
+	}
+	return []byte(fmt.Sprintf("[%5d.%06d] %s\n", usec/1e6, usec%1e6, msg))
+}
+
+func (mgr *localManager) saveCrash(output []byte) {
+	desc, text, _, _ := report.Parse(output, nil)
+	if desc == "" {
+		return
+	}
+	Logf(0, "crash: %v", desc)
+	mgr.mu.Lock()
+	progs := mgr.recent.Log()
+	mgr.stats["crashes"]++
+	crash := mgr.crashes[desc]
+	if crash == nil {
+		sig := hash.Hash([]byte(desc))
+		crash = &localCrash{
+			desc: desc,
+			dir:  filepath.Join(mgr.crashdir, sig.String()),
+		}
+		mgr.crashes[desc] = crash
+		mgr.stats["crash types"]++
+	}
+	crash.count++
+	crash.last = time.Now()
+	mgr.mu.Unlock()
+
+	// The layout is the same as in syz-manager: description and up to 100 logN/reportN/progsN.
+	dir := crash.dir
+	os.MkdirAll(dir, 0700)
+	if err := ioutil.WriteFile(filepath.Join(dir, "description"), []byte(desc+"\n"), 0660); err != nil {
+		Logf(0, "failed to write crash: %v", err)
+	}
+	oldestI := 0
+	var oldestTime time.Time
+	for i := 0; i < maxCrashLogs; i++ {
+		info, err := os.Stat(filepath.Join(dir, fmt.Sprintf("log%v", i)))
+		if err != nil {
+			oldestI = i
+			break
+		}
+		if oldestTime.IsZero() || info.ModTime().Before(oldestTime) {
+			oldestI = i
+			oldestTime = info.ModTime()
+		}
+	}
+	ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("log%v", oldestI)), output, 0660)
+	ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("report%v", oldestI)), text, 0660)
+	progsFile := filepath.Join(dir, fmt.Sprintf("progs%v", oldestI))
+	if len(progs) != 0 {
+		ioutil.WriteFile(progsFile, progs, 0660)
+	} else {
+		os.Remove(progsFile)
+	}
+}
+
+func (mgr *localManager) httpSummary(w http.ResponseWriter, r *http.Request) {
+	mgr.mu.Lock()
+	data := &localSummaryData{
+		Workdir:    mgr.workdir,
+		Uptime:     time.Since(mgr.startTime) / 1e9 * 1e9,
+		Corpus:     len(mgr.corpusDB.Records),
+		Signal:     len(mgr.corpusSignal),
+		Candidates: len(mgr.candidates),
+	}
+	for k, v := range mgr.stats {
+		data.Stats = append(data.Stats, localStat{k, v})
+	}
+	for _, crash := range mgr.crashes {
+		data.Crashes = append(data.Crashes, localUICrash{
+			Desc:  crash.desc,
+			Dir:   crash.dir,
+			Count: crash.count,
+			Last:  crash.last.Format("Jan 02 15:04"),
+		})
+	}
+	mgr.mu.Unlock()
+	sort.Sort(localStatArray(data.Stats))
+	sort.Sort(localUICrashArray(data.Crashes))
+	if err := localSummaryTemplate.Execute(w, data); err != nil {
+		http.Error(w, fmt.Sprintf("failed to execute template: %v", err), http.StatusInternalServerError)
+	}
+}
+
+type localSummaryData struct {
+	Workdir    string
+	Uptime     time.Duration
+	Corpus     int
+	Signal     int
+	Candidates int
+	Stats      []localStat
+	Crashes    []localUICrash
+}
+
+type localStat struct {
+	Name  string
+	Value uint64
+}
+
+type localStatArray []localStat
+
+func (a localStatArray) Len() int           { return len(a) }
+func (a localStatArray) Less(i, j int) bool { return a[i].Name < a[j].Name }
+func (a localStatArray) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+type localUICrash struct {
+	Desc  string
+	Dir   string
+	Count int
+	Last  string
+}
+
+type localUICrashArray []localUICrash
+
+func (a localUICrashArray) Len() int           { return len(a) }
+func (a localUICrashArray) Less(i, j int) bool { return a[i].Desc < a[j].Desc }
+func (a localUICrashArray) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+var localSummaryTemplate = template.Must(template.New("").Parse(`
+<!doctype html>
+<html>
+<head>
+	<title>syz-fuzzer {{.Workdir}}</title>
+</head>
+<body>
+<b>Standalone fuzzer: {{.Workdir}}</b>
+<br>
+uptime: {{.Uptime}}<br>
+corpus: {{.Corpus}}<br>
+signal: {{.Signal}}<br>
+untriaged corpus programs: {{.Candidates}}<br>
+{{range $s := $.Stats}}
+	{{$s.Name}}: {{$s.Value}}<br>
+{{end}}
+<br>
+<table>
+	<caption>Crashes:</caption>
+	<tr>
+		<th>Description</th>
+		<th>Count</th>
+		<th>Last Time</th>
+		<th>Directory</th>
+	</tr>
+	{{range $c := $.Crashes}}
+	<tr>
+		<td>{{$c.Desc}}</td>
+		<td>{{$c.Count}}</td>
+		<td>{{$c.Last}}</td>
+		<td>{{$c.Dir}}</td>
+	</tr>
+	{{end}}
+</table>
+</body></html>
+`))
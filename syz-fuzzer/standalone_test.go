@@ -0,0 +1,115 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/syzkaller/prog"
+	. "github.com/google/syzkaller/rpctype"
+)
+
+func TestKmsgText(t *testing.T) {
+	tests := []struct {
+		rec  string
+		text string
+	}{
+		{"6,123,5000123,-;hello world\n", "[    5.000123] hello world\n"},
+		{"4,124,42,c;usb 1-1: new device\n SUBSYSTEM=usb\n DEVICE=c189:1\n", "[    0.000042] usb 1-1: new device\n"},
+		{"garbage", ""},
+	}
+	for _, test := range tests {
+		if text := string(kmsgText([]byte(test.rec))); text != test.text {
+			t.Errorf("record %q: got %q, want %q", test.rec, text, test.text)
+		}
+	}
+}
+
+func TestLocalManagerCorpus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syz-fuzzer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mgr := newLocalManager(dir)
+	if len(mgr.candidates) != 0 {
+		t.Fatalf("got %v candidates in empty workdir", len(mgr.candidates))
+	}
+	inputs := []RpcInput{
+		{Call: "getpid", Prog: []byte("getpid()\n"), Signal: []uint32{1, 2}},
+		{Call: "getuid", Prog: []byte("getuid()\n"), Signal: []uint32{2}}, // no new signal
+		{Call: "getgid", Prog: []byte("getgid()\n"), Signal: []uint32{3}},
+	}
+	for _, inp := range inputs {
+		if err := mgr.NewInput(&NewInputArgs{Name: "standalone", RpcInput: inp}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Corpus must be loaded back as candidates on restart.
+	mgr = newLocalManager(dir)
+	got := make(map[string]bool)
+	for _, c := range mgr.candidates {
+		if !c.Minimized {
+			t.Fatalf("corpus program is not marked as minimized")
+		}
+		got[string(c.Prog)] = true
+	}
+	if len(got) != 2 || !got["getpid()\n"] || !got["getgid()\n"] {
+		t.Fatalf("got corpus %v", got)
+	}
+	defer func(procs int) { *flagProcs = procs }(*flagProcs)
+	*flagProcs = 1
+	if cands := mgr.takeCandidates(); len(cands) != 1 || len(mgr.candidates) != 1 {
+		t.Fatalf("took %v candidates, %v left", len(cands), len(mgr.candidates))
+	}
+}
+
+func TestLocalManagerCrash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syz-fuzzer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mgr := newLocalManager(dir)
+	p, err := prog.Deserialize([]byte("getpid()\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mgr.Recent(&RecentArgs{
+		Name:  "standalone",
+		Progs: []RecentProg{{Seq: 1, Proc: 0, Config: "none", Prog: p.Serialize()}},
+	}, nil)
+	output := []byte("[    1.000000] foo\n" +
+		"[    2.000000] BUG: KASAN: use-after-free in foo_bar+0x10/0x20\n" +
+		"[    2.000001] Read of size 8 by task syz-executor/1234\n")
+	mgr.saveCrash(output)
+	mgr.saveCrash(output)
+	if len(mgr.crashes) != 1 {
+		t.Fatalf("got %v crash types", len(mgr.crashes))
+	}
+	var crash *localCrash
+	for _, c := range mgr.crashes {
+		crash = c
+	}
+	if crash.count != 2 || !strings.Contains(crash.desc, "use-after-free") {
+		t.Fatalf("got crash %+v", crash)
+	}
+	for _, file := range []string{"description", "log0", "report0", "progs0", "log1"} {
+		if _, err := os.Stat(filepath.Join(crash.dir, file)); err != nil {
+			t.Fatalf("crash file is missing: %v", err)
+		}
+	}
+	progs, err := ioutil.ReadFile(filepath.Join(crash.dir, "progs0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := prog.ParseLog(progs)
+	if len(entries) != 1 || entries[0].Config != "none" {
+		t.Fatalf("bad programs file:\n%s", progs)
+	}
+}
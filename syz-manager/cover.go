@@ -76,6 +76,7 @@ func generateCoverHtml(w io.Writer, vmlinux string, cov []uint32) error {
 	if len(cov) == 0 {
 		return fmt.Errorf("No coverage data available")
 	}
+	targets := targetProgress(cov)
 
 	base, err := getVmOffset(vmlinux)
 	if err != nil {
@@ -103,7 +104,7 @@ func generateCoverHtml(w io.Writer, vmlinux string, cov []uint32) error {
 		return err
 	}
 
-	var d templateData
+	d := templateData{Targets: targets}
 	for f, covered := range fileSet(coveredFrames, uncoveredFrames) {
 		lines, err := parseFile(f)
 		if err != nil {
@@ -356,7 +357,14 @@ func symbolize(vmlinux string, pcs []uint64) ([]symbolizer.Frame, string, error)
 }
 
 type templateData struct {
-	Files []*templateFile
+	Files   []*templateFile
+	Targets []*templateTarget
+}
+
+type templateTarget struct {
+	Name    string
+	Covered int // number of covered PCs in the target
+	Total   int // number of coverage points in the target
 }
 
 type templateFile struct {
@@ -419,6 +427,10 @@ var coverTemplate = template.Must(template.New("").Parse(
 				color: rgb(255, 0, 0);
 				font-weight: bold;
 			}
+			#targets {
+				font-family: 'Courier New', Courier, monospace;
+				margin-top: 50px;
+			}
 		</style>
 	</head>
 	<body>
@@ -431,6 +443,14 @@ var coverTemplate = template.Must(template.New("").Parse(
 				</select>
 			</div>
 		</div>
+		{{if .Targets}}
+		<div id="targets">
+		<b>Fuzzing targets:</b><br>
+		{{range $t := .Targets}}
+		{{$t.Name}}: {{$t.Covered}}/{{$t.Total}}<br>
+		{{end}}
+		</div>
+		{{end}}
 		<div id="content">
 		{{range $i, $f := .Files}}
 		<pre class="file" id="file{{$i}}" {{if $i}}style="display: none"{{end}}>{{$f.Body}}</pre>
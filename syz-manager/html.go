@@ -4,6 +4,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"io"
@@ -17,11 +18,13 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/google/syzkaller/cover"
 	. "github.com/google/syzkaller/log"
 	"github.com/google/syzkaller/prog"
+	. "github.com/google/syzkaller/rpctype"
 	"github.com/google/syzkaller/sys"
 )
 
@@ -35,6 +38,10 @@ func (mgr *Manager) initHttp() {
 	http.HandleFunc("/prio", mgr.httpPrio)
 	http.HandleFunc("/file", mgr.httpFile)
 	http.HandleFunc("/report", mgr.httpReport)
+	http.HandleFunc("/descriptions", mgr.httpDescriptions)
+	http.HandleFunc("/latency", mgr.httpLatency)
+	http.HandleFunc("/syscalls", mgr.httpSyscalls)
+	http.HandleFunc("/quarantine", mgr.httpQuarantine)
 
 	ln, err := net.Listen("tcp4", mgr.cfg.Http)
 	if err != nil {
@@ -64,9 +71,13 @@ func (mgr *Manager) httpSummary(w http.ResponseWriter, r *http.Request) {
 	data.Stats = append(data.Stats, UIStat{Name: "uptime", Value: fmt.Sprint(time.Since(mgr.startTime) / 1e9 * 1e9)})
 	data.Stats = append(data.Stats, UIStat{Name: "fuzzing", Value: fmt.Sprint(mgr.fuzzingTime / 60e9 * 60e9)})
 	data.Stats = append(data.Stats, UIStat{Name: "corpus", Value: fmt.Sprint(len(mgr.corpus))})
-	data.Stats = append(data.Stats, UIStat{Name: "triage queue", Value: fmt.Sprint(len(mgr.candidates))})
+	data.Stats = append(data.Stats, UIStat{Name: "triage queue", Value: fmt.Sprint(mgr.numUntriaged())})
 	data.Stats = append(data.Stats, UIStat{Name: "cover", Value: fmt.Sprint(len(mgr.corpusCover)), Link: "/cover"})
 	data.Stats = append(data.Stats, UIStat{Name: "signal", Value: fmt.Sprint(len(mgr.corpusSignal))})
+	data.Stats = append(data.Stats, UIStat{Name: "used descriptions", Value: fmt.Sprint(len(mgr.descStats)), Link: "/descriptions"})
+	data.Stats = append(data.Stats, UIStat{Name: "syscall latency", Value: fmt.Sprint(len(mgr.callStats)), Link: "/latency"})
+	data.Stats = append(data.Stats, UIStat{Name: "executed syscalls", Value: fmt.Sprint(len(mgr.callStats)), Link: "/syscalls"})
+	data.Stats = append(data.Stats, UIStat{Name: "quarantined calls", Value: fmt.Sprint(len(mgr.quarantinedCalls())), Link: "/quarantine"})
 
 	type CallCov struct {
 		count int
@@ -217,6 +228,168 @@ func (mgr *Manager) httpPrio(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (mgr *Manager) httpDescriptions(w http.ResponseWriter, r *http.Request) {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	var calls []*sys.Call
+	if mgr.enabledCalls != nil {
+		for _, name := range mgr.enabledCalls {
+			if c := sys.CallMap[name]; c != nil {
+				calls = append(calls, c)
+			}
+		}
+	} else {
+		calls = sys.Calls
+	}
+	data := &UIDescriptionsData{}
+	seen := make(map[string]bool)
+	add := func(list *[]UIDescStat, name string) {
+		if seen[name] {
+			return
+		}
+		seen[name] = true
+		st := mgr.descStats[name]
+		*list = append(*list, UIDescStat{name, st.Execs, st.NewSignal})
+	}
+	for _, c := range calls {
+		add(&data.Calls, c.Name)
+		// Names must match the ones produced by prog.UsedFields.
+		sys.ForeachType(c, func(t sys.Type) {
+			switch typ := t.(type) {
+			case *sys.StructType:
+				for _, f := range typ.Fields {
+					if !prog.IsConstField(f) {
+						add(&data.Fields, typ.Name()+"."+f.FieldName())
+					}
+				}
+			case *sys.UnionType:
+				for _, opt := range typ.Options {
+					add(&data.Options, typ.Name()+"."+opt.FieldName())
+				}
+			}
+		})
+	}
+	sort.Sort(UIDescStatArray(data.Calls))
+	sort.Sort(UIDescStatArray(data.Fields))
+	sort.Sort(UIDescStatArray(data.Options))
+
+	if r.FormValue("json") != "" {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(data); err != nil {
+			http.Error(w, fmt.Sprintf("failed to encode json: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+	if err := descriptionsTemplate.Execute(w, data); err != nil {
+		http.Error(w, fmt.Sprintf("failed to execute template: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (mgr *Manager) httpLatency(w http.ResponseWriter, r *http.Request) {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	data := &UILatencyData{}
+	for _, b := range LatencyBuckets {
+		data.Buckets = append(data.Buckets, "<="+b.String())
+	}
+	data.Buckets = append(data.Buckets, ">"+LatencyBuckets[len(LatencyBuckets)-1].String())
+	for name, st := range mgr.callStats {
+		data.Calls = append(data.Calls, UICallLatency{
+			Name:       name,
+			Total:      st.Execs,
+			Hist:       st.Hist,
+			Blocked:    st.Blocked,
+			Unfinished: st.Unfinished,
+		})
+	}
+	sort.Sort(UICallLatencyArray(data.Calls))
+
+	if err := latencyTemplate.Execute(w, data); err != nil {
+		http.Error(w, fmt.Sprintf("failed to execute template: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (mgr *Manager) httpSyscalls(w http.ResponseWriter, r *http.Request) {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	data := &UISyscallsData{}
+	for name, st := range mgr.callStats {
+		c := UICallStat{
+			Name:      name,
+			Execs:     st.Execs,
+			Successes: st.Successes,
+			NewSignal: st.NewSignal,
+		}
+		if st.Execs != 0 {
+			c.SuccessRate = st.Successes * 100 / st.Execs
+		}
+		var errnos []UIErrno
+		for errno, n := range st.Errnos {
+			c.Failures += n
+			errnos = append(errnos, UIErrno{errno, syscall.Errno(errno).Error(), n})
+		}
+		sort.Sort(UIErrnoArray(errnos))
+		if len(errnos) > maxUIErrnos {
+			errnos = errnos[:maxUIErrnos]
+		}
+		c.Errnos = errnos
+		data.Calls = append(data.Calls, c)
+	}
+	data.Sort = r.FormValue("sort")
+	if _, ok := callStatLess[data.Sort]; !ok {
+		data.Sort = "execs"
+	}
+	sort.Sort(UICallStatArray{data.Calls, callStatLess[data.Sort]})
+
+	if err := syscallsTemplate.Execute(w, data); err != nil {
+		http.Error(w, fmt.Sprintf("failed to execute template: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (mgr *Manager) httpQuarantine(w http.ResponseWriter, r *http.Request) {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	data := &UIQuarantineData{}
+	now := time.Now()
+	for name, q := range mgr.quarantine {
+		call := UIQuarantine{
+			Name:     name,
+			Active:   now.Before(q.Until),
+			Since:    q.Since.Format(dateFormat),
+			Until:    q.Until.Format(dateFormat),
+			Times:    q.Times,
+			Execs:    q.Execs,
+			Hangs:    q.Evidence.Hangs,
+			VMLosses: q.Evidence.VMLosses,
+		}
+		for _, p := range q.Evidence.Programs {
+			call.Programs = append(call.Programs, string(p))
+		}
+		data.Calls = append(data.Calls, call)
+	}
+	sort.Sort(UIQuarantineArray(data.Calls))
+	for name, st := range mgr.hangStats {
+		data.Suspects = append(data.Suspects, UIQuarantine{
+			Name:     name,
+			Hangs:    st.Hangs,
+			VMLosses: st.VMLosses,
+		})
+	}
+	sort.Sort(UIQuarantineArray(data.Suspects))
+
+	if err := quarantineTemplate.Execute(w, data); err != nil {
+		http.Error(w, fmt.Sprintf("failed to execute template: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
 func (mgr *Manager) httpFile(w http.ResponseWriter, r *http.Request) {
 	mgr.mu.Lock()
 	defer mgr.mu.Unlock()
@@ -343,6 +516,10 @@ func readCrash(workdir, dir string, full bool) *UICrashType {
 			}
 			tag, _ := ioutil.ReadFile(filepath.Join(crashdir, dir, "tag"+index))
 			crash.Tag = string(tag)
+			progsFile := filepath.Join("crashes", dir, "progs"+index)
+			if _, err := os.Stat(filepath.Join(workdir, progsFile)); err == nil {
+				crash.Progs = progsFile
+			}
 			reportFile := filepath.Join("crashes", dir, "report"+index)
 			if _, err := os.Stat(filepath.Join(workdir, reportFile)); err == nil {
 				crash.Report = reportFile
@@ -409,6 +586,7 @@ type UICrash struct {
 	Time    time.Time
 	TimeStr string
 	Log     string
+	Progs   string // programs sent by fuzzer (see recent.go)
 	Report  string
 	Tag     string
 }
@@ -556,6 +734,7 @@ Report: <a href="/report?id={{.ID}}">{{.Triaged}}</a>
 	<tr>
 		<th>#</th>
 		<th>Log</th>
+		<th>Programs</th>
 		<th>Report</th>
 		<th>Time</th>
 		<th>Tag</th>
@@ -564,6 +743,11 @@ Report: <a href="/report?id={{.ID}}">{{.Triaged}}</a>
 	<tr>
 		<td>{{$c.Index}}</td>
 		<td><a href="/file?name={{$c.Log}}">log</a></td>
+		{{if $c.Progs}}
+			<td><a href="/file?name={{$c.Progs}}">programs</a></td>
+		{{else}}
+			<td></td>
+		{{end}}
 		{{if $c.Report}}
 			<td><a href="/file?name={{$c.Report}}">report</a></td>
 		{{else}}
@@ -624,6 +808,351 @@ Priorities for {{$.Call}} <br> <br>
 </body></html>
 `)))
 
+type UIDescriptionsData struct {
+	Calls   []UIDescStat
+	Fields  []UIDescStat
+	Options []UIDescStat
+}
+
+type UIDescStat struct {
+	Name      string
+	Execs     uint64
+	NewSignal uint64
+}
+
+// UIDescStatArray sorts least useful descriptions first.
+type UIDescStatArray []UIDescStat
+
+func (a UIDescStatArray) Len() int { return len(a) }
+func (a UIDescStatArray) Less(i, j int) bool {
+	if a[i].NewSignal != a[j].NewSignal {
+		return a[i].NewSignal < a[j].NewSignal
+	}
+	if a[i].Execs != a[j].Execs {
+		return a[i].Execs < a[j].Execs
+	}
+	return a[i].Name < a[j].Name
+}
+func (a UIDescStatArray) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+
+var descriptionsTemplate = template.Must(template.New("").Parse(addStyle(`
+<!doctype html>
+<html>
+<head>
+	<title>syzkaller descriptions</title>
+	{{STYLE}}
+</head>
+<body>
+<a href="/descriptions?json=1">json</a>
+<br><br>
+
+<table>
+	<caption>Calls:</caption>
+	<tr>
+		<th>Name</th>
+		<th>Executions</th>
+		<th>New signal</th>
+	</tr>
+	{{range $d := $.Calls}}
+	<tr>
+		<td>{{$d.Name}}</td>
+		<td>{{$d.Execs}}</td>
+		<td>{{$d.NewSignal}}</td>
+	</tr>
+	{{end}}
+</table>
+<br>
+
+<table>
+	<caption>Struct fields:</caption>
+	<tr>
+		<th>Name</th>
+		<th>Executions</th>
+		<th>New signal</th>
+	</tr>
+	{{range $d := $.Fields}}
+	<tr>
+		<td>{{$d.Name}}</td>
+		<td>{{$d.Execs}}</td>
+		<td>{{$d.NewSignal}}</td>
+	</tr>
+	{{end}}
+</table>
+<br>
+
+<table>
+	<caption>Union options:</caption>
+	<tr>
+		<th>Name</th>
+		<th>Executions</th>
+		<th>New signal</th>
+	</tr>
+	{{range $d := $.Options}}
+	<tr>
+		<td>{{$d.Name}}</td>
+		<td>{{$d.Execs}}</td>
+		<td>{{$d.NewSignal}}</td>
+	</tr>
+	{{end}}
+</table>
+<br>
+</body></html>
+`)))
+
+type UILatencyData struct {
+	Buckets []string
+	Calls   []UICallLatency
+}
+
+type UICallLatency struct {
+	Name       string
+	Total      uint64
+	Hist       []uint64
+	Blocked    uint64
+	Unfinished uint64
+}
+
+// UICallLatencyArray sorts most blocking calls first.
+type UICallLatencyArray []UICallLatency
+
+func (a UICallLatencyArray) Len() int { return len(a) }
+func (a UICallLatencyArray) Less(i, j int) bool {
+	if a[i].Blocked != a[j].Blocked {
+		return a[i].Blocked > a[j].Blocked
+	}
+	return a[i].Name < a[j].Name
+}
+func (a UICallLatencyArray) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+
+var latencyTemplate = template.Must(template.New("").Parse(addStyle(`
+<!doctype html>
+<html>
+<head>
+	<title>syzkaller syscall latency</title>
+	{{STYLE}}
+</head>
+<body>
+<table>
+	<caption>Syscall latency:</caption>
+	<tr>
+		<th>Name</th>
+		<th>Executions</th>
+		<th>Blocked</th>
+		<th>Unfinished</th>
+		{{range $b := $.Buckets}}
+		<th>{{$b}}</th>
+		{{end}}
+	</tr>
+	{{range $c := $.Calls}}
+	<tr>
+		<td>{{$c.Name}}</td>
+		<td>{{$c.Total}}</td>
+		<td>{{$c.Blocked}}</td>
+		<td>{{$c.Unfinished}}</td>
+		{{range $v := $c.Hist}}
+		<td>{{$v}}</td>
+		{{end}}
+	</tr>
+	{{end}}
+</table>
+</body></html>
+`)))
+
+type UISyscallsData struct {
+	Sort  string // column the table is sorted by
+	Calls []UICallStat
+}
+
+type UICallStat struct {
+	Name        string
+	Execs       uint64
+	Successes   uint64
+	SuccessRate uint64 // percent
+	Failures    uint64
+	NewSignal   uint64
+	Errnos      []UIErrno // most frequent errnos
+}
+
+type UIErrno struct {
+	Errno int
+	Desc  string
+	Count uint64
+}
+
+const maxUIErrnos = 5
+
+// UIErrnoArray sorts most frequent errnos first.
+type UIErrnoArray []UIErrno
+
+func (a UIErrnoArray) Len() int { return len(a) }
+func (a UIErrnoArray) Less(i, j int) bool {
+	if a[i].Count != a[j].Count {
+		return a[i].Count > a[j].Count
+	}
+	return a[i].Errno < a[j].Errno
+}
+func (a UIErrnoArray) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+
+// callStatLess are orders of syscall stats table selectable with sort parameter.
+var callStatLess = map[string]func(a, b *UICallStat) bool{
+	"name":      func(a, b *UICallStat) bool { return a.Name < b.Name },
+	"execs":     func(a, b *UICallStat) bool { return a.Execs > b.Execs },
+	"successes": func(a, b *UICallStat) bool { return a.Successes > b.Successes },
+	"rate":      func(a, b *UICallStat) bool { return a.SuccessRate < b.SuccessRate },
+	"failures":  func(a, b *UICallStat) bool { return a.Failures > b.Failures },
+	"signal":    func(a, b *UICallStat) bool { return a.NewSignal > b.NewSignal },
+}
+
+// UICallStatArray sorts syscall stats with the less function, ties are broken by name.
+type UICallStatArray struct {
+	calls []UICallStat
+	less  func(a, b *UICallStat) bool
+}
+
+func (a UICallStatArray) Len() int { return len(a.calls) }
+func (a UICallStatArray) Less(i, j int) bool {
+	if a.less(&a.calls[i], &a.calls[j]) {
+		return true
+	}
+	if a.less(&a.calls[j], &a.calls[i]) {
+		return false
+	}
+	return a.calls[i].Name < a.calls[j].Name
+}
+func (a UICallStatArray) Swap(i, j int) { a.calls[i], a.calls[j] = a.calls[j], a.calls[i] }
+
+var syscallsTemplate = template.Must(template.New("").Parse(addStyle(`
+<!doctype html>
+<html>
+<head>
+	<title>syzkaller executed syscalls</title>
+	{{STYLE}}
+</head>
+<body>
+<table>
+	<caption>Executed syscalls (sorted by {{$.Sort}}):</caption>
+	<tr>
+		<th><a href="/syscalls?sort=name">Name</a></th>
+		<th><a href="/syscalls?sort=execs">Executions</a></th>
+		<th><a href="/syscalls?sort=successes">Successes</a></th>
+		<th><a href="/syscalls?sort=rate">Success %</a></th>
+		<th><a href="/syscalls?sort=failures">Failures</a></th>
+		<th><a href="/syscalls?sort=signal">New signal</a></th>
+		<th>Errnos</th>
+	</tr>
+	{{range $c := $.Calls}}
+	<tr>
+		<td>{{$c.Name}}</td>
+		<td>{{$c.Execs}}</td>
+		<td>{{$c.Successes}}</td>
+		<td>{{$c.SuccessRate}}</td>
+		<td>{{$c.Failures}}</td>
+		<td>{{$c.NewSignal}}</td>
+		<td>
+		{{range $e := $c.Errnos}}
+			<span title="{{$e.Desc}}">{{$e.Errno}}:{{$e.Count}}</span>
+		{{end}}
+		</td>
+	</tr>
+	{{end}}
+</table>
+</body></html>
+`)))
+
+type UIQuarantineData struct {
+	Calls    []UIQuarantine // quarantined calls (currently or in the past)
+	Suspects []UIQuarantine // calls with hangs that are not quarantined yet
+}
+
+type UIQuarantine struct {
+	Name     string
+	Active   bool
+	Since    string
+	Until    string
+	Times    int
+	Execs    uint64
+	Hangs    uint64
+	VMLosses uint64
+	Programs []string
+}
+
+// UIQuarantineArray sorts active quarantines and calls with most hangs first.
+type UIQuarantineArray []UIQuarantine
+
+func (a UIQuarantineArray) Len() int { return len(a) }
+func (a UIQuarantineArray) Less(i, j int) bool {
+	if a[i].Active != a[j].Active {
+		return a[i].Active
+	}
+	hi := a[i].Hangs + a[i].VMLosses*quarantineVMLossWeight
+	hj := a[j].Hangs + a[j].VMLosses*quarantineVMLossWeight
+	if hi != hj {
+		return hi > hj
+	}
+	return a[i].Name < a[j].Name
+}
+func (a UIQuarantineArray) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+
+var quarantineTemplate = template.Must(template.New("").Parse(addStyle(`
+<!doctype html>
+<html>
+<head>
+	<title>syzkaller quarantined calls</title>
+	{{STYLE}}
+</head>
+<body>
+<table>
+	<caption>Quarantined calls:</caption>
+	<tr>
+		<th>Name</th>
+		<th>Active</th>
+		<th>Since</th>
+		<th>Until</th>
+		<th>Times</th>
+		<th>Executions</th>
+		<th>Hangs</th>
+		<th>VM losses</th>
+	</tr>
+	{{range $c := $.Calls}}
+	<tr>
+		<td>{{$c.Name}}</td>
+		<td>{{$c.Active}}</td>
+		<td>{{$c.Since}}</td>
+		<td>{{$c.Until}}</td>
+		<td>{{$c.Times}}</td>
+		<td>{{$c.Execs}}</td>
+		<td>{{$c.Hangs}}</td>
+		<td>{{$c.VMLosses}}</td>
+	</tr>
+	{{end}}
+</table>
+<br>
+{{range $c := $.Calls}}
+{{if $c.Programs}}
+<b>Last programs of VM losses with {{$c.Name}}:</b><br>
+{{range $p := $c.Programs}}
+<textarea rows="10" readonly>{{$p}}</textarea><br>
+{{end}}
+{{end}}
+{{end}}
+<table>
+	<caption>Suspects:</caption>
+	<tr>
+		<th>Name</th>
+		<th>Hangs</th>
+		<th>VM losses</th>
+	</tr>
+	{{range $c := $.Suspects}}
+	<tr>
+		<td>{{$c.Name}}</td>
+		<td>{{$c.Hangs}}</td>
+		<td>{{$c.VMLosses}}</td>
+	</tr>
+	{{end}}
+</table>
+</body></html>
+`)))
+
 func addStyle(html string) string {
 	return strings.Replace(html, "{{STYLE}}", htmlStyle, -1)
 }
@@ -0,0 +1,46 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestCallStatSort(t *testing.T) {
+	calls := []UICallStat{
+		{Name: "read", Execs: 10, Successes: 5, SuccessRate: 50, Failures: 5, NewSignal: 1},
+		{Name: "close", Execs: 10, Successes: 10, SuccessRate: 100, NewSignal: 0},
+		{Name: "open", Execs: 20, Successes: 2, SuccessRate: 10, Failures: 18, NewSignal: 3},
+		{Name: "bind", Execs: 5, Successes: 5, SuccessRate: 100, NewSignal: 1},
+	}
+	tests := map[string][]string{
+		"name":      {"bind", "close", "open", "read"},
+		"execs":     {"open", "close", "read", "bind"},
+		"successes": {"close", "bind", "read", "open"},
+		"rate":      {"open", "read", "bind", "close"},
+		"failures":  {"open", "read", "bind", "close"},
+		"signal":    {"open", "bind", "read", "close"},
+	}
+	for order, want := range tests {
+		less := callStatLess[order]
+		if less == nil {
+			t.Fatalf("unknown sort order %v", order)
+		}
+		sorted := append([]UICallStat{}, calls...)
+		sort.Sort(UICallStatArray{sorted, less})
+		for i, c := range sorted {
+			if c.Name != want[i] {
+				var got []string
+				for _, c := range sorted {
+					got = append(got, c.Name)
+				}
+				t.Fatalf("sort by %v: got %v, want %v", order, got, want)
+			}
+		}
+	}
+	if len(tests) != len(callStatLess) {
+		t.Fatalf("not all sort orders are tested")
+	}
+}
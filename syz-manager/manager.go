@@ -9,11 +9,12 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
-	"math/rand"
 	"net"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"regexp"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"syscall"
@@ -43,6 +44,9 @@ var (
 	flagConfig = flag.String("config", "", "configuration file")
 	flagDebug  = flag.Bool("debug", false, "dump all VM output to console")
 	flagBench  = flag.String("bench", "", "write execution statistics into this file periodically")
+
+	// Printed by fuzzer when executor persistently fails to set up the test environment.
+	executorFailureRe = regexp.MustCompile(`SYZ-FUZZER: EXECUTOR FAILURE \(([a-z ]+)\)`)
 )
 
 type Manager struct {
@@ -67,13 +71,19 @@ type Manager struct {
 	enabledSyscalls string
 	enabledCalls    []string // as determined by fuzzer
 
-	candidates     []RpcCandidate // untriaged inputs
-	disabledHashes map[string]struct{}
-	corpus         map[string]RpcInput
-	corpusSignal   map[uint32]struct{}
-	maxSignal      map[uint32]struct{}
-	corpusCover    map[uint32]struct{}
-	prios          [][]float32
+	candidates      []RpcCandidate // untriaged inputs not handed out to fuzzers (see triage.go)
+	lastCandidateID uint64
+	triageCrashes   map[uint64]int // number of VM crashes with the input in flight
+	disabledHashes  map[string]struct{}
+	corpus          map[string]RpcInput
+	corpusSignal    map[uint32]struct{}
+	maxSignal       map[uint32]struct{}
+	corpusCover     map[uint32]struct{}
+	prios           [][]float32
+	descStats       map[string]DescStat
+	callStats       map[string]CallStat
+	hangStats       map[string]*HangStat   // hang evidence of calls (see quarantine.go)
+	quarantine      map[string]*Quarantine // calls that are or were quarantined
 
 	fuzzers   map[string]*Fuzzer
 	hub       *RpcClient
@@ -84,6 +94,8 @@ type Fuzzer struct {
 	name         string
 	inputs       []RpcInput
 	newMaxSignal []uint32
+	inflight     map[uint64]RpcCandidate // untriaged inputs handed out to the fuzzer
+	recent       RecentProgSet           // last executed programs (see recent.go)
 }
 
 type Crash struct {
@@ -91,6 +103,7 @@ type Crash struct {
 	desc   string
 	text   []byte
 	output []byte
+	progs  []byte // last programs executed by the fuzzer in execution log format (see recent.go)
 }
 
 func main() {
@@ -105,6 +118,7 @@ func main() {
 		cfg.Count = 1
 	}
 	initAllCover(cfg.Vmlinux)
+	initTargets(cfg.Vmlinux, cfg.Target_Funcs, cfg.Target_Files)
 	RunManager(cfg, syscalls)
 }
 
@@ -134,7 +148,12 @@ func RunManager(cfg *config.Config, syscalls map[int]bool) {
 		corpusSignal:    make(map[uint32]struct{}),
 		maxSignal:       make(map[uint32]struct{}),
 		corpusCover:     make(map[uint32]struct{}),
+		descStats:       make(map[string]DescStat),
+		callStats:       make(map[string]CallStat),
+		hangStats:       make(map[string]*HangStat),
+		quarantine:      make(map[string]*Quarantine),
 		fuzzers:         make(map[string]*Fuzzer),
+		triageCrashes:   make(map[uint64]int),
 		fresh:           true,
 		vmStop:          make(chan bool),
 	}
@@ -178,7 +197,7 @@ func RunManager(cfg *config.Config, syscalls map[int]bool) {
 			mgr.disabledHashes[hash.String(rec.Val)] = struct{}{}
 			continue
 		}
-		mgr.candidates = append(mgr.candidates, RpcCandidate{
+		mgr.queueCandidate(RpcCandidate{
 			Prog:      rec.Val,
 			Minimized: true, // don't reminimize programs from corpus, it takes lots of time on start
 		})
@@ -186,19 +205,6 @@ func RunManager(cfg *config.Config, syscalls map[int]bool) {
 	mgr.fresh = len(mgr.corpusDB.Records) == 0
 	Logf(0, "loaded %v programs (%v total, %v deleted)", len(mgr.candidates), len(mgr.corpusDB.Records), deleted)
 
-	// Now this is ugly.
-	// We duplicate all inputs in the corpus and shuffle the second part.
-	// This solves the following problem. A fuzzer can crash while triaging candidates,
-	// in such case it will also lost all cached candidates. Or, the input can be somewhat flaky
-	// and doesn't give the coverage on first try. So we give each input the second chance.
-	// Shuffling should alleviate deterministically losing the same inputs on fuzzer crashing.
-	mgr.candidates = append(mgr.candidates, mgr.candidates...)
-	shuffle := mgr.candidates[len(mgr.candidates)/2:]
-	for i := range shuffle {
-		j := i + rand.Intn(len(shuffle)-i)
-		shuffle[i], shuffle[j] = shuffle[j], shuffle[i]
-	}
-
 	// Create HTTP server.
 	mgr.initHttp()
 
@@ -353,7 +359,7 @@ func (mgr *Manager) vmLoop() {
 				instances = instances[:len(instances)-reproInstances]
 				Logf(1, "loop: starting repro of '%v' on instances %+v", crash.desc, vmIndexes)
 				go func() {
-					res, err := repro.Run(crash.output, mgr.cfg, vmIndexes)
+					res, err := repro.Run(crash.output, crash.progs, mgr.cfg, vmIndexes)
 					reproDone <- &ReproResult{vmIndexes, crash, res, err}
 				}()
 			}
@@ -451,24 +457,50 @@ func (mgr *Manager) runInstance(vmCfg *vm.Config, first bool) (*Crash, error) {
 	start := time.Now()
 	atomic.AddUint32(&mgr.numFuzzing, 1)
 	defer atomic.AddUint32(&mgr.numFuzzing, ^uint32(0))
-	cmd := fmt.Sprintf("%v -executor=%v -name=%v -manager=%v -output=%v -procs=%v -leak=%v -cover=%v -sandbox=%v -debug=%v -v=%d",
-		fuzzerBin, executorBin, vmCfg.Name, fwdAddr, mgr.cfg.Output, procs, leak, mgr.cfg.Cover, mgr.cfg.Sandbox, *flagDebug, fuzzerV)
+	var execConfigs []string
+	for _, ec := range mgr.cfg.ProcExecConfigs(vmCfg.Index, procs) {
+		execConfigs = append(execConfigs, ec.String())
+	}
+	cmd := fmt.Sprintf("%v -executor=%v -name=%v -manager=%v -output=%v -procs=%v -leak=%v -cover=%v -errno_signal=%v -sandbox=%v -exec_configs=%v -debug=%v -v=%d",
+		fuzzerBin, executorBin, vmCfg.Name, fwdAddr, mgr.cfg.Output, procs, leak, mgr.cfg.Cover, mgr.cfg.Errno_Signal, mgr.cfg.Sandbox,
+		strings.Join(execConfigs, "/"), *flagDebug, fuzzerV)
 	outc, errc, err := inst.Run(time.Hour, mgr.vmStop, cmd)
 	if err != nil {
 		return nil, fmt.Errorf("failed to run fuzzer: %v", err)
 	}
 
 	desc, text, output, crashed, timedout := vm.MonitorExecution(outc, errc, mgr.cfg.Type == "local", true, mgr.cfg.ParsedIgnores)
+	var progs []byte
+	var recent RecentProgSet
+	mgr.mu.Lock()
+	if f := mgr.fuzzers[vmCfg.Name]; f != nil {
+		recent = f.recent
+		progs = recent.Log()
+		mgr.fuzzerLost(f, crashed)
+	}
+	if crashed && desc == "no output from test machine" {
+		mgr.noteVMLoss(recent)
+	}
+	mgr.mu.Unlock()
 	if timedout {
 		// This is the only "OK" outcome.
 		Logf(0, "%v: running for %v, restarting (%v)", vmCfg.Name, time.Since(start), desc)
 		return nil, nil
 	}
+	if match := executorFailureRe.FindSubmatch(output); match != nil && len(text) == 0 {
+		// The machine is misconfigured (e.g. kcov is not enabled), this is not a kernel bug.
+		reason := string(match[1])
+		Logf(0, "%v: executor failure: %v", vmCfg.Name, reason)
+		mgr.mu.Lock()
+		mgr.stats["executor failure "+reason]++
+		mgr.mu.Unlock()
+		return nil, nil
+	}
 	if !crashed {
 		// syz-fuzzer exited, but it should not.
 		desc = "lost connection to test machine"
 	}
-	return &Crash{vmCfg.Name, desc, text, output}, nil
+	return &Crash{vmCfg.Name, desc, text, output, progs}, nil
 }
 
 func (mgr *Manager) isSuppressed(crash *Crash) bool {
@@ -519,6 +551,12 @@ func (mgr *Manager) saveCrash(crash *Crash) {
 		}
 	}
 	ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("log%v", oldestI)), crash.output, 0660)
+	progsFile := filepath.Join(dir, fmt.Sprintf("progs%v", oldestI))
+	if len(crash.progs) != 0 {
+		ioutil.WriteFile(progsFile, crash.progs, 0660)
+	} else {
+		os.Remove(progsFile)
+	}
 	if len(mgr.cfg.Tag) > 0 {
 		ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("tag%v", oldestI)), []byte(mgr.cfg.Tag), 0660)
 	}
@@ -649,7 +687,7 @@ func (mgr *Manager) minimizeCorpus() {
 	}
 
 	// Don't minimize persistent corpus until fuzzers have triaged all inputs from it.
-	if len(mgr.candidates) == 0 {
+	if mgr.numUntriaged() == 0 {
 		for key := range mgr.corpusDB.Records {
 			_, ok1 := mgr.corpus[key]
 			_, ok2 := mgr.disabledHashes[key]
@@ -663,6 +701,7 @@ func (mgr *Manager) minimizeCorpus() {
 
 func (mgr *Manager) Connect(a *ConnectArgs, r *ConnectRes) error {
 	Logf(1, "fuzzer %v connected", a.Name)
+	<-targetsReady
 	mgr.mu.Lock()
 	defer mgr.mu.Unlock()
 
@@ -671,8 +710,12 @@ func (mgr *Manager) Connect(a *ConnectArgs, r *ConnectRes) error {
 	}
 
 	mgr.stats["vm restarts"]++
+	if f := mgr.fuzzers[a.Name]; f != nil {
+		mgr.fuzzerLost(f, false)
+	}
 	f := &Fuzzer{
-		name: a.Name,
+		name:     a.Name,
+		inflight: make(map[uint64]RpcCandidate),
 	}
 	mgr.fuzzers[a.Name] = f
 	mgr.minimizeCorpus()
@@ -706,20 +749,16 @@ func (mgr *Manager) Connect(a *ConnectArgs, r *ConnectRes) error {
 	}
 	r.Prios = mgr.prios
 	r.EnabledCalls = mgr.enabledSyscalls
+	r.Descriptions = mgr.cfg.ParsedDescriptions
+	r.Quarantined = mgr.quarantinedCalls()
+	r.Targets = targets
 	r.NeedCheck = !mgr.vmChecked
 	r.MaxSignal = make([]uint32, 0, len(mgr.maxSignal))
 	for s := range mgr.maxSignal {
 		r.MaxSignal = append(r.MaxSignal, s)
 	}
 	f.newMaxSignal = nil
-	for i := 0; i < mgr.cfg.Procs && len(mgr.candidates) > 0; i++ {
-		last := len(mgr.candidates) - 1
-		r.Candidates = append(r.Candidates, mgr.candidates[last])
-		mgr.candidates = mgr.candidates[:last]
-	}
-	if len(mgr.candidates) == 0 {
-		mgr.candidates = nil
-	}
+	r.Candidates = mgr.takeCandidates(f, mgr.cfg.Procs)
 	return nil
 }
 
@@ -775,7 +814,8 @@ func (mgr *Manager) NewInput(a *NewInputArgs, r *int) error {
 				continue
 			}
 			inp := a.RpcInput
-			inp.Cover = nil // Don't send coverage back to all fuzzers.
+			// Don't send coverage back to all fuzzers, only the part they need to score the input.
+			inp.Cover = targets.Filter(a.RpcInput.Cover)
 			f1.inputs = append(f1.inputs, inp)
 		}
 	}
@@ -789,6 +829,18 @@ func (mgr *Manager) Poll(a *PollArgs, r *PollRes) error {
 	for k, v := range a.Stats {
 		mgr.stats[k] += v
 	}
+	for k, v := range a.DescStats {
+		st := mgr.descStats[k]
+		st.Execs += v.Execs
+		st.NewSignal += v.NewSignal
+		mgr.descStats[k] = st
+	}
+	for k, v := range a.CallStats {
+		st := mgr.callStats[k]
+		st.Merge(v)
+		mgr.callStats[k] = st
+	}
+	mgr.noteHangs(a.Hangs)
 
 	f := mgr.fuzzers[a.Name]
 	if f == nil {
@@ -808,6 +860,8 @@ func (mgr *Manager) Poll(a *PollArgs, r *PollRes) error {
 		}
 		f1.newMaxSignal = append(f1.newMaxSignal, newMaxSignal...)
 	}
+	mgr.triageDone(f, a.TriageDone)
+	r.TriageIDs = mgr.newTriage(f, a.NewTriage)
 	r.MaxSignal = f.newMaxSignal
 	f.newMaxSignal = nil
 	for i := 0; i < 100 && len(f.inputs) > 0; i++ {
@@ -818,15 +872,8 @@ func (mgr *Manager) Poll(a *PollArgs, r *PollRes) error {
 	if len(f.inputs) == 0 {
 		f.inputs = nil
 	}
-
-	for i := 0; i < mgr.cfg.Procs && len(mgr.candidates) > 0; i++ {
-		last := len(mgr.candidates) - 1
-		r.Candidates = append(r.Candidates, mgr.candidates[last])
-		mgr.candidates = mgr.candidates[:last]
-	}
-	if len(mgr.candidates) == 0 {
-		mgr.candidates = nil
-	}
+	r.Candidates = mgr.takeCandidates(f, mgr.cfg.Procs)
+	r.Quarantined = mgr.quarantinedCalls()
 	Logf(2, "poll from %v: recv maxsignal=%v, send maxsignal=%v candidates=%v inputs=%v",
 		a.Name, len(a.MaxSignal), len(r.MaxSignal), len(r.Candidates), len(r.NewInputs))
 	return nil
@@ -835,7 +882,7 @@ func (mgr *Manager) Poll(a *PollArgs, r *PollRes) error {
 func (mgr *Manager) hubSync() {
 	mgr.mu.Lock()
 	defer mgr.mu.Unlock()
-	if !mgr.vmChecked || len(mgr.candidates) != 0 {
+	if !mgr.vmChecked || mgr.numUntriaged() != 0 {
 		return
 	}
 
@@ -912,7 +959,7 @@ func (mgr *Manager) hubSync() {
 			dropped++
 			continue
 		}
-		mgr.candidates = append(mgr.candidates, RpcCandidate{
+		mgr.queueCandidate(RpcCandidate{
 			Prog:      inp,
 			Minimized: false, // don't trust programs from hub
 		})
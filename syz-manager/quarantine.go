@@ -0,0 +1,179 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sort"
+	"time"
+
+	. "github.com/google/syzkaller/log"
+	"github.com/google/syzkaller/prog"
+	. "github.com/google/syzkaller/rpctype"
+)
+
+// Calls that cause hangs are temporarily quarantined: fuzzers remove them from choice tables.
+// Hangs are attributed to calls from two sources: fuzzers report calls that hanged
+// programs that were killed, and manager attributes "no output" VM losses
+// to calls of the last programs that procs of the VM sent to manager before execution.
+// A call is quarantined if it has enough hangs relative to the number of its executions
+// (so that calls present in every program like mmap are not blamed). A single event
+// (a hanged program or a VM loss) is never enough, and calls need enough executions
+// for the ratio to be meaningful (e.g. a VM loss right after manager start is not).
+
+const (
+	quarantineMinHangs     = 3         // minimal number of hangs to quarantine a call
+	quarantineMinEvents    = 2         // minimal number of independent hang events (hanged programs and VM losses)
+	quarantineMinExecs     = 100       // minimal number of call executions to quarantine the call
+	quarantineRatio        = 100       // at least one of quarantineRatio executions must hang
+	quarantineVMLossWeight = 3         // a VM loss counts as this many hangs
+	quarantinePeriod       = time.Hour // doubles every time the call is quarantined again
+	quarantineMaxPrograms  = 5         // programs of VM losses kept as evidence
+)
+
+type HangStat struct {
+	Hangs    uint64   // hanged programs with the call reported by fuzzers
+	VMLosses uint64   // "no output" VM losses with the call among suspects (see noteVMLoss)
+	Programs [][]byte // last programs of VM losses with the call
+}
+
+type Quarantine struct {
+	Since    time.Time
+	Until    time.Time
+	Times    int      // number of times the call was quarantined
+	Execs    uint64   // number of call executions when the call was quarantined
+	Evidence HangStat // hangs that caused the last quarantine
+}
+
+// noteHangs accounts hanged programs reported by a fuzzer.
+func (mgr *Manager) noteHangs(hangs map[string]uint64) {
+	for call, n := range hangs {
+		mgr.hangStat(call).Hangs += n
+		mgr.checkQuarantine(call)
+	}
+}
+
+// noteVMLoss attributes a "no output" VM loss to calls of the last programs of the VM procs.
+// Which call hanged is unknown, so suspects of every proc are calls of its last executor request
+// that the proc did not execute in earlier programs: the earlier programs did not lose the VM,
+// so e.g. mmap present in every program is not blamed. If the last request has no such calls,
+// all of its calls are suspects.
+func (mgr *Manager) noteVMLoss(recent RecentProgSet) {
+	calls := make(map[string][]byte) // suspects and programs they come from
+	for _, progs := range recent {
+		for name, data := range vmLossSuspects(progs) {
+			calls[name] = data
+		}
+	}
+	for name, data := range calls {
+		st := mgr.hangStat(name)
+		st.VMLosses++
+		st.Programs = append(st.Programs, data)
+		if len(st.Programs) > quarantineMaxPrograms {
+			st.Programs = st.Programs[1:]
+		}
+	}
+	for call := range calls {
+		mgr.checkQuarantine(call)
+	}
+}
+
+// vmLossSuspects returns suspect calls for a VM loss given recent programs of a proc
+// (see noteVMLoss) along with programs they come from.
+func vmLossSuspects(progs []RecentProg) map[string][]byte {
+	if len(progs) == 0 {
+		return nil
+	}
+	// Programs of one request have consecutive sequence numbers.
+	last := len(progs) - 1
+	for last > 0 && progs[last-1].Seq+1 == progs[last].Seq {
+		last--
+	}
+	executed := make(map[string]bool)
+	for _, rp := range progs[:last] {
+		for _, name := range progCalls(rp.Prog) {
+			executed[name] = true
+		}
+	}
+	all := make(map[string][]byte)
+	suspects := make(map[string][]byte)
+	for _, rp := range progs[last:] {
+		for _, name := range progCalls(rp.Prog) {
+			all[name] = rp.Prog
+			if !executed[name] {
+				suspects[name] = rp.Prog
+			}
+		}
+	}
+	if len(suspects) == 0 {
+		return all
+	}
+	return suspects
+}
+
+// progCalls returns names of calls of a serialized program.
+func progCalls(data []byte) []string {
+	p, err := prog.Deserialize(data)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, c := range p.Calls {
+		names = append(names, c.Meta.Name)
+	}
+	return names
+}
+
+func (mgr *Manager) hangStat(call string) *HangStat {
+	st := mgr.hangStats[call]
+	if st == nil {
+		st = new(HangStat)
+		mgr.hangStats[call] = st
+	}
+	return st
+}
+
+func (mgr *Manager) checkQuarantine(call string) {
+	q := mgr.quarantine[call]
+	if q != nil && time.Now().Before(q.Until) {
+		return
+	}
+	st := mgr.hangStats[call]
+	hangs := st.Hangs + st.VMLosses*quarantineVMLossWeight
+	execs := mgr.callStats[call].Execs
+	if st.Hangs+st.VMLosses < quarantineMinEvents || execs < quarantineMinExecs ||
+		hangs < quarantineMinHangs || hangs*quarantineRatio < execs {
+		return
+	}
+	if q == nil {
+		q = new(Quarantine)
+		mgr.quarantine[call] = q
+	}
+	period := quarantinePeriod << uint(q.Times)
+	if q.Times > 4 {
+		period = quarantinePeriod << 4
+	}
+	q.Times++
+	q.Since = time.Now()
+	q.Until = q.Since.Add(period)
+	q.Execs = execs
+	q.Evidence = *st
+	// The call needs new evidence to be quarantined again after release.
+	delete(mgr.hangStats, call)
+	mgr.stats["quarantines"]++
+	Logf(0, "quarantining %v for %v: %v hangs, %v vm losses, %v executions",
+		call, period, st.Hangs, st.VMLosses, execs)
+}
+
+// quarantinedCalls returns names of currently quarantined calls.
+func (mgr *Manager) quarantinedCalls() []string {
+	var calls []string
+	now := time.Now()
+	for call, q := range mgr.quarantine {
+		if now.Before(q.Until) {
+			calls = append(calls, call)
+		}
+	}
+	sort.Strings(calls)
+	return calls
+}
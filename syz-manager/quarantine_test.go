@@ -0,0 +1,92 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+
+	. "github.com/google/syzkaller/rpctype"
+)
+
+func TestVMLossSuspects(t *testing.T) {
+	tests := []struct {
+		progs    []RecentProg
+		suspects []string
+	}{
+		// Calls executed by earlier requests are not suspects.
+		{
+			progs: []RecentProg{
+				{Seq: 1, Prog: []byte("getpid()\nsched_yield()\n")},
+				{Seq: 5, Prog: []byte("getpid()\npause()\n")},
+				{Seq: 6, Prog: []byte("getpid()\ngetuid()\n")},
+			},
+			suspects: []string{"pause", "getuid"},
+		},
+		// All calls of the last request were executed earlier.
+		{
+			progs: []RecentProg{
+				{Seq: 1, Prog: []byte("getpid()\npause()\n")},
+				{Seq: 3, Prog: []byte("pause()\n")},
+			},
+			suspects: []string{"pause"},
+		},
+		// The only request.
+		{
+			progs: []RecentProg{
+				{Seq: 1, Prog: []byte("getpid()\n")},
+				{Seq: 2, Prog: []byte("pause()\n")},
+			},
+			suspects: []string{"getpid", "pause"},
+		},
+		{
+			progs:    nil,
+			suspects: nil,
+		},
+	}
+	for i, test := range tests {
+		got := vmLossSuspects(test.progs)
+		if len(got) != len(test.suspects) {
+			t.Errorf("#%v: got suspects %v, want %v", i, got, test.suspects)
+			continue
+		}
+		for _, name := range test.suspects {
+			if got[name] == nil {
+				t.Errorf("#%v: got suspects %v, want %v", i, got, test.suspects)
+				break
+			}
+		}
+	}
+}
+
+func TestCheckQuarantine(t *testing.T) {
+	tests := []struct {
+		hangs    uint64
+		vmLosses uint64
+		execs    uint64
+		quar     bool
+	}{
+		{0, 1, 0, false},     // a single VM loss before the first poll
+		{0, 1, 200, false},   // a single VM loss
+		{1, 0, 200, false},   // a single hang
+		{0, 2, 50, false},    // not enough executions
+		{0, 2, 500, true},    // two VM losses
+		{3, 0, 300, true},    // three hangs
+		{2, 0, 150, false},   // not enough hangs
+		{3, 0, 1000, false},  // hangs are rare
+		{5, 1, 800, true},    // hangs and VM losses
+		{1, 1, 10000, false}, // hangs are rare
+	}
+	for i, test := range tests {
+		mgr := &Manager{
+			stats:      make(map[string]uint64),
+			callStats:  map[string]CallStat{"pause": {Execs: test.execs}},
+			hangStats:  map[string]*HangStat{"pause": {Hangs: test.hangs, VMLosses: test.vmLosses}},
+			quarantine: make(map[string]*Quarantine),
+		}
+		mgr.checkQuarantine("pause")
+		if quar := len(mgr.quarantinedCalls()) != 0; quar != test.quar {
+			t.Errorf("#%v: quarantined %v, want %v", i, quar, test.quar)
+		}
+	}
+}
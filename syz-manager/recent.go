@@ -0,0 +1,31 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	. "github.com/google/syzkaller/log"
+	. "github.com/google/syzkaller/rpctype"
+)
+
+// Fuzzers send programs to manager before executing them (see syz-fuzzer/recent.go).
+// Manager keeps the last RecentProgsPerProc programs of every proc and attaches them
+// to crashes of the fuzzer's VM: they are saved as progsN next to logN in the crash dir
+// and are used by repro along with programs parsed from the console log.
+
+func (mgr *Manager) Recent(a *RecentArgs, r *int) error {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	f := mgr.fuzzers[a.Name]
+	if f == nil {
+		// A request that was in flight when the VM was lost can arrive after that.
+		Logf(1, "dropping recent programs from fuzzer %v that is not connected", a.Name)
+		return nil
+	}
+	if f.recent == nil {
+		f.recent = make(RecentProgSet)
+	}
+	f.recent.Add(a.Progs)
+	return nil
+}
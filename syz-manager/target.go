@@ -0,0 +1,147 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/syzkaller/cover"
+	. "github.com/google/syzkaller/log"
+	"github.com/google/syzkaller/symbolizer"
+)
+
+// Directed fuzzing: target functions and files from config are resolved to PC ranges in vmlinux.
+// Fuzzers give more energy to inputs that cover PCs in or near the target ranges
+// (see cover.Targets.Score), and /cover page shows coverage of the targets.
+
+type targetInfo struct {
+	name   string // function or file name from config
+	ranges cover.Targets
+}
+
+var (
+	targets      cover.Targets // union of all target ranges
+	targetInfos  []*targetInfo
+	targetsReady = make(chan bool)
+)
+
+func initTargets(vmlinux string, funcs, files []string) {
+	if len(funcs) == 0 && len(files) == 0 {
+		close(targetsReady)
+		return
+	}
+	// Running nm and addr2line on vmlinux takes some time, so we do it asynchronously on start.
+	go func() {
+		defer close(targetsReady)
+		infos, err := resolveTargets(vmlinux, funcs, files)
+		if err != nil {
+			Logf(0, "failed to resolve fuzzing targets: %v", err)
+			return
+		}
+		var all []cover.PCRange
+		for _, info := range infos {
+			if len(info.ranges) == 0 {
+				Logf(0, "fuzzing target %v is not found in %v", info.name, vmlinux)
+			}
+			all = append(all, info.ranges...)
+		}
+		targetInfos = infos
+		targets = cover.MakeTargets(all)
+		Logf(0, "resolved %v fuzzing targets to %v PC ranges", len(infos), len(targets))
+	}()
+}
+
+func resolveTargets(vmlinux string, funcs, files []string) ([]*targetInfo, error) {
+	symbols, err := symbolizer.ReadSymbols(vmlinux)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run nm on vmlinux: %v", err)
+	}
+	symbolRange := func(s symbolizer.Symbol) cover.PCRange {
+		return cover.PCRange{Start: uint32(s.Addr), End: uint32(s.Addr + uint64(s.Size))}
+	}
+	var infos []*targetInfo
+	for _, name := range funcs {
+		info := &targetInfo{name: name}
+		for _, s := range symbols[name] {
+			info.ranges = append(info.ranges, symbolRange(s))
+		}
+		infos = append(infos, info)
+	}
+	if len(files) == 0 {
+		return infos, nil
+	}
+
+	// Find source files of all functions by symbolizing their start addresses.
+	var addrs []uint64
+	addrSymbols := make(map[uint64]symbolizer.Symbol)
+	for _, ss := range symbols {
+		for _, s := range ss {
+			addrs = append(addrs, s.Addr)
+			addrSymbols[s.Addr] = s
+		}
+	}
+	sort.Sort(uint64Array(addrs))
+	symb := symbolizer.NewSymbolizer()
+	defer symb.Close()
+	frames, err := symb.SymbolizeArray(vmlinux, addrs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to symbolize vmlinux: %v", err)
+	}
+	fileInfos := make(map[string]*targetInfo)
+	for _, name := range files {
+		info := &targetInfo{name: name}
+		fileInfos[name] = info
+		infos = append(infos, info)
+	}
+	for _, frame := range frames {
+		if frame.Inline {
+			continue
+		}
+		for name, info := range fileInfos {
+			if matchFile(frame.File, name) {
+				info.ranges = append(info.ranges, symbolRange(addrSymbols[frame.PC]))
+			}
+		}
+	}
+	for _, info := range infos {
+		info.ranges = cover.MakeTargets(info.ranges)
+	}
+	return infos, nil
+}
+
+// matchFile returns true if source file path (as reported by addr2line) is the target file name.
+// Name matches whole trailing path components, so "net/ipv4/tcp.c" matches
+// "/src/linux/net/ipv4/tcp.c", but "tcp.c" does not match "net/ipv4/mptcp.c".
+func matchFile(path, name string) bool {
+	return path == name || strings.HasSuffix(path, "/"+name)
+}
+
+// targetProgress returns coverage progress on the targets: number of covered PCs in cov
+// and total number of coverage points in each target.
+func targetProgress(cov cover.Cover) []*templateTarget {
+	<-targetsReady
+	if len(targetInfos) == 0 {
+		return nil
+	}
+	<-allCoverReady
+	var res []*templateTarget
+	for _, info := range targetInfos {
+		t := &templateTarget{Name: info.name}
+		for _, pc := range allCoverPCs {
+			// Coverage contains return addresses of coverage callbacks.
+			if info.ranges.Contains(uint32(pc + callLen)) {
+				t.Total++
+			}
+		}
+		for _, pc := range cov {
+			if info.ranges.Contains(pc) {
+				t.Covered++
+			}
+		}
+		res = append(res, t)
+	}
+	return res
+}
@@ -0,0 +1,30 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+)
+
+func TestMatchFile(t *testing.T) {
+	tests := []struct {
+		path  string
+		name  string
+		match bool
+	}{
+		{"/src/linux/net/ipv4/tcp.c", "net/ipv4/tcp.c", true},
+		{"/src/linux/net/ipv4/tcp.c", "tcp.c", true},
+		{"/src/linux/net/ipv4/tcp.c", "/src/linux/net/ipv4/tcp.c", true},
+		{"net/ipv4/tcp.c", "net/ipv4/tcp.c", true},
+		{"/src/linux/net/mptcp/mptcp.c", "tcp.c", false},
+		{"/src/linux/net/ipv4/tcp.c", "ipv4/tcp", false},
+		{"/src/linux/net/ipv4/tcp.c", "4/tcp.c", false},
+		{"/src/linux/net/ipv4/tcp.c", "net/ipv6/tcp.c", false},
+	}
+	for _, test := range tests {
+		if match := matchFile(test.path, test.name); match != test.match {
+			t.Errorf("matchFile(%q, %q) = %v, want %v", test.path, test.name, match, test.match)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2017 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	. "github.com/google/syzkaller/log"
+	. "github.com/google/syzkaller/rpctype"
+)
+
+// Manager owns all untriaged inputs: candidates (programs from persistent corpus and hub)
+// and programs with new signal found by fuzzers. Each input has a unique ID.
+// An input handed out to a fuzzer stays in flight until the fuzzer acknowledges it
+// in PollArgs.TriageDone. If the fuzzer is lost (VM crashed or was restarted),
+// its inputs in flight are returned to the queue and handed out to another fuzzer.
+
+// Inputs that were in flight on maxTriageCrashes crashed VMs are dropped,
+// they most likely crash the kernel themselves.
+const maxTriageCrashes = 3
+
+// queueCandidate adds an untriaged input to the queue.
+func (mgr *Manager) queueCandidate(c RpcCandidate) {
+	mgr.lastCandidateID++
+	c.ID = mgr.lastCandidateID
+	mgr.candidates = append(mgr.candidates, c)
+}
+
+// takeCandidates hands out up to n queued inputs to fuzzer f.
+func (mgr *Manager) takeCandidates(f *Fuzzer, n int) []RpcCandidate {
+	var res []RpcCandidate
+	for i := 0; i < n && len(mgr.candidates) > 0; i++ {
+		last := len(mgr.candidates) - 1
+		c := mgr.candidates[last]
+		mgr.candidates = mgr.candidates[:last]
+		f.inflight[c.ID] = c
+		res = append(res, c)
+	}
+	if len(mgr.candidates) == 0 {
+		mgr.candidates = nil
+	}
+	return res
+}
+
+// fuzzerLost returns inputs in flight on fuzzer f to the queue.
+// crashed says if the fuzzer VM crashed (as opposed to a normal restart).
+func (mgr *Manager) fuzzerLost(f *Fuzzer, crashed bool) {
+	requeued, dropped := 0, 0
+	for id, c := range f.inflight {
+		delete(f.inflight, id)
+		if crashed {
+			mgr.triageCrashes[id]++
+			if mgr.triageCrashes[id] >= maxTriageCrashes {
+				delete(mgr.triageCrashes, id)
+				dropped++
+				continue
+			}
+		}
+		mgr.candidates = append(mgr.candidates, c)
+		requeued++
+	}
+	mgr.stats["triage requeued"] += uint64(requeued)
+	mgr.stats["triage dropped"] += uint64(dropped)
+	if requeued != 0 || dropped != 0 {
+		Logf(0, "%v: lost fuzzer, requeued %v inputs, dropped %v", f.name, requeued, dropped)
+	}
+}
+
+// numUntriaged returns number of queued and in flight inputs.
+func (mgr *Manager) numUntriaged() int {
+	n := len(mgr.candidates)
+	for _, f := range mgr.fuzzers {
+		n += len(f.inflight)
+	}
+	return n
+}
+
+// newTriage registers inputs found by fuzzer f (PollArgs.NewTriage) as in flight on f
+// and returns their IDs.
+func (mgr *Manager) newTriage(f *Fuzzer, inputs []RpcCandidate) []uint64 {
+	var ids []uint64
+	for _, c := range inputs {
+		mgr.lastCandidateID++
+		c.ID = mgr.lastCandidateID
+		f.inflight[c.ID] = c
+		ids = append(ids, c.ID)
+	}
+	return ids
+}
+
+// triageDone removes inputs triaged by fuzzer f (PollArgs.TriageDone) from flight.
+func (mgr *Manager) triageDone(f *Fuzzer, ids []uint64) {
+	for _, id := range ids {
+		// The input is unknown if the fuzzer was considered lost and the input was requeued.
+		delete(f.inflight, id)
+		delete(mgr.triageCrashes, id)
+	}
+}
@@ -13,15 +13,19 @@ import (
 	"io/ioutil"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/google/syzkaller/cover"
+	"github.com/google/syzkaller/host"
 	"github.com/google/syzkaller/ipc"
+	"github.com/google/syzkaller/ipc/ipcconfig"
 	. "github.com/google/syzkaller/log"
 	"github.com/google/syzkaller/prog"
+	"github.com/google/syzkaller/sys"
 )
 
 var (
@@ -30,6 +34,8 @@ var (
 	flagRepeat    = flag.Int("repeat", 1, "repeat execution that many times (0 for infinite loop)")
 	flagProcs     = flag.Int("procs", 1, "number of parallel processes to execute programs")
 	flagOutput    = flag.String("output", "none", "write programs to none/stdout")
+	flagLeak      = flag.Bool("leak", false, "detect memory leaks with kmemleak")
+	flagDescs     = flag.String("descriptions", "", "comma-separated list of description files/dirs to use instead of compiled-in descriptions")
 )
 
 func main() {
@@ -40,6 +46,16 @@ func main() {
 		os.Exit(1)
 	}
 
+	if *flagDescs != "" {
+		files, err := sys.ReadDescriptions(strings.Split(*flagDescs, ","))
+		if err != nil {
+			Fatalf("%v", err)
+		}
+		if err := sys.LoadDescriptions(files); err != nil {
+			Fatalf("failed to load descriptions: %v", err)
+		}
+	}
+
 	var progs []*prog.Prog
 	for _, fn := range flag.Args() {
 		data, err := ioutil.ReadFile(fn)
@@ -56,7 +72,7 @@ func main() {
 		return
 	}
 
-	flags, timeout, err := ipc.DefaultFlags()
+	flags, timeout, err := ipcconfig.Default()
 	if err != nil {
 		Fatalf("%v", err)
 	}
@@ -74,14 +90,30 @@ func main() {
 			handled[call.Meta.CallName] = true
 		}
 	}
-	if handled["syz_emit_ethernet"] {
+	if handled["syz_emit_ethernet"] || handled["syz_extract_tcp_res"] {
 		flags |= ipc.FlagEnableTun
 	}
 
+	var leakCallback func()
+	if *flagLeak {
+		if err := host.KmemleakInit(true); err != nil {
+			Fatalf("%v", err)
+		}
+		leakCallback = func() {
+			leaks, err := host.KmemleakScan(true)
+			if err != nil {
+				Fatalf("%v", err)
+			}
+			if leaks != nil {
+				fmt.Printf("BUG: memory leak:\n%s\n", leaks)
+			}
+		}
+	}
+
 	var wg sync.WaitGroup
 	wg.Add(*flagProcs)
 	var posMu, logMu sync.Mutex
-	gate := ipc.NewGate(2**flagProcs, nil)
+	gate := ipc.NewGate(2**flagProcs, leakCallback)
 	var pos int
 	var lastPrint time.Time
 	var shutdown uint32
@@ -168,4 +200,11 @@ func main() {
 	}()
 
 	wg.Wait()
+	if leakCallback != nil && atomic.LoadUint32(&shutdown) == 0 {
+		// The gate scans right after the last programs, when leaked objects are too young
+		// to be reported (e.g. with -repeat=1 the only scan happens right after the program).
+		// Wait until they are old enough and scan again (KmemleakScan scans twice).
+		time.Sleep(host.KmemleakMinAge)
+		leakCallback()
+	}
 }
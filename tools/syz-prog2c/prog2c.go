@@ -18,7 +18,7 @@ var (
 	flagCollide  = flag.Bool("collide", false, "create collide program")
 	flagRepeat   = flag.Bool("repeat", false, "repeat program infinitely or not")
 	flagProcs    = flag.Int("procs", 4, "number of parallel processes")
-	flagSandbox  = flag.String("sandbox", "none", "sandbox to use (none, setuid, namespace)")
+	flagSandbox  = flag.String("sandbox", "none", "sandbox to use (none, setuid, namespace, seccomp)")
 	flagProg     = flag.String("prog", "", "file with program to convert (required)")
 )
 
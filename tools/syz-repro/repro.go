@@ -26,6 +26,7 @@ import (
 var (
 	flagConfig = flag.String("config", "", "configuration file")
 	flagCount  = flag.Int("count", 0, "number of VMs to use (overrides config count param)")
+	flagProgs  = flag.String("progs", "", "file with programs reported by fuzzer (progsN file in crash dir)")
 )
 
 func main() {
@@ -48,6 +49,13 @@ func main() {
 	if err != nil {
 		Fatalf("failed to open log file: %v", err)
 	}
+	var progs []byte
+	if *flagProgs != "" {
+		progs, err = ioutil.ReadFile(*flagProgs)
+		if err != nil {
+			Fatalf("failed to open programs file: %v", err)
+		}
+	}
 	vmIndexes := make([]int, cfg.Count)
 	for i := range vmIndexes {
 		vmIndexes[i] = i
@@ -63,7 +71,7 @@ func main() {
 		Fatalf("terminating")
 	}()
 
-	res, err := repro.Run(data, cfg, vmIndexes)
+	res, err := repro.Run(data, progs, cfg, vmIndexes)
 	if err != nil {
 		Logf(0, "reproduction failed: %v", err)
 	}
@@ -17,6 +17,7 @@ import (
 	"github.com/google/syzkaller/db"
 	"github.com/google/syzkaller/host"
 	"github.com/google/syzkaller/ipc"
+	"github.com/google/syzkaller/ipc/ipcconfig"
 	. "github.com/google/syzkaller/log"
 	"github.com/google/syzkaller/prog"
 	"github.com/google/syzkaller/sys"
@@ -29,6 +30,7 @@ var (
 	flagProcs    = flag.Int("procs", 2*runtime.NumCPU(), "number of parallel processes")
 	flagLogProg  = flag.Bool("logprog", false, "print programs before execution")
 	flagGenerate = flag.Bool("generate", true, "generate new programs, otherwise only mutate corpus")
+	flagBatch    = flag.Int("batch", 4, "number of programs executed per executor request")
 
 	failedRe = regexp.MustCompile("runtime error: |panic: |Panic: ")
 
@@ -40,6 +42,9 @@ const programLength = 30
 
 func main() {
 	flag.Parse()
+	if *flagBatch < 1 || *flagBatch > ipc.MaxBatch {
+		Fatalf("-batch flag must be in [1, %v]", ipc.MaxBatch)
+	}
 	corpus := readCorpus()
 	Logf(0, "parsed %v programs", len(corpus))
 	if !*flagGenerate && len(corpus) == 0 {
@@ -50,7 +55,7 @@ func main() {
 	prios := prog.CalculatePriorities(corpus)
 	ct := prog.BuildChoiceTable(prios, calls)
 
-	flags, timeout, err := ipc.DefaultFlags()
+	flags, timeout, err := ipcconfig.Default()
 	if err != nil {
 		Fatalf("%v", err)
 	}
@@ -64,19 +69,25 @@ func main() {
 			}
 			rs := rand.NewSource(time.Now().UnixNano() + int64(pid)*1e12)
 			rnd := rand.New(rs)
+			var batch []*prog.Prog
 			for i := 0; ; i++ {
 				var p *prog.Prog
 				if *flagGenerate && len(corpus) == 0 || i%4 != 0 {
 					p = prog.Generate(rs, programLength, ct)
-					execute(pid, env, p)
+					batch = append(batch, p.Clone())
 					p.Mutate(rs, programLength, ct, corpus)
-					execute(pid, env, p)
+					batch = append(batch, p)
 				} else {
 					p = corpus[rnd.Intn(len(corpus))].Clone()
 					p.Mutate(rs, programLength, ct, corpus)
-					execute(pid, env, p)
+					batch = append(batch, p.Clone())
 					p.Mutate(rs, programLength, ct, corpus)
-					execute(pid, env, p)
+					batch = append(batch, p)
+				}
+				// Programs are added in pairs, so the batch can overflow by one.
+				for len(batch) >= *flagBatch {
+					execute(pid, env, batch[:*flagBatch])
+					batch = batch[*flagBatch:]
 				}
 			}
 		}()
@@ -88,26 +99,35 @@ func main() {
 
 var outMu sync.Mutex
 
-func execute(pid int, env *ipc.Env, p *prog.Prog) {
+func execute(pid int, env *ipc.Env, progs []*prog.Prog) {
 	if *flagExecutor == "" {
 		return
 	}
-	atomic.AddUint64(&statExec, 1)
 	if *flagLogProg {
 		ticket := gate.Enter()
 		defer gate.Leave(ticket)
 		outMu.Lock()
-		fmt.Printf("executing program %v\n%s\n", pid, p.Serialize())
+		for _, p := range progs {
+			fmt.Printf("executing program %v\n%s\n", pid, p.Serialize())
+		}
 		outMu.Unlock()
 	}
 
-	output, _, failed, hanged, err := env.Exec(p, false, false)
+	output, _, failed, hanged, killed, err := env.ExecBatch(progs, false, false)
+	executed := len(progs)
+	if killed != -1 {
+		// Programs after the killed one were not executed.
+		executed = killed + 1
+	}
+	atomic.AddUint64(&statExec, uint64(executed))
 	if err != nil {
 		fmt.Printf("failed to execute executor: %v\n", err)
 	}
 	paniced := failedRe.Match(output)
 	if failed || hanged || paniced || err != nil {
-		fmt.Printf("PROGRAM:\n%s\n", p.Serialize())
+		for _, p := range progs {
+			fmt.Printf("PROGRAM:\n%s\n", p.Serialize())
+		}
 	}
 	if failed || hanged || paniced || err != nil || *flagOutput {
 		os.Stdout.Write(output)